@@ -0,0 +1,123 @@
+package checksum
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// archiveSeparator joins the path of an archive and the name of a member
+// inside it, e.g. "bundle.zip!/dir/file.txt".
+const archiveSeparator = "!/"
+
+// isArchive reports whether the file name has an extension that archive
+// mode can descend into. A plain .gz file compresses a single file and is
+// hashed as it is.
+func isArchive(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range []string{".zip", ".tar", ".tgz", ".tar.gz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitArchivePath splits a virtual member path into the archive path and
+// the member name. ok is false for ordinary paths.
+func splitArchivePath(p string) (archivePath, member string, ok bool) {
+	archivePath, member, ok = strings.Cut(p, archiveSeparator)
+	return archivePath, member, ok
+}
+
+// hashArchive hashes every regular member of the archive at filePath and
+// returns a map of virtual path (relPath!/member) to content hash, together
+// with the number of uncompressed bytes read.
+func hashArchive(ctx context.Context, filePath, relPath, algorithm string) (map[string]string, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	members := make(map[string]string)
+	var total int64
+	add := func(name string, r io.Reader) error {
+		hash := NewHash(algorithm)
+		n, err := io.Copy(hash, contextReader{ctx, r})
+		total += n
+		if err != nil {
+			return fmt.Errorf("could not read archive member '%s': %w", name, err)
+		}
+		name = strings.TrimPrefix(path.Clean("/"+name), "/")
+		members[relPath+archiveSeparator+name] = fmt.Sprintf("%x", hash.Sum(nil))
+		return nil
+	}
+
+	lower := strings.ToLower(filePath)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		info, err := file.Stat()
+		if err != nil {
+			return nil, 0, err
+		}
+		zr, err := zip.NewReader(file, info.Size())
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, total, err
+			}
+			err = add(f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return nil, total, err
+			}
+		}
+	case strings.HasSuffix(lower, ".tar"):
+		if err := hashTarMembers(tar.NewReader(file), add); err != nil {
+			return nil, total, err
+		}
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer gz.Close()
+		if err := hashTarMembers(tar.NewReader(gz), add); err != nil {
+			return nil, total, err
+		}
+	default:
+		return nil, 0, fmt.Errorf("unsupported archive '%s'", filePath)
+	}
+	return members, total, nil
+}
+
+func hashTarMembers(tr *tar.Reader, add func(name string, r io.Reader) error) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := add(header.Name, tr); err != nil {
+			return err
+		}
+	}
+}
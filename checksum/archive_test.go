@@ -0,0 +1,166 @@
+package checksum
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"md5checker/checksum/checksumtest"
+)
+
+// archiveMembers are the members written to every test archive.
+var archiveMembers = map[string]string{
+	"top.txt":          "top",
+	"dir/inner.txt":    "inner",
+	"./dir/sub/x.json": "{}",
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func zipArchive(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if _, err := zw.Create("dir/"); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range archiveMembers {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarArchive(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "top.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range archiveMembers {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestIsArchive(t *testing.T) {
+	tests := map[string]bool{
+		"a.zip":      true,
+		"a.ZIP":      true,
+		"a.tar":      true,
+		"a.tgz":      true,
+		"a.tar.gz":   true,
+		"access.gz":  false,
+		"a.log.gz":   false,
+		"a.txt":      false,
+		"zip":        false,
+		"a.tar.bz2":  false,
+		"a.tar.gz.1": false,
+	}
+	for name, want := range tests {
+		if got := isArchive(name); got != want {
+			t.Errorf("isArchive(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestHashArchive(t *testing.T) {
+	dir := t.TempDir()
+	archives := map[string][]byte{
+		"bundle.zip":    zipArchive(t),
+		"bundle.tar":    tarArchive(t),
+		"bundle.tgz":    gzipData(t, tarArchive(t)),
+		"bundle.tar.gz": gzipData(t, tarArchive(t)),
+	}
+	for name, data := range archives {
+		t.Run(name, func(t *testing.T) {
+			filePath := filepath.Join(dir, name)
+			if err := os.WriteFile(filePath, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			relPath := filepath.Join("sub", name)
+			members, _, err := hashArchive(t.Context(), filePath, relPath, "md5")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Directories and links are left out, names are cleaned
+			want := map[string]string{
+				relPath + "!/top.txt":        md5Hex("top"),
+				relPath + "!/dir/inner.txt":  md5Hex("inner"),
+				relPath + "!/dir/sub/x.json": md5Hex("{}"),
+			}
+			if !reflect.DeepEqual(members, want) {
+				t.Errorf("members = %v, want %v", members, want)
+			}
+		})
+	}
+}
+
+func TestArchiveFallbackWarning(t *testing.T) {
+	root := checksumtest.WriteTree(t, map[string]string{"broken.zip": "not a zip file"})
+	if err := os.WriteFile(filepath.Join(root, "good.zip"), zipArchive(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "log.gz"), gzipData(t, []byte("log line")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Root: root, Archives: true}
+	checksumDB := make(Database)
+	summary := scanTestTree(t, opts, checksumDB)
+
+	if len(summary.Warnings) != 1 || summary.Warnings[0].Path != "broken.zip" {
+		t.Errorf("add warned about %v, want broken.zip", summary.Warnings)
+	}
+	want := []string{"broken.zip", "good.zip!/dir/inner.txt", "good.zip!/dir/sub/x.json", "good.zip!/top.txt", "log.gz"}
+	if got := sortedPaths(checksumDB); !reflect.DeepEqual(got, want) {
+		t.Errorf("database paths = %v, want %v", got, want)
+	}
+
+	if err := checksumDB.Save(filepath.Join(root, DatabaseFileName), nil); err != nil {
+		t.Fatal(err)
+	}
+	report := verifyTestTree(t, opts)
+	if len(report.Warnings) != 1 || report.Warnings[0].Path != "broken.zip" {
+		t.Errorf("verify warned about %v, want broken.zip", report.Warnings)
+	}
+	if report.Discrepancies() != 0 {
+		t.Errorf("verify found %d discrepancies, want none", report.Discrepancies())
+	}
+}
@@ -0,0 +1,525 @@
+package checksum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Database formats. JSON databases are gzipped JSON, optionally
+// encrypted; binary databases are the compact format below.
+const (
+	FormatJSON   = "json"
+	FormatBinary = "binary"
+)
+
+// A binary database stores digests as raw bytes and timestamps as Unix
+// seconds. It starts with binaryMagic, the digest size and the
+// length-prefixed JSON file selection (length 0 for none), followed by:
+//
+//   - the entries, in the order they were written (by digest when saved
+//     from memory): digest, FirstCreated and
+//     LastContentUpdate as varints, the chunk hashes as length-prefixed
+//     JSON (length 0 for none), the number of paths and for every path its
+//     length-prefixed name, FirstSeen, LastSeen and length-prefixed JSON
+//     metadata;
+//   - the hash index: every digest with the offset of its entry;
+//   - the path index, sorted by path: the offset of every path name
+//     within the entries and the offset of its entry;
+//   - the footer, see binaryFooter.
+//
+// The two indexes have fixed-size records, so a single path or hash is
+// found by binary search without reading the rest of the file.
+const (
+	binaryMagic       = "MD5CBIN2"
+	binaryMagicV1     = "MD5CBIN1" // without the file selection
+	binaryFooterMagic = "MD5CEND1"
+	binaryFooterSize  = 5 * 8
+)
+
+// errBinaryEncrypted is returned when an encrypted database would be saved
+// in the binary format, which only JSON databases support.
+var errBinaryEncrypted = errors.New("the binary format cannot be encrypted, encrypted databases are stored as JSON")
+
+// binaryFooter ends a binary database with the offsets of its sections.
+type binaryFooter struct {
+	hashIndex  uint64
+	pathIndex  uint64
+	entries    uint64
+	paths      uint64
+	digestSize int
+	// headerSize and selection come from the header
+	headerSize uint64
+	selection  []byte
+}
+
+// isBinary reports whether the data read by r is a binary database.
+func isBinary(r *bufio.Reader) bool {
+	magic, err := r.Peek(len(binaryMagic))
+	return err == nil && (string(magic) == binaryMagic || string(magic) == binaryMagicV1)
+}
+
+// DetectFormat returns the format of the database file.
+func DetectFormat(checksumFilePath string) (string, error) {
+	f, err := os.Open(checksumFilePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if isBinary(bufio.NewReader(f)) {
+		return FormatBinary, nil
+	}
+	return FormatJSON, nil
+}
+
+func packTime(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp '%s'", s)
+	}
+	return t.Unix(), nil
+}
+
+func unpackTime(v int64) string {
+	if v == 0 {
+		return ""
+	}
+	return time.Unix(v, 0).UTC().Format(time.RFC3339)
+}
+
+// binaryWriter appends to a buffered writer and tracks the offset.
+type binaryWriter struct {
+	w   *bufio.Writer
+	off uint64
+	buf []byte
+}
+
+func (b *binaryWriter) flushBuf() error {
+	n, err := b.w.Write(b.buf)
+	b.off += uint64(n)
+	b.buf = b.buf[:0]
+	return err
+}
+
+func (b *binaryWriter) appendBlob(data []byte) {
+	b.buf = binary.AppendUvarint(b.buf, uint64(len(data)))
+	b.buf = append(b.buf, data...)
+}
+
+func (b *binaryWriter) appendJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b.appendBlob(data)
+	return nil
+}
+
+func (b *binaryWriter) appendTime(s string) error {
+	v, err := packTime(s)
+	b.buf = binary.AppendVarint(b.buf, v)
+	return err
+}
+
+// binaryDatabaseWriter writes a binary database entry by entry. The
+// offsets for the two indexes are sorted externally, so writing a database
+// of any size takes bounded memory.
+type binaryDatabaseWriter struct {
+	bw         *binaryWriter
+	digestSize int
+	entries    uint64
+	hashes     *externalSorter
+	paths      *externalSorter
+	// selection is the encoded file selection written in the header
+	selection []byte
+}
+
+func newBinaryDatabaseWriter(w io.Writer, tempDir string) *binaryDatabaseWriter {
+	return &binaryDatabaseWriter{
+		bw:     &binaryWriter{w: bufio.NewWriterSize(w, 256*1024)},
+		hashes: newExternalSorter(tempDir, defaultSortMemory/2),
+		paths:  newExternalSorter(tempDir, defaultSortMemory/2),
+	}
+}
+
+func packOffset(v uint64) string {
+	return string(binary.LittleEndian.AppendUint64(nil, v))
+}
+
+// writeHeader writes the header, with the digest size of the first entry.
+func (b *binaryDatabaseWriter) writeHeader(digestSize int) error {
+	b.digestSize = digestSize
+	b.bw.buf = append(append(b.bw.buf, binaryMagic...), byte(digestSize))
+	b.bw.appendBlob(b.selection)
+	return b.bw.flushBuf()
+}
+
+// write appends the entry of a content hash.
+func (b *binaryDatabaseWriter) write(hash string, infoData InfoData) error {
+	digest, err := hex.DecodeString(hash)
+	if err != nil || len(digest) == 0 || len(digest) > 255 || (b.digestSize > 0 && len(digest) != b.digestSize) {
+		return fmt.Errorf("invalid content hash '%s'", hash)
+	}
+	if b.digestSize == 0 {
+		if err := b.writeHeader(len(digest)); err != nil {
+			return err
+		}
+	}
+	bw := b.bw
+	entryOffset := bw.off
+	bw.buf = append(bw.buf, digest...)
+	if err := bw.appendTime(infoData.FirstCreated); err != nil {
+		return err
+	}
+	if err := bw.appendTime(infoData.LastContentUpdate); err != nil {
+		return err
+	}
+	if infoData.Chunks == nil {
+		bw.appendBlob(nil)
+	} else if err := bw.appendJSON(infoData.Chunks); err != nil {
+		return err
+	}
+	bw.buf = binary.AppendUvarint(bw.buf, uint64(len(infoData.RelativePaths)))
+	for _, p := range infoData.RelativePaths {
+		pathOffset := bw.off + uint64(len(bw.buf))
+		if err := b.paths.add(sortRecord{Key: p.Path, Value: packOffset(pathOffset), Extra: packOffset(entryOffset)}); err != nil {
+			return err
+		}
+		bw.appendBlob([]byte(p.Path))
+		if err := bw.appendTime(p.FirstSeen); err != nil {
+			return err
+		}
+		if err := bw.appendTime(p.LastSeen); err != nil {
+			return err
+		}
+		if p.Metadata == nil {
+			bw.appendBlob(nil)
+		} else if err := bw.appendJSON(p.Metadata); err != nil {
+			return err
+		}
+	}
+	b.entries++
+	if err := b.hashes.add(sortRecord{Key: string(digest), Value: packOffset(entryOffset)}); err != nil {
+		return err
+	}
+	return bw.flushBuf()
+}
+
+// close writes the indexes and the footer. The writer must be closed even
+// after an error, to remove its temporary files.
+func (b *binaryDatabaseWriter) close() error {
+	defer b.hashes.remove()
+	defer b.paths.remove()
+	bw := b.bw
+	if b.digestSize == 0 {
+		if err := b.writeHeader(16); err != nil {
+			return err
+		}
+	}
+	footer := binaryFooter{hashIndex: bw.off, entries: b.entries}
+	hashes, err := b.hashes.iterate()
+	if err != nil {
+		return err
+	}
+	defer hashes.close()
+	for r, ok := hashes.next(); ok; r, ok = hashes.next() {
+		bw.buf = append(append(bw.buf, r.Key...), r.Value...)
+		if err := bw.flushBuf(); err != nil {
+			return err
+		}
+	}
+	if hashes.err != nil {
+		return hashes.err
+	}
+	footer.pathIndex = bw.off
+	paths, err := b.paths.iterate()
+	if err != nil {
+		return err
+	}
+	defer paths.close()
+	for r, ok := paths.next(); ok; r, ok = paths.next() {
+		bw.buf = append(append(bw.buf, r.Value...), r.Extra...)
+		footer.paths++
+		if err := bw.flushBuf(); err != nil {
+			return err
+		}
+	}
+	if paths.err != nil {
+		return paths.err
+	}
+	for _, v := range []uint64{footer.hashIndex, footer.pathIndex, footer.entries, footer.paths} {
+		bw.buf = binary.LittleEndian.AppendUint64(bw.buf, v)
+	}
+	bw.buf = append(bw.buf, binaryFooterMagic...)
+	if err := bw.flushBuf(); err != nil {
+		return err
+	}
+	return bw.w.Flush()
+}
+
+// readBinaryFooter reads and checks the header and footer of a binary
+// database.
+func readBinaryFooter(f io.ReaderAt, size int64) (binaryFooter, error) {
+	var footer binaryFooter
+	header := make([]byte, len(binaryMagic)+1)
+	data := make([]byte, binaryFooterSize)
+	if size < int64(len(header)+binaryFooterSize) {
+		return footer, fmt.Errorf("binary database is truncated")
+	}
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return footer, err
+	}
+	if _, err := f.ReadAt(data, size-binaryFooterSize); err != nil {
+		return footer, err
+	}
+	magic := string(header[:len(binaryMagic)])
+	if (magic != binaryMagic && magic != binaryMagicV1) || string(data[4*8:]) != binaryFooterMagic {
+		return footer, fmt.Errorf("binary database is damaged or truncated")
+	}
+	values := make([]uint64, 4)
+	for i := range values {
+		values[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	footer = binaryFooter{hashIndex: values[0], pathIndex: values[1], entries: values[2], paths: values[3], digestSize: int(header[len(binaryMagic)])}
+	end := uint64(size - binaryFooterSize)
+	headerSize := uint64(len(header))
+	if magic == binaryMagic {
+		r := bufio.NewReader(io.NewSectionReader(f, int64(headerSize), size-int64(headerSize)))
+		selection, err := readBlob(r)
+		if err != nil {
+			return footer, fmt.Errorf("binary database is damaged: %w", err)
+		}
+		headerSize += uint64(len(binary.AppendUvarint(nil, uint64(len(selection))))) + uint64(len(selection))
+		footer.selection = selection
+	}
+	footer.headerSize = headerSize
+	if footer.hashIndex < headerSize || footer.hashIndex > footer.pathIndex || footer.pathIndex > end ||
+		footer.hashIndex+footer.entries*uint64(footer.digestSize+8) != footer.pathIndex || footer.pathIndex+footer.paths*16 != end {
+		return footer, fmt.Errorf("binary database is damaged")
+	}
+	return footer, nil
+}
+
+func readBlob(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > 1<<30 {
+		return nil, fmt.Errorf("record too large")
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(r, data)
+	return data, err
+}
+
+func readTime(r *bufio.Reader) (string, error) {
+	v, err := binary.ReadVarint(r)
+	return unpackTime(v), err
+}
+
+// readBinaryEntry decodes the entry at the position of r.
+func readBinaryEntry(r *bufio.Reader, digestSize int) (InfoData, error) {
+	var infoData InfoData
+	digest := make([]byte, digestSize)
+	if _, err := io.ReadFull(r, digest); err != nil {
+		return infoData, err
+	}
+	infoData.ContentMD5 = hex.EncodeToString(digest)
+	var err error
+	if infoData.FirstCreated, err = readTime(r); err != nil {
+		return infoData, err
+	}
+	if infoData.LastContentUpdate, err = readTime(r); err != nil {
+		return infoData, err
+	}
+	chunks, err := readBlob(r)
+	if err != nil {
+		return infoData, err
+	}
+	if len(chunks) > 0 {
+		infoData.Chunks = &ChunkInfo{}
+		if err := json.Unmarshal(chunks, infoData.Chunks); err != nil {
+			return infoData, err
+		}
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return infoData, err
+	}
+	infoData.RelativePaths = make([]PathEntry, 0, min(count, 1024))
+	for range count {
+		var p PathEntry
+		path, err := readBlob(r)
+		if err != nil {
+			return infoData, err
+		}
+		p.Path = string(path)
+		if p.FirstSeen, err = readTime(r); err != nil {
+			return infoData, err
+		}
+		if p.LastSeen, err = readTime(r); err != nil {
+			return infoData, err
+		}
+		metadata, err := readBlob(r)
+		if err != nil {
+			return infoData, err
+		}
+		if len(metadata) > 0 {
+			p.Metadata = &FileMetadata{}
+			if err := json.Unmarshal(metadata, p.Metadata); err != nil {
+				return infoData, err
+			}
+		}
+		infoData.RelativePaths = append(infoData.RelativePaths, p)
+	}
+	return infoData, nil
+}
+
+// readBinaryEntries decodes the entries of a binary database one at a
+// time.
+func readBinaryEntries(f *os.File, fn func(hash string, infoData InfoData) error) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	footer, err := readBinaryFooter(f, info.Size())
+	if err != nil {
+		return err
+	}
+	headerSize := int64(footer.headerSize)
+	r := bufio.NewReaderSize(io.NewSectionReader(f, headerSize, int64(footer.hashIndex)-headerSize), 256*1024)
+	for range footer.entries {
+		infoData, err := readBinaryEntry(r, footer.digestSize)
+		if err != nil {
+			return fmt.Errorf("binary database is damaged: %w", err)
+		}
+		if err := fn(infoData.ContentMD5, infoData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BinaryDatabase is an open binary database that looks up single paths and
+// hashes through its indexes, without decoding the whole file.
+type BinaryDatabase struct {
+	f      *os.File
+	footer binaryFooter
+}
+
+// OpenBinaryDatabase opens a binary database for lookups.
+func OpenBinaryDatabase(checksumFilePath string) (*BinaryDatabase, error) {
+	f, err := os.Open(checksumFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open checksum database file '%s': %w", checksumFilePath, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	footer, err := readBinaryFooter(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not open checksum database file '%s': %w", checksumFilePath, err)
+	}
+	return &BinaryDatabase{f: f, footer: footer}, nil
+}
+
+// Close closes the database file.
+func (b *BinaryDatabase) Close() error {
+	return b.f.Close()
+}
+
+// Len returns the number of content hashes in the database.
+func (b *BinaryDatabase) Len() int {
+	return int(b.footer.entries)
+}
+
+// entryAt decodes the entry at offset.
+func (b *BinaryDatabase) entryAt(offset uint64) (InfoData, error) {
+	if offset >= b.footer.hashIndex {
+		return InfoData{}, fmt.Errorf("binary database is damaged")
+	}
+	r := bufio.NewReader(io.NewSectionReader(b.f, int64(offset), int64(b.footer.hashIndex-offset)))
+	return readBinaryEntry(r, b.footer.digestSize)
+}
+
+// Get returns the entry of a content hash.
+func (b *BinaryDatabase) Get(hash string) (InfoData, bool, error) {
+	digest, err := hex.DecodeString(hash)
+	if err != nil || len(digest) != b.footer.digestSize {
+		return InfoData{}, false, nil
+	}
+	recordSize := b.footer.digestSize + 8
+	record := make([]byte, recordSize)
+	var readErr error
+	i := sort.Search(int(b.footer.entries), func(i int) bool {
+		if _, err := b.f.ReadAt(record, int64(b.footer.hashIndex)+int64(i*recordSize)); err != nil {
+			readErr = err
+			return true
+		}
+		return bytes.Compare(record[:b.footer.digestSize], digest) >= 0
+	})
+	if readErr != nil || i == int(b.footer.entries) {
+		return InfoData{}, false, readErr
+	}
+	if _, err := b.f.ReadAt(record, int64(b.footer.hashIndex)+int64(i*recordSize)); err != nil {
+		return InfoData{}, false, err
+	}
+	if !bytes.Equal(record[:b.footer.digestSize], digest) {
+		return InfoData{}, false, nil
+	}
+	infoData, err := b.entryAt(binary.LittleEndian.Uint64(record[b.footer.digestSize:]))
+	return infoData, err == nil, err
+}
+
+// pathRecord reads record i of the path index: the path and the offset of
+// its entry.
+func (b *BinaryDatabase) pathRecord(i int) (string, uint64, error) {
+	data := make([]byte, 16)
+	if _, err := b.f.ReadAt(data, int64(b.footer.pathIndex)+int64(i*16)); err != nil {
+		return "", 0, err
+	}
+	offset, entry := binary.LittleEndian.Uint64(data), binary.LittleEndian.Uint64(data[8:])
+	if offset >= b.footer.hashIndex || entry >= b.footer.hashIndex {
+		return "", 0, fmt.Errorf("binary database is damaged")
+	}
+	r := bufio.NewReaderSize(io.NewSectionReader(b.f, int64(offset), int64(b.footer.hashIndex-offset)), 512)
+	path, err := readBlob(r)
+	return string(path), entry, err
+}
+
+// Lookup returns the entry holding the relative path.
+func (b *BinaryDatabase) Lookup(relPath string) (InfoData, bool, error) {
+	var readErr error
+	i := sort.Search(int(b.footer.paths), func(i int) bool {
+		path, _, err := b.pathRecord(i)
+		if err != nil {
+			readErr = err
+			return true
+		}
+		return path >= relPath
+	})
+	if readErr != nil || i == int(b.footer.paths) {
+		return InfoData{}, false, readErr
+	}
+	path, offset, err := b.pathRecord(i)
+	if err != nil || path != relPath {
+		return InfoData{}, false, err
+	}
+	infoData, err := b.entryAt(offset)
+	return infoData, err == nil, err
+}
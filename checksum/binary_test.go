@@ -0,0 +1,91 @@
+package checksum
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	checksumDB := testDatabase(3000)
+	// A content stored under several paths, with metadata and chunks
+	shared := checksumDB.PathIndex()["dir0/file-0.txt"]
+	infoData := checksumDB[shared]
+	infoData.RelativePaths = append(infoData.RelativePaths, PathEntry{
+		Path:      "copies/file-0.txt",
+		FirstSeen: "2024-01-02T03:04:05Z",
+		LastSeen:  "2024-06-07T08:09:10Z",
+		Metadata: &FileMetadata{
+			Mode:    "-rw-r--r--",
+			Size:    42,
+			ModTime: "2024-01-02T03:04:05Z",
+			Owner:   &FileOwner{UID: 1000, GID: 1000},
+			Xattrs:  map[string]string{"user.origin": "scanner"},
+		},
+	})
+	infoData.Chunks = &ChunkInfo{Mode: "fixed", Size: 32, Length: 42, Chunks: []Chunk{
+		{Offset: 0, Length: 32, Hash: "0123456789abcdef0123456789abcdef"},
+		{Offset: 32, Length: 10, Hash: "fedcba9876543210fedcba9876543210"},
+	}}
+	checksumDB[shared] = infoData
+
+	path := filepath.Join(t.TempDir(), DatabaseFileName)
+	selection := Selection{MaxSize: 1 << 20, SkipHidden: true}
+	if err := checksumDB.SaveAs(path, FormatBinary, nil, selection); err != nil {
+		t.Fatal(err)
+	}
+	if format, err := DetectFormat(path); err != nil || format != FormatBinary {
+		t.Errorf("DetectFormat = %s, %v, want %s", format, err, FormatBinary)
+	}
+	loaded, err := LoadDatabase(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, checksumDB) {
+		t.Error("loaded binary database differs from the saved one")
+	}
+	if recorded, err := ReadSelection(path, nil); err != nil || !recorded.equal(selection) {
+		t.Errorf("recorded selection = %v, %v, want %v", recorded, err, selection)
+	}
+}
+
+func TestBinaryLookup(t *testing.T) {
+	checksumDB := testDatabase(3000)
+	path := filepath.Join(t.TempDir(), DatabaseFileName)
+	if err := checksumDB.SaveAs(path, FormatBinary, nil, Selection{}); err != nil {
+		t.Fatal(err)
+	}
+	binaryDB, err := OpenBinaryDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binaryDB.Close()
+
+	if binaryDB.Len() != len(checksumDB) {
+		t.Errorf("Len = %d, want %d", binaryDB.Len(), len(checksumDB))
+	}
+	for relPath, hash := range checksumDB.PathIndex() {
+		byHash, found, err := binaryDB.Get(hash)
+		if err != nil || !found || !reflect.DeepEqual(byHash, checksumDB[hash]) {
+			t.Fatalf("Get(%s) = %v, %v, %v, want the entry of %s", hash, byHash, found, err, relPath)
+		}
+		byPath, found, err := binaryDB.Lookup(filepath.FromSlash(relPath))
+		if err != nil || !found || byPath.ContentMD5 != hash {
+			t.Fatalf("Lookup(%s) = %s, %v, %v, want %s", relPath, byPath.ContentMD5, found, err, hash)
+		}
+	}
+
+	missing := []struct{ hash, path string }{
+		{"00000000000000000000000000000000", "dir0/missing.txt"},
+		{"ffffffffffffffffffffffffffffffff", "zzz"},
+		{"", ""},
+	}
+	for _, m := range missing {
+		if _, found, err := binaryDB.Get(m.hash); err != nil || found {
+			t.Errorf("Get(%q) = %v, %v, want not found", m.hash, found, err)
+		}
+		if _, found, err := binaryDB.Lookup(m.path); err != nil || found {
+			t.Errorf("Lookup(%q) = %v, %v, want not found", m.path, found, err)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package checksum
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// boundedSorters is the number of external sorters a bounded verify uses;
+// each gets an equal share of the memory limit.
+const boundedSorters = 5
+
+// minSorterMemory is the least memory each sorter of a bounded verify
+// holds before it spills. Tests lower it to spill after a few records.
+var minSorterMemory = 1024 * 1024
+
+// verifyBounded classifies the files on disk like ClassifyFiles, within
+// the memory limit of opts. The hashed files and the database paths
+// are sorted on disk and merged by path, which finds OK, MODIFIED and
+// METADATA_CHANGED files; the files not found at their path and the
+// deleted paths are then merged with the database by hash, which finds the
+// MOVED, RENAMED, NEW and DELETED ones.
+func (v *Verifier) verifyBounded(ctx context.Context, opts Options, checksumFilePath, algorithm string) (*VerifyReport, error) {
+	baseLocationPath := opts.RootPath()
+	limit := int(opts.MemoryLimit / boundedSorters)
+	newSorter := func() *externalSorter {
+		return newExternalSorter("", max(limit, minSorterMemory))
+	}
+	diskByPath, dbByPath, dbByHash := newSorter(), newSorter(), newSorter()
+	defer diskByPath.remove()
+	defer dbByPath.remove()
+	defer dbByHash.remove()
+
+	// Index the database paths in scope
+	entries, uniqueChecksums := 0, 0
+	err := ReadDatabase(checksumFilePath, opts.DatabaseKey, func(hash string, infoData InfoData) error {
+		if entries == 0 {
+			if existing := DetectAlgorithm(Database{hash: infoData}); existing != algorithm {
+				return fmt.Errorf("the database uses %s but %s was requested", existing, algorithm)
+			}
+		}
+		entries++
+		inScope := false
+		for _, p := range infoData.RelativePaths {
+			if !opts.inScope(p.Path, false) {
+				continue
+			}
+			inScope = true
+			var metadata string
+			if p.Metadata != nil {
+				data, err := json.Marshal(p.Metadata)
+				if err != nil {
+					return err
+				}
+				metadata = string(data)
+			}
+			if err := dbByPath.add(sortRecord{Key: p.Path, Value: hash, Extra: metadata}); err != nil {
+				return err
+			}
+			if err := dbByHash.add(sortRecord{Key: hash, Value: p.Path}); err != nil {
+				return err
+			}
+		}
+		if inScope {
+			uniqueChecksums++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if entries == 0 {
+		return nil, fmt.Errorf("no valid checksums found in database")
+	}
+
+	// Hash the files on disk
+	scanner := &Scanner{Options: opts, Progress: v.Progress}
+	report := &VerifyReport{UniqueChecksums: uniqueChecksums}
+	var addErr error
+	skippedFiles, pending := scanner.hashFiles(ctx, func(f hashedFile) {
+		if f.err != nil {
+			report.Errors = append(report.Errors, FileError{Path: f.relPath, Error: f.err.Error()})
+			return
+		}
+		if f.warning != "" {
+			report.Warnings = append(report.Warnings, FileError{Path: f.relPath, Error: f.warning})
+		}
+		for entryPath, entry := range f.entries {
+			if err := diskByPath.add(sortRecord{Key: entryPath, Value: entry.hash}); err != nil && addErr == nil {
+				addErr = err
+			}
+			report.FilesChecked++
+		}
+		report.BytesHashed += f.size
+	})
+	if addErr != nil {
+		return nil, addErr
+	}
+	report.Skipped, report.FilesPending = skippedFiles, len(pending)
+
+	// Files that were not read are unknown rather than deleted
+	unchecked := make(map[string]bool, len(pending)+len(report.Errors))
+	for _, p := range pending {
+		unchecked[p] = true
+	}
+	for _, e := range report.Errors {
+		unchecked[e.Path] = true
+	}
+
+	results := map[string][]Result{
+		"OK":               {},
+		"MODIFIED":         {},
+		"METADATA_CHANGED": {},
+		"MOVED":            {},
+		"NEW":              {},
+		"DELETED":          {},
+		"RENAMED":          {},
+	}
+
+	// Merge by path. Files not at a database path of their hash go to
+	// notFound, flagged when they are MODIFIED, which rules out NEW.
+	notFound, deleted := newSorter(), newSorter()
+	defer notFound.remove()
+	defer deleted.remove()
+	disk, err := diskByPath.iterate()
+	if err != nil {
+		return nil, err
+	}
+	defer disk.close()
+	db, err := dbByPath.iterate()
+	if err != nil {
+		return nil, err
+	}
+	defer db.close()
+	for {
+		diskRecord, diskOK := disk.peek()
+		dbRecord, dbOK := db.peek()
+		if !diskOK && !dbOK {
+			break
+		}
+		path := dbRecord.Key
+		if !dbOK || (diskOK && diskRecord.Key < dbRecord.Key) {
+			path = diskRecord.Key
+		}
+		var stored []sortRecord
+		for r, ok := db.peek(); ok && r.Key == path; r, ok = db.peek() {
+			stored = append(stored, r)
+			db.next()
+		}
+		if !diskOK || diskRecord.Key != path {
+			for _, r := range stored {
+				if err := deleted.add(sortRecord{Key: r.Value, Value: path}); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		disk.next()
+		hash, found := diskRecord.Value, false
+		for _, r := range stored {
+			if r.Value != hash {
+				results["MODIFIED"] = append(results["MODIFIED"], Result{Path: path, OriginalContentHash: r.Value, ContentHash: hash})
+				continue
+			}
+			found = true
+			var recorded *FileMetadata
+			if r.Extra != "" {
+				recorded = &FileMetadata{}
+				if err := json.Unmarshal([]byte(r.Extra), recorded); err != nil {
+					return nil, err
+				}
+			}
+			if changes := changedMetadata(baseLocationPath, path, recorded); len(changes) > 0 {
+				results["METADATA_CHANGED"] = append(results["METADATA_CHANGED"], Result{Path: path, ContentHash: hash, ChangedAttributes: changes})
+			} else {
+				report.OKNotListed++
+			}
+		}
+		if !found {
+			modified := ""
+			if len(stored) > 0 {
+				modified = "modified"
+			}
+			if err := notFound.add(sortRecord{Key: hash, Value: path, Extra: modified}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if disk.err != nil {
+		return nil, disk.err
+	}
+	if db.err != nil {
+		return nil, db.err
+	}
+
+	// Merge by hash
+	if err := classifyByHash(dbByHash, notFound, deleted, unchecked, results); err != nil {
+		return nil, err
+	}
+	dropDeselected(results, baseLocationPath, opts.Selection, time.Now())
+
+	// Read the original chunks of the modified files
+	if len(results["MODIFIED"]) > 0 {
+		originals := make(Database)
+		for _, r := range results["MODIFIED"] {
+			originals[r.OriginalContentHash] = InfoData{}
+		}
+		err := ReadDatabase(checksumFilePath, opts.DatabaseKey, func(hash string, infoData InfoData) error {
+			if _, wanted := originals[hash]; wanted {
+				originals[hash] = InfoData{Chunks: infoData.Chunks}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		localiseModifications(ctx, newThrottle(opts), baseLocationPath, algorithm, originals, results["MODIFIED"])
+	}
+
+	for _, list := range results {
+		sortResults(list)
+	}
+	report.Results = results
+	return report, nil
+}
+
+// classifyByHash merges the database paths, the files not found at their
+// path and the deleted paths by content hash. A file whose hash is in the
+// database is MOVED, or else NEW unless it was MODIFIED; a hash both moved
+// and deleted is RENAMED. Deleted paths that were not checked are left out.
+func classifyByHash(dbByHash, notFound, deleted *externalSorter, unchecked map[string]bool, results map[string][]Result) error {
+	var iterators [3]*sortIterator
+	for i, s := range []*externalSorter{dbByHash, notFound, deleted} {
+		it, err := s.iterate()
+		if err != nil {
+			return err
+		}
+		defer it.close()
+		iterators[i] = it
+	}
+	db, files, gone := iterators[0], iterators[1], iterators[2]
+	group := func(it *sortIterator, hash string) []sortRecord {
+		var records []sortRecord
+		for r, ok := it.peek(); ok && r.Key == hash; r, ok = it.peek() {
+			records = append(records, r)
+			it.next()
+		}
+		return records
+	}
+	for {
+		var hash string
+		for _, it := range iterators[1:] {
+			if r, ok := it.peek(); ok && (hash == "" || r.Key < hash) {
+				hash = r.Key
+			}
+		}
+		if hash == "" {
+			break
+		}
+		// Skip the database hashes no file or deleted path refers to
+		for r, ok := db.peek(); ok && r.Key < hash; r, ok = db.peek() {
+			db.next()
+		}
+		var knownPaths []string
+		for _, r := range group(db, hash) {
+			knownPaths = append(knownPaths, r.Value)
+		}
+
+		var moved []Result
+		for _, r := range group(files, hash) {
+			switch {
+			case len(knownPaths) > 0:
+				moved = append(moved, Result{Path: r.Value, ContentHash: hash, KnownOldPaths: knownPaths})
+			case r.Extra == "":
+				results["NEW"] = append(results["NEW"], Result{Path: r.Value, ContentHash: hash})
+			}
+		}
+		var deletedPaths []string
+		for _, r := range group(gone, hash) {
+			deletedPaths = append(deletedPaths, r.Value)
+		}
+
+		if len(moved) > 0 && len(deletedPaths) > 0 {
+			results["RENAMED"] = append(results["RENAMED"], Result{ContentHash: hash, OldPaths: deletedPaths, NewPaths: getPathsFromResults(moved)})
+			continue
+		}
+		results["MOVED"] = append(results["MOVED"], moved...)
+		for _, path := range deletedPaths {
+			archivePath, _, _ := splitArchivePath(path)
+			if !unchecked[path] && !unchecked[archivePath] {
+				results["DELETED"] = append(results["DELETED"], Result{Path: path, OriginalContentHash: hash})
+			}
+		}
+	}
+	for _, it := range iterators {
+		if it.err != nil {
+			return it.err
+		}
+	}
+	return nil
+}
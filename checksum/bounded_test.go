@@ -0,0 +1,80 @@
+package checksum
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"md5checker/checksum/checksumtest"
+)
+
+func TestVerifyBoundedMatchesVerify(t *testing.T) {
+	files := map[string]string{
+		"a/same.txt":    "same",
+		"a/changed.txt": "before",
+		"a/gone.txt":    "gone",
+		"a/old-name":    "renamed",
+		"b/copy-1":      "copied",
+	}
+	for i := range 40 {
+		files[fmt.Sprintf("bulk/dir%d/file-%02d.txt", i%5, i)] = fmt.Sprint(i % 30)
+	}
+	root := checksumtest.WriteTree(t, files)
+	checksumDB := make(Database)
+	scanTestTree(t, Options{Root: root}, checksumDB)
+	if err := checksumDB.Save(filepath.Join(root, DatabaseFileName), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(path, content string) {
+		if err := os.WriteFile(filepath.Join(root, filepath.FromSlash(path)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a/changed.txt", "after")
+	write("b/copy-2", "copied")
+	write("b/fresh.txt", "fresh")
+	if err := os.Remove(filepath.Join(root, "a", "gone.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(root, "a", "old-name"), filepath.Join(root, "b", "new-name")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := verifyTestTree(t, Options{Root: root})
+
+	// Every sorter spills after a few records
+	defer func(saved int) { minSorterMemory = saved }(minSorterMemory)
+	minSorterMemory = 300
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	got := verifyTestTree(t, Options{Root: root, MemoryLimit: 1})
+
+	withoutOK := func(report *VerifyReport) []string {
+		var reported []string
+		for _, line := range reportedPaths(report) {
+			if !strings.HasPrefix(line, "OK ") {
+				reported = append(reported, line)
+			}
+		}
+		return reported
+	}
+	if !reflect.DeepEqual(withoutOK(got), withoutOK(want)) {
+		t.Errorf("bounded verify reported %v, want %v", withoutOK(got), withoutOK(want))
+	}
+	if got.OKNotListed != len(want.Results["OK"]) {
+		t.Errorf("bounded verify counted %d OK files, want %d", got.OKNotListed, len(want.Results["OK"]))
+	}
+	if got.FilesChecked != want.FilesChecked || got.UniqueChecksums != want.UniqueChecksums {
+		t.Errorf("bounded verify checked %d files of %d checksums, want %d of %d", got.FilesChecked, got.UniqueChecksums, want.FilesChecked, want.UniqueChecksums)
+	}
+	if renamed := got.Results["RENAMED"]; len(renamed) != 1 || !reflect.DeepEqual(renamed[0].OldPaths, []string{filepath.FromSlash("a/old-name")}) {
+		t.Errorf("bounded verify RENAMED = %v, want a/old-name", renamed)
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("%d sort files left after the verify", len(entries))
+	}
+}
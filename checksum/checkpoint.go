@@ -0,0 +1,271 @@
+package checksum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// DefaultCheckpointInterval is how often a Checkpoint writes its records to
+// disk when no interval is set.
+const DefaultCheckpointInterval = 30 * time.Second
+
+// checkpointHeader is the first line of a checkpoint. It records the options
+// that determine the hashes and the files scanned, so a resume with other
+// options is refused.
+type checkpointHeader struct {
+	Algorithm string    `json:"Algorithm"`
+	Archives  bool      `json:"Archives"`
+	ChunkMode string    `json:"ChunkMode"`
+	ChunkSize int64     `json:"ChunkSize"`
+	Metadata  bool      `json:"Metadata"`
+	Xattrs    bool      `json:"Xattrs"`
+	Symlinks  string    `json:"Symlinks"`
+	Include   []string  `json:"Include,omitempty"`
+	Exclude   []string  `json:"Exclude,omitempty"`
+	Selection Selection `json:"Selection"`
+	Scope     []string  `json:"Scope,omitempty"`
+}
+
+func newCheckpointHeader(opts Options) checkpointHeader {
+	header := checkpointHeader{
+		Algorithm: AlgorithmName(opts.Algorithm),
+		Archives:  opts.Archives,
+		ChunkMode: opts.ChunkMode,
+		Metadata:  opts.Metadata,
+		Xattrs:    opts.Xattrs,
+		Symlinks:  opts.Symlinks,
+		Include:   opts.Include,
+		Exclude:   opts.excludePatterns(),
+		Selection: opts.Selection,
+		Scope:     opts.Paths,
+	}
+	if opts.ChunkMode != "" {
+		header.ChunkSize = opts.ChunkSize
+	}
+	return header
+}
+
+// checkpointRecord is one file hashed by an earlier run, with the size and
+// modification time it had when it was hashed.
+type checkpointRecord struct {
+	Path     string                     `json:"Path"`
+	Size     int64                      `json:"Size"`
+	ModTime  string                     `json:"ModTime"`
+	Expanded bool                       `json:"Expanded,omitempty"`
+	Entries  map[string]checkpointEntry `json:"Entries"`
+}
+
+type checkpointEntry struct {
+	Hash     string        `json:"Hash"`
+	Chunks   *ChunkInfo    `json:"Chunks,omitempty"`
+	Metadata *FileMetadata `json:"Metadata,omitempty"`
+}
+
+// Checkpoint is an append-only log of the files hashed by a scan, one JSON
+// line per file. When a long scan is interrupted, a resumed scan takes the
+// hashes of files whose size and modification time are unchanged from the
+// checkpoint instead of reading them again.
+type Checkpoint struct {
+	// Interval is how often the records are written to disk.
+	Interval time.Duration
+
+	path      string
+	file      *os.File
+	w         *bufio.Writer
+	records   map[string]checkpointRecord
+	lastFlush time.Time
+	err       error
+}
+
+// OpenCheckpoint opens the checkpoint at path for a scan with opts. With
+// resume, the records of an earlier run are loaded and new records are
+// appended to them; a checkpoint written with other options is refused.
+// Otherwise any existing checkpoint is replaced.
+func OpenCheckpoint(path string, opts Options, resume bool) (*Checkpoint, error) {
+	header := newCheckpointHeader(opts)
+	c := &Checkpoint{Interval: DefaultCheckpointInterval, path: path, records: make(map[string]checkpointRecord), lastFlush: time.Now()}
+	if resume {
+		file, err := os.OpenFile(path, os.O_RDWR, 0644)
+		if err == nil {
+			if err := c.load(file, header); err != nil {
+				file.Close()
+				return nil, err
+			}
+			c.file = file
+			c.w = bufio.NewWriter(file)
+			return c, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("could not open checkpoint '%s': %w", path, err)
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not create checkpoint '%s': %w", path, err)
+	}
+	c.file = file
+	c.w = bufio.NewWriter(file)
+	c.write(header)
+	if err := c.Flush(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads the header and records of an existing checkpoint and leaves the
+// file positioned for appending. A partly written last line, left by a
+// crash, is cut off.
+func (c *Checkpoint) load(file *os.File, header checkpointHeader) error {
+	reader := bufio.NewReader(file)
+	var offset int64
+	first := true
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("could not read checkpoint '%s': %w", c.path, err)
+		}
+		if first {
+			if !header.matches(line) {
+				return fmt.Errorf("checkpoint '%s' was written with different options, file selection or paths, run without resuming to start over", c.path)
+			}
+			first = false
+		} else {
+			var record checkpointRecord
+			if json.Unmarshal(line, &record) != nil {
+				break
+			}
+			c.records[record.Path] = record
+		}
+		offset += int64(len(line))
+	}
+	if first {
+		return fmt.Errorf("checkpoint '%s' is empty, run without resuming to start over", c.path)
+	}
+	if err := file.Truncate(offset); err != nil {
+		return fmt.Errorf("could not repair checkpoint '%s': %w", c.path, err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("could not repair checkpoint '%s': %w", c.path, err)
+	}
+	return nil
+}
+
+// matches reports whether line is a checkpoint header written with the same
+// options as h.
+func (h checkpointHeader) matches(line []byte) bool {
+	var existing checkpointHeader
+	if json.Unmarshal(line, &existing) != nil {
+		return false
+	}
+	a, errA := json.Marshal(existing)
+	b, errB := json.Marshal(h)
+	return errA == nil && errB == nil && bytes.Equal(a, b)
+}
+
+// Len returns the number of files recorded in the checkpoint.
+func (c *Checkpoint) Len() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.records)
+}
+
+// resume returns the file as recorded in the checkpoint if its size and
+// modification time still match info.
+func (c *Checkpoint) resume(filePath, relPath string, info os.FileInfo) (hashedFile, bool) {
+	record, ok := c.records[relPath]
+	if !ok || record.Size != info.Size() || record.ModTime != info.ModTime().UTC().Format(time.RFC3339Nano) {
+		return hashedFile{}, false
+	}
+	f := hashedFile{filePath: filePath, relPath: relPath, entries: make(map[string]hashedEntry, len(record.Entries)), expanded: record.Expanded, resumed: true}
+	for entryPath, entry := range record.Entries {
+		f.entries[entryPath] = hashedEntry{hash: entry.Hash, chunks: entry.Chunks, metadata: entry.Metadata}
+	}
+	return f, true
+}
+
+// record appends a hashed file, taking its size and modification time from
+// info as it was before hashing, so a change made while hashing is noticed.
+func (c *Checkpoint) record(f hashedFile, info os.FileInfo) {
+	record := checkpointRecord{
+		Path:     f.relPath,
+		Size:     info.Size(),
+		ModTime:  info.ModTime().UTC().Format(time.RFC3339Nano),
+		Expanded: f.expanded,
+		Entries:  make(map[string]checkpointEntry, len(f.entries)),
+	}
+	for entryPath, entry := range f.entries {
+		record.Entries[entryPath] = checkpointEntry{Hash: entry.hash, Chunks: entry.chunks, Metadata: entry.metadata}
+	}
+	c.records[record.Path] = record
+	c.write(record)
+	if time.Since(c.lastFlush) >= c.Interval {
+		c.Flush()
+	}
+}
+
+func (c *Checkpoint) write(v any) {
+	if c.err != nil {
+		return
+	}
+	line, err := json.Marshal(v)
+	if err == nil {
+		_, err = c.w.Write(append(line, '\n'))
+	}
+	if err != nil {
+		c.err = fmt.Errorf("could not write checkpoint '%s': %w", c.path, err)
+	}
+}
+
+// Flush writes the buffered records to disk.
+func (c *Checkpoint) Flush() error {
+	if c == nil {
+		return nil
+	}
+	c.lastFlush = time.Now()
+	if c.err != nil {
+		return c.err
+	}
+	if err := c.w.Flush(); err != nil {
+		c.err = fmt.Errorf("could not write checkpoint '%s': %w", c.path, err)
+	} else if err := c.file.Sync(); err != nil {
+		c.err = fmt.Errorf("could not write checkpoint '%s': %w", c.path, err)
+	}
+	return c.err
+}
+
+// Close flushes and closes the checkpoint, keeping it on disk for a resume.
+func (c *Checkpoint) Close() error {
+	if c == nil {
+		return nil
+	}
+	err := c.Flush()
+	if closeErr := c.file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("could not write checkpoint '%s': %w", c.path, closeErr)
+	}
+	return err
+}
+
+// Remove closes and deletes the checkpoint once the scan it belongs to has
+// been saved.
+func (c *Checkpoint) Remove() error {
+	if c == nil {
+		return nil
+	}
+	c.file.Close()
+	if err := os.Remove(c.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not remove checkpoint '%s': %w", c.path, err)
+	}
+	return nil
+}
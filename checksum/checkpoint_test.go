@@ -0,0 +1,137 @@
+package checksum
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"md5checker/checksum/checksumtest"
+)
+
+// checkpointScan validates opts and scans the tree into a new database,
+// logging to or resuming from the checkpoint at path.
+func checkpointScan(t *testing.T, opts Options, path string, resume bool) *ScanSummary {
+	t.Helper()
+	if err := opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := OpenCheckpoint(path, opts, resume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := (&Scanner{Options: opts, Checkpoint: checkpoint}).Update(context.Background(), make(Database), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkpoint.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return summary
+}
+
+func TestCheckpointResume(t *testing.T) {
+	root := checksumtest.WriteTree(t, map[string]string{"a.txt": "alpha", "b.txt": "bravo", "sub/c.txt": "charlie"})
+	path := filepath.Join(t.TempDir(), "checksums.checkpoint.jsonl")
+	opts := Options{Root: root}
+
+	if summary := checkpointScan(t, opts, path, false); summary.Resumed != 0 || summary.FilesScanned != 3 {
+		t.Fatalf("first scan resumed %d of %d files, want none of 3", summary.Resumed, summary.FilesScanned)
+	}
+	checkpoint, err := OpenCheckpoint(path, opts, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.Len() != 3 {
+		t.Errorf("checkpoint holds %d files, want 3", checkpoint.Len())
+	}
+	checkpoint.Close()
+
+	// A file whose size changed is hashed again
+	if err := os.WriteFile(filepath.Join(root, "b.txt"), []byte("bravo, changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if summary := checkpointScan(t, opts, path, true); summary.Resumed != 2 {
+		t.Errorf("resumed scan took %d files from the checkpoint, want 2", summary.Resumed)
+	}
+
+	// Without resuming the checkpoint starts over
+	if summary := checkpointScan(t, opts, path, false); summary.Resumed != 0 {
+		t.Errorf("scan without resuming took %d files from the checkpoint", summary.Resumed)
+	}
+}
+
+func TestCheckpointTruncatedLine(t *testing.T) {
+	root := checksumtest.WriteTree(t, map[string]string{"a.txt": "alpha", "b.txt": "bravo"})
+	path := filepath.Join(t.TempDir(), "checksums.checkpoint.jsonl")
+	opts := Options{Root: root}
+	checkpointScan(t, opts, path, false)
+	complete, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash while writing leaves part of a line behind
+	if err := os.WriteFile(path, append(complete, `{"Path":"c.txt","Si`...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := OpenCheckpoint(path, opts, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.Len() != 2 {
+		t.Errorf("checkpoint holds %d files, want 2", checkpoint.Len())
+	}
+	checkpoint.Close()
+	if repaired, _ := os.ReadFile(path); string(repaired) != string(complete) {
+		t.Errorf("partial line was not cut off:\n%s", repaired)
+	}
+}
+
+func TestCheckpointOptionsMismatch(t *testing.T) {
+	root := checksumtest.WriteTree(t, map[string]string{"a.txt": "alpha", "docs/b.txt": "bravo"})
+	path := filepath.Join(t.TempDir(), "checksums.checkpoint.jsonl")
+	opts := Options{Root: root, Selection: Selection{MinSize: 2, Extensions: []string{".txt"}}, Paths: []string{"docs"}}
+	checkpointScan(t, opts, path, false)
+
+	tests := []struct {
+		name   string
+		change func(*Options)
+	}{
+		{"algorithm", func(o *Options) { o.Algorithm = "sha256" }},
+		{"min size", func(o *Options) { o.Selection.MinSize = 3 }},
+		{"extensions", func(o *Options) { o.Selection.Extensions = []string{".md"} }},
+		{"no selection", func(o *Options) { o.Selection = Selection{} }},
+		{"scope", func(o *Options) { o.Paths = []string{"other"} }},
+		{"no scope", func(o *Options) { o.Paths = nil }},
+		{"exclude", func(o *Options) { o.Exclude = []string{"*.tmp"} }},
+	}
+	for _, tt := range tests {
+		changed := opts
+		tt.change(&changed)
+		if err := changed.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenCheckpoint(path, changed, true); err == nil || !strings.Contains(err.Error(), "different options") {
+			t.Errorf("resuming with a different %s: %v, want the resume refused", tt.name, err)
+		}
+	}
+
+	same := opts
+	if err := same.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := OpenCheckpoint(path, same, true)
+	if err != nil {
+		t.Fatalf("resuming with the same options: %v", err)
+	}
+	checkpoint.Close()
+
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenCheckpoint(path, same, true); err == nil {
+		t.Error("an empty checkpoint was resumed")
+	}
+}
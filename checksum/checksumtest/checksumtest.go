@@ -0,0 +1,26 @@
+// Package checksumtest provides the file trees shared by the tests of
+// md5checker and its checksum package.
+package checksumtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// WriteTree creates the files, given by slash-separated path, in a new
+// temporary directory and returns it.
+func WriteTree(t testing.TB, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
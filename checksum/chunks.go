@@ -0,0 +1,273 @@
+package checksum
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Chunk is the hash of one byte range of a file.
+type Chunk struct {
+	Offset int64  `json:"Offset"`
+	Length int64  `json:"Length"`
+	Hash   string `json:"Hash"`
+}
+
+// UnmarshalJSON also reads the MD5 key that databases written before chunk
+// hashes could use other algorithms store the hash under.
+func (c *Chunk) UnmarshalJSON(data []byte) error {
+	type plain Chunk
+	var v struct {
+		plain
+		MD5 string `json:"MD5"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*c = Chunk(v.plain)
+	if c.Hash == "" {
+		c.Hash = v.MD5
+	}
+	return nil
+}
+
+// ChunkInfo holds the chunk hashes of a file's content. Mode is "fixed" for
+// fixed-size chunks or "cdc" for content-defined chunks, where Size is the
+// average chunk size.
+type ChunkInfo struct {
+	Mode   string  `json:"Mode"`
+	Size   int64   `json:"Size"`
+	Length int64   `json:"Length"`
+	Chunks []Chunk `json:"Chunks"`
+}
+
+// ByteRange is a half-open range [Start, End) of a file.
+type ByteRange struct {
+	Start int64 `json:"Start"`
+	End   int64 `json:"End"`
+}
+
+// defaultChunkSize is used when a chunk mode is set without a size.
+const defaultChunkSize = 1024 * 1024
+
+// gearTable drives the rolling hash used for content-defined chunking.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	seed := uint64(0x6d643563686b7221)
+	for i := range table {
+		// splitmix64
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// chunker is an io.Writer that hashes the whole stream and splits it into
+// chunks at the same time.
+type chunker struct {
+	mode     string
+	size     int64
+	min, max int64
+	mask     uint64
+
+	whole  hash.Hash
+	cur    hash.Hash
+	curLen int64
+	offset int64
+	roll   uint64
+	chunks []Chunk
+}
+
+func newChunker(mode string, size int64, algorithm string) *chunker {
+	c := &chunker{mode: mode, size: size, whole: NewHash(algorithm), cur: NewHash(algorithm)}
+	if mode == "cdc" {
+		c.min = size / 4
+		c.max = size * 4
+		c.mask = uint64(1)<<uint(bits.Len64(uint64(size))-1) - 1
+	}
+	return c
+}
+
+func (c *chunker) Write(p []byte) (int, error) {
+	c.whole.Write(p)
+	total := len(p)
+	for len(p) > 0 {
+		if c.mode == "fixed" {
+			n := int(min(int64(len(p)), c.size-c.curLen))
+			c.cur.Write(p[:n])
+			c.curLen += int64(n)
+			p = p[n:]
+			if c.curLen == c.size {
+				c.cut()
+			}
+			continue
+		}
+
+		// Content-defined: cut where the rolling hash hits the mask
+		cutAt := -1
+		for i, b := range p {
+			c.roll = (c.roll << 1) + gearTable[b]
+			length := c.curLen + int64(i) + 1
+			if (length >= c.min && c.roll&c.mask == 0) || length >= c.max {
+				cutAt = i + 1
+				break
+			}
+		}
+		if cutAt < 0 {
+			c.cur.Write(p)
+			c.curLen += int64(len(p))
+			break
+		}
+		c.cur.Write(p[:cutAt])
+		c.curLen += int64(cutAt)
+		p = p[cutAt:]
+		c.cut()
+	}
+	return total, nil
+}
+
+func (c *chunker) cut() {
+	c.chunks = append(c.chunks, Chunk{
+		Offset: c.offset,
+		Length: c.curLen,
+		Hash:   fmt.Sprintf("%x", c.cur.Sum(nil)),
+	})
+	c.offset += c.curLen
+	c.curLen = 0
+	c.roll = 0
+	c.cur.Reset()
+}
+
+// finish flushes the last partial chunk and returns the whole-file hash and
+// the chunk list.
+func (c *chunker) finish() (string, *ChunkInfo) {
+	if c.curLen > 0 {
+		c.cut()
+	}
+	return fmt.Sprintf("%x", c.whole.Sum(nil)), &ChunkInfo{
+		Mode:   c.mode,
+		Size:   c.size,
+		Length: c.offset,
+		Chunks: c.chunks,
+	}
+}
+
+// hashFileChunks hashes the file at filePath and splits it into chunks in a
+// single pass.
+func hashFileChunks(ctx context.Context, filePath, mode string, size int64, algorithm string) (string, *ChunkInfo, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+
+	c := newChunker(mode, size, algorithm)
+	if _, err := io.Copy(c, contextReader{ctx, file}); err != nil {
+		return "", nil, err
+	}
+	fileContentHash, info := c.finish()
+	return fileContentHash, info, nil
+}
+
+func validChunkMode(mode string) bool {
+	return mode == "" || mode == "fixed" || mode == "cdc"
+}
+
+// diffChunks returns the byte ranges of the current content that differ
+// from the original, and the share of the file they cover in percent.
+// Fixed-size chunks are compared position by position; content-defined
+// chunks are compared by content, so inserted bytes only mark the chunks
+// around them.
+func diffChunks(original, current *ChunkInfo) ([]ByteRange, float64) {
+	var ranges []ByteRange
+	addRange := func(start, end int64) {
+		if n := len(ranges); n > 0 && ranges[n-1].End == start {
+			ranges[n-1].End = end
+			return
+		}
+		ranges = append(ranges, ByteRange{Start: start, End: end})
+	}
+
+	total := current.Length
+	if original.Mode == "fixed" {
+		total = max(original.Length, current.Length)
+		for i := 0; i < max(len(original.Chunks), len(current.Chunks)); i++ {
+			switch {
+			case i >= len(current.Chunks):
+				addRange(original.Chunks[i].Offset, original.Chunks[i].Offset+original.Chunks[i].Length)
+			case i >= len(original.Chunks) || original.Chunks[i].Hash != current.Chunks[i].Hash ||
+				original.Chunks[i].Length != current.Chunks[i].Length:
+				addRange(current.Chunks[i].Offset, current.Chunks[i].Offset+current.Chunks[i].Length)
+			}
+		}
+	} else {
+		known := make(map[string]bool, len(original.Chunks))
+		for _, c := range original.Chunks {
+			known[c.Hash] = true
+		}
+		for _, c := range current.Chunks {
+			if !known[c.Hash] {
+				addRange(c.Offset, c.Offset+c.Length)
+			}
+		}
+	}
+
+	if total == 0 {
+		return ranges, 0
+	}
+	var changed int64
+	for _, r := range ranges {
+		changed += r.End - r.Start
+	}
+	return ranges, float64(changed) * 100 / float64(total)
+}
+
+// localiseModifications fills in the changed byte ranges of MODIFIED results
+// whose original content was stored with chunk hashes. Only the modified
+// files are read again, using the chunk settings stored in the database,
+// and paced by the same limits as the scan.
+func localiseModifications(ctx context.Context, limits *throttle, baseLocationPath, algorithm string, checksumDB Database, modified []Result) {
+	ctx = withThrottle(ctx, limits)
+	for i := range modified {
+		r := &modified[i]
+		original := checksumDB[r.OriginalContentHash].Chunks
+		if original == nil || strings.Contains(r.Path, archiveSeparator) {
+			continue
+		}
+		if limits != nil && limits.waitFile(ctx) != nil {
+			return
+		}
+		_, current, err := hashFileChunks(ctx, filepath.Join(baseLocationPath, r.Path), original.Mode, original.Size, algorithm)
+		if err != nil {
+			continue
+		}
+		r.ChangedRanges, r.ChangedPercent = diffChunks(original, current)
+	}
+}
+
+// FormatByteRange renders a byte range as "start-end (size)".
+func FormatByteRange(r ByteRange) string {
+	return fmt.Sprintf("%d-%d (%s)", r.Start, r.End, FormatBytes(r.End-r.Start))
+}
+
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
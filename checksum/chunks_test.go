@@ -0,0 +1,180 @@
+package checksum
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testContent returns n pseudo-random bytes that are the same on every run.
+func testContent(n int) []byte {
+	data := make([]byte, n)
+	x := uint64(0x9e3779b97f4a7c15)
+	for i := range data {
+		x ^= x << 13
+		x ^= x >> 7
+		x ^= x << 17
+		data[i] = byte(x)
+	}
+	return data
+}
+
+// chunkContent splits data as a scan with the given chunk settings would.
+func chunkContent(mode string, size int64, data []byte) *ChunkInfo {
+	c := newChunker(mode, size, "md5")
+	c.Write(data)
+	_, info := c.finish()
+	return info
+}
+
+// edit returns a copy of data with insert placed at offset, replacing
+// replace bytes.
+func edit(data []byte, offset, replace int, insert string) []byte {
+	out := append(bytes.Clone(data[:offset]), insert...)
+	return append(out, data[min(offset+replace, len(data)):]...)
+}
+
+func TestCDCBoundaries(t *testing.T) {
+	const size = 1024
+	data := testContent(64 * 1024)
+	info := chunkContent("cdc", size, data)
+
+	// Boundaries depend on the gear table alone; changing it would mark
+	// every chunk of an existing database as modified
+	var offsets []int64
+	for _, c := range info.Chunks[:6] {
+		offsets = append(offsets, c.Offset)
+	}
+	if want := []int64{0, 1105, 3067, 7163, 8285, 9857}; !reflect.DeepEqual(offsets, want) {
+		t.Errorf("first chunk offsets = %v, want %v", offsets, want)
+	}
+	var next int64
+	for i, c := range info.Chunks {
+		if c.Offset != next {
+			t.Fatalf("chunk %d starts at %d, want %d", i, c.Offset, next)
+		}
+		if i < len(info.Chunks)-1 && (c.Length < size/4 || c.Length > size*4) {
+			t.Errorf("chunk %d is %d bytes, outside %d-%d", i, c.Length, size/4, size*4)
+		}
+		next += c.Length
+	}
+	if next != info.Length || info.Length != int64(len(data)) {
+		t.Errorf("chunks cover %d of %d bytes", next, len(data))
+	}
+
+	// After an insert the boundaries find their way back to the content
+	shifted := chunkContent("cdc", size, edit(data, 100, 0, "inserted"))
+	known := make(map[string]bool)
+	for _, c := range info.Chunks {
+		known[c.Hash] = true
+	}
+	shared := 0
+	for _, c := range shifted.Chunks {
+		if known[c.Hash] {
+			shared++
+		}
+	}
+	if shared < len(info.Chunks)-2 {
+		t.Errorf("%d of %d chunks survive an insert, want all but the first", shared, len(info.Chunks))
+	}
+}
+
+func TestDiffChunks(t *testing.T) {
+	const size = 1024
+	data := testContent(64 * 1024)
+	tests := []struct {
+		name    string
+		mode    string
+		current []byte
+		// want are the exact ranges, or nil to only check that the ranges
+		// lie within the window and cover the edited offset
+		want   []ByteRange
+		window ByteRange
+		covers int64
+	}{
+		{name: "fixed in-place change", mode: "fixed", current: edit(data, 5000, 3, "xyz"),
+			want: []ByteRange{{4096, 5120}}},
+		{name: "fixed append", mode: "fixed", current: append(bytes.Clone(data), "tail"...),
+			want: []ByteRange{{65536, 65540}}},
+		{name: "fixed insert shifts every later chunk", mode: "fixed", current: edit(data, 5000, 0, "xyz"),
+			want: []ByteRange{{4096, 65539}}},
+		{name: "cdc in-place change", mode: "cdc", current: edit(data, 30000, 3, "xyz"),
+			window: ByteRange{30000 - 2*4096, 30003 + 2*4096}, covers: 30000},
+		{name: "cdc append", mode: "cdc", current: append(bytes.Clone(data), "tail"...),
+			window: ByteRange{65536 - 4096, 65540}, covers: 65536},
+		{name: "cdc insert only marks the chunks around it", mode: "cdc", current: edit(data, 30000, 0, "xyz"),
+			window: ByteRange{30000 - 2*4096, 30003 + 2*4096}, covers: 30000},
+	}
+	for _, tt := range tests {
+		original := chunkContent(tt.mode, size, data)
+		ranges, percent := diffChunks(original, chunkContent(tt.mode, size, tt.current))
+		var changed int64
+		for _, r := range ranges {
+			changed += r.End - r.Start
+		}
+		if tt.want != nil {
+			if !reflect.DeepEqual(ranges, tt.want) {
+				t.Errorf("%s: ranges %v, want %v", tt.name, ranges, tt.want)
+			}
+		} else {
+			covered := false
+			for _, r := range ranges {
+				if r.Start < tt.window.Start || r.End > tt.window.End {
+					t.Errorf("%s: range %v outside %v", tt.name, r, tt.window)
+				}
+				covered = covered || (r.Start <= tt.covers && tt.covers < r.End)
+			}
+			if !covered {
+				t.Errorf("%s: ranges %v miss offset %d", tt.name, ranges, tt.covers)
+			}
+		}
+		total := max(len(data), len(tt.current))
+		if tt.mode == "cdc" {
+			total = len(tt.current)
+		}
+		if want := float64(changed) * 100 / float64(total); percent != want {
+			t.Errorf("%s: %.2f%% changed, want %.2f%%", tt.name, percent, want)
+		}
+	}
+
+	if ranges, _ := diffChunks(chunkContent("cdc", size, data), chunkContent("cdc", size, data)); len(ranges) != 0 {
+		t.Errorf("unchanged content has changed ranges %v", ranges)
+	}
+}
+
+func TestLocaliseModifications(t *testing.T) {
+	dir := t.TempDir()
+	data := testContent(16 * 1024)
+	if err := os.WriteFile(filepath.Join(dir, "f.bin"), edit(data, 5000, 1, "x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checksumDB := Database{"h-old": InfoData{Chunks: chunkContent("fixed", 1024, data)}}
+	modified := []Result{
+		{Path: "f.bin", OriginalContentHash: "h-old"},
+		{Path: "missing.bin", OriginalContentHash: "h-old"},
+		{Path: "no-chunks.bin", OriginalContentHash: "h-other"},
+	}
+	// Re-reading the modified files is paced like the scan
+	limits := newThrottle(Options{MaxFilesPerSec: 1000, MaxBytesPerSec: 1 << 30})
+	localiseModifications(context.Background(), limits, dir, "md5", checksumDB, modified)
+
+	if want := []ByteRange{{4096, 5120}}; !reflect.DeepEqual(modified[0].ChangedRanges, want) {
+		t.Errorf("changed ranges = %v, want %v", modified[0].ChangedRanges, want)
+	}
+	if modified[1].ChangedRanges != nil || modified[2].ChangedRanges != nil {
+		t.Errorf("ranges for unreadable or unchunked files: %v, %v", modified[1].ChangedRanges, modified[2].ChangedRanges)
+	}
+	if limits.files.next.IsZero() {
+		t.Error("re-reading a modified file did not go through the file rate limit")
+	}
+}
+
+func TestChunkLegacyMD5Key(t *testing.T) {
+	var c Chunk
+	if err := c.UnmarshalJSON([]byte(`{"Offset":0,"Length":4,"MD5":"abc"}`)); err != nil || c.Hash != "abc" {
+		t.Errorf("chunk with an MD5 key = %+v, %v, want hash abc", c, err)
+	}
+}
@@ -0,0 +1,375 @@
+package checksum
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PathEntry is one path at which a content hash was seen.
+type PathEntry struct {
+	Path      string        `json:"Path"`
+	FirstSeen string        `json:"FirstSeen"`
+	LastSeen  string        `json:"LastSeen"`
+	Metadata  *FileMetadata `json:"Metadata,omitempty"`
+}
+
+// InfoData is the database entry of one content hash.
+type InfoData struct {
+	ContentMD5        string      `json:"ContentMD5"`
+	RelativePaths     []PathEntry `json:"RelativePaths"`
+	FirstCreated      string      `json:"FirstCreated"`
+	LastContentUpdate string      `json:"LastContentUpdate"`
+	Chunks            *ChunkInfo  `json:"Chunks,omitempty"`
+}
+
+// Database is a content-addressable checksum database: every content hash
+// maps to the paths it was seen at.
+type Database map[string]InfoData
+
+// LoadDatabase reads a checksum database from disk, in either format.
+// Encrypted databases are recognized by their header and need key; key is
+// not used for plain databases. The shards of a sharded database are
+// merged into one.
+func LoadDatabase(checksumFilePath string, key *DatabaseKey) (Database, error) {
+	checksumDB := make(Database)
+	err := ReadDatabase(checksumFilePath, key, func(hash string, infoData InfoData) error {
+		checksumDB[hash] = infoData
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return checksumDB, nil
+}
+
+// ReadDatabase reads a checksum database like LoadDatabase, but passes the
+// entries to fn one at a time instead of holding them all in memory; only
+// sharded databases are merged in memory first. An error returned by fn
+// stops the read and is returned.
+func ReadDatabase(checksumFilePath string, key *DatabaseKey, fn func(hash string, infoData InfoData) error) error {
+	f, err := os.Open(checksumFilePath)
+	if err != nil {
+		return fmt.Errorf("could not open checksum database file '%s': %w", checksumFilePath, err)
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	if isShardIndex(br) {
+		checksumDB, err := loadShardedDatabase(checksumFilePath, key)
+		if err != nil {
+			return err
+		}
+		for _, hash := range sortedKeys(checksumDB) {
+			if err := fn(hash, checksumDB[hash]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if isBinary(br) {
+		var fnErr error
+		err := readBinaryEntries(f, func(hash string, infoData InfoData) error {
+			fnErr = fn(hash, infoData)
+			return fnErr
+		})
+		if err != nil && err != fnErr {
+			return fmt.Errorf("could not read checksum database: %w", err)
+		}
+		return err
+	}
+	var r io.Reader = br
+	if isEncrypted(br) {
+		if key == nil {
+			return fmt.Errorf("could not open checksum database '%s': %w", checksumFilePath, ErrEncrypted)
+		}
+		if r, err = newDecryptReader(br, key); err != nil {
+			return fmt.Errorf("could not decrypt checksum database: %w", err)
+		}
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		if errors.Is(err, ErrWrongKey) {
+			return fmt.Errorf("could not decrypt checksum database: %w", err)
+		}
+		return fmt.Errorf("could not create gzip reader: %w", err)
+	}
+	defer gz.Close()
+	var fnErr error
+	err = decodeEntries(json.NewDecoder(gz), func(hash string, infoData InfoData) error {
+		fnErr = fn(hash, infoData)
+		return fnErr
+	})
+	switch {
+	case err == nil || err == fnErr:
+		return err
+	case errors.Is(err, ErrWrongKey):
+		return fmt.Errorf("could not decrypt checksum database: %w", err)
+	default:
+		return fmt.Errorf("could not parse checksum database: %w", err)
+	}
+}
+
+// decodeEntries decodes the JSON object of a database one entry at a time.
+func decodeEntries(decoder *json.Decoder, fn func(hash string, infoData InfoData) error) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("expected a JSON object")
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		hash, _ := token.(string)
+		var infoData InfoData
+		if err := decoder.Decode(&infoData); err != nil {
+			return err
+		}
+		if err := fn(hash, infoData); err != nil {
+			return err
+		}
+	}
+	_, err = decoder.Token()
+	return err
+}
+
+// Save writes the checksum database to disk as gzipped JSON. The file is
+// written next to the target and renamed over it, so an interrupted save
+// never leaves a half-written database behind. With a key, the database is
+// encrypted.
+func (db Database) Save(checksumFilePath string, key *DatabaseKey) error {
+	return db.SaveAs(checksumFilePath, FormatJSON, key, Selection{})
+}
+
+// SaveAs writes the checksum database in the given format, like Save,
+// recording the file selection it was scanned with in its header.
+func (db Database) SaveAs(checksumFilePath, format string, key *DatabaseKey, selection Selection) error {
+	w, err := CreateDatabase(checksumFilePath, format, key)
+	if err != nil {
+		return err
+	}
+	if err := w.SetSelection(selection); err != nil {
+		w.Abort()
+		return err
+	}
+	for _, hash := range sortedKeys(db) {
+		if err := w.Write(hash, db[hash]); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// DatabaseWriter writes a checksum database entry by entry, so that a
+// database need not be held in memory to be saved. Like Save, it writes a
+// temporary file that Close renames over the database.
+type DatabaseWriter struct {
+	path   string
+	file   *os.File
+	enc    *encryptWriter
+	gz     *gzip.Writer
+	json   *bufio.Writer
+	binary *binaryDatabaseWriter
+	n      int
+}
+
+// CreateDatabase starts writing a database in the given format, encrypted
+// with key if set.
+func CreateDatabase(checksumFilePath, format string, key *DatabaseKey) (*DatabaseWriter, error) {
+	if format != FormatJSON && format != FormatBinary {
+		return nil, fmt.Errorf("invalid database format '%s', expected '%s' or '%s'", format, FormatJSON, FormatBinary)
+	}
+	if format == FormatBinary && key != nil {
+		return nil, errBinaryEncrypted
+	}
+	file, err := os.CreateTemp(filepath.Dir(checksumFilePath), filepath.Base(checksumFilePath)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("could not create checksum file: %w", err)
+	}
+	w := &DatabaseWriter{path: checksumFilePath, file: file}
+	if format == FormatBinary {
+		w.binary = newBinaryDatabaseWriter(file, filepath.Dir(checksumFilePath))
+		return w, nil
+	}
+	var out io.Writer = file
+	if key != nil {
+		if w.enc, err = newEncryptWriter(file, key); err != nil {
+			w.Abort()
+			return nil, fmt.Errorf("could not encrypt checksum database: %w", err)
+		}
+		out = w.enc
+	}
+	w.gz = gzip.NewWriter(out)
+	w.json = bufio.NewWriterSize(w.gz, 64*1024)
+	w.json.WriteByte('{')
+	return w, nil
+}
+
+// SetSelection records the file selection of the database in its header.
+// It must be called before the first Write.
+func (w *DatabaseWriter) SetSelection(selection Selection) error {
+	data, err := selection.encode()
+	if err != nil {
+		return err
+	}
+	if w.binary != nil {
+		w.binary.selection = data
+		return nil
+	}
+	w.gz.Header.Extra, err = gzipExtra(data)
+	return err
+}
+
+// Write adds the entry of a content hash. Every hash must be written once.
+func (w *DatabaseWriter) Write(hash string, infoData InfoData) error {
+	if w.binary != nil {
+		if err := w.binary.write(hash, infoData); err != nil {
+			return fmt.Errorf("could not encode checksum database: %w", err)
+		}
+		return nil
+	}
+	key, err := json.Marshal(hash)
+	if err != nil {
+		return fmt.Errorf("could not encode checksum database: %w", err)
+	}
+	value, err := json.Marshal(infoData)
+	if err != nil {
+		return fmt.Errorf("could not encode checksum database: %w", err)
+	}
+	if w.n > 0 {
+		w.json.WriteByte(',')
+	}
+	w.json.Write(key)
+	w.json.WriteByte(':')
+	if _, err := w.json.Write(value); err != nil {
+		return fmt.Errorf("could not encode checksum database: %w", err)
+	}
+	w.n++
+	return nil
+}
+
+// Close finishes the database and renames it over the target.
+func (w *DatabaseWriter) Close() error {
+	if w.binary != nil {
+		if err := w.binary.close(); err != nil {
+			w.Abort()
+			return fmt.Errorf("could not encode checksum database: %w", err)
+		}
+		return w.replace()
+	}
+	w.json.WriteString("}\n")
+	if err := w.json.Flush(); err != nil {
+		w.Abort()
+		return fmt.Errorf("could not encode checksum database: %w", err)
+	}
+	if err := w.gz.Close(); err != nil {
+		w.Abort()
+		return fmt.Errorf("could not compress checksum database: %w", err)
+	}
+	if w.enc != nil {
+		if err := w.enc.Close(); err != nil {
+			w.Abort()
+			return fmt.Errorf("could not encrypt checksum database: %w", err)
+		}
+	}
+	return w.replace()
+}
+
+// Abort discards the database written so far.
+func (w *DatabaseWriter) Abort() {
+	if w.binary != nil {
+		w.binary.hashes.remove()
+		w.binary.paths.remove()
+	}
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+func (w *DatabaseWriter) replace() error {
+	defer os.Remove(w.file.Name())
+	defer w.file.Close()
+	return replaceDatabase(w.file, w.path)
+}
+
+// replaceDatabase closes the temporary file written by SaveAs and renames
+// it over the database.
+func replaceDatabase(file *os.File, checksumFilePath string) error {
+	if err := file.Chmod(0644); err != nil {
+		return fmt.Errorf("could not write checksum file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("could not write checksum file: %w", err)
+	}
+	if err := os.Rename(file.Name(), checksumFilePath); err != nil {
+		return fmt.Errorf("could not replace checksum file: %w", err)
+	}
+	return nil
+}
+
+// Lookup returns the entry holding the relative path.
+func (db Database) Lookup(relPath string) (InfoData, bool) {
+	for _, infoData := range db {
+		if findPathEntry(infoData.RelativePaths, relPath) != nil {
+			return infoData, true
+		}
+	}
+	return InfoData{}, false
+}
+
+// PathIndex maps every path in the database to its content hash.
+func (db Database) PathIndex() map[string]string {
+	index := make(map[string]string)
+	for hash, infoData := range db {
+		for _, p := range infoData.RelativePaths {
+			index[p.Path] = hash
+		}
+	}
+	return index
+}
+
+// NewDatabase builds a database from a map of relative path to content
+// hash, with every path first seen now.
+func NewDatabase(files map[string]string) Database {
+	currentTime := time.Now().UTC().Format(time.RFC3339)
+	checksumDB := make(Database)
+	for relPath, hash := range files {
+		infoData, exists := checksumDB[hash]
+		if !exists {
+			infoData = InfoData{
+				ContentMD5:        hash,
+				RelativePaths:     []PathEntry{},
+				FirstCreated:      currentTime,
+				LastContentUpdate: currentTime,
+			}
+		}
+		infoData.RelativePaths = append(infoData.RelativePaths, PathEntry{
+			Path:      relPath,
+			FirstSeen: currentTime,
+			LastSeen:  currentTime,
+		})
+		checksumDB[hash] = infoData
+	}
+	return checksumDB
+}
+
+func findPathEntry(paths []PathEntry, path string) *PathEntry {
+	for i := range paths {
+		if paths[i].Path == path {
+			return &paths[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package checksum
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted databases start with encryptionMagic and a header holding the
+// key derivation and nonce, followed by the gzipped JSON in AES-256-GCM
+// sealed segments of encryptionSegmentSize plaintext bytes. Each segment
+// is authenticated together with the header, its index and whether it is
+// the last one, so segments cannot be reordered or cut off unnoticed.
+const (
+	encryptionMagic       = "MD5CENC1"
+	encryptionSegmentSize = 64 * 1024
+	encryptionHeaderSize  = len(encryptionMagic) + 1 + 16 + 3 + 12
+
+	kdfScrypt  = 1
+	kdfKeyFile = 2
+
+	// scrypt cost: N = 2^15, r = 8, p = 1
+	scryptLogN = 15
+	scryptR    = 8
+	scryptP    = 1
+)
+
+var (
+	// ErrEncrypted is returned when an encrypted database is loaded
+	// without a key.
+	ErrEncrypted = errors.New("the database is encrypted, provide its passphrase or key file")
+	// ErrWrongKey is returned when an encrypted database cannot be
+	// decrypted with the given passphrase or key file.
+	ErrWrongKey = errors.New("wrong passphrase or key file, or the database is damaged")
+)
+
+// DatabaseKey encrypts the database at rest, with a key derived from a
+// passphrase or read from a key file.
+type DatabaseKey struct {
+	passphrase []byte
+	secret     []byte
+}
+
+// NewPassphraseKey returns a key derived from the passphrase with scrypt.
+func NewPassphraseKey(passphrase string) *DatabaseKey {
+	return &DatabaseKey{passphrase: []byte(passphrase)}
+}
+
+// LoadDatabaseKeyFile reads a key file, which holds at least 32 random
+// bytes, e.g. from 'head -c 32 /dev/urandom'.
+func LoadDatabaseKeyFile(path string) (*DatabaseKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key file '%s': %w", path, err)
+	}
+	if len(data) < 32 {
+		return nil, fmt.Errorf("key file '%s' is too short, it needs at least 32 random bytes", path)
+	}
+	sum := sha256.Sum256(append([]byte("md5checker database key\x00"), data...))
+	return &DatabaseKey{secret: sum[:]}, nil
+}
+
+// encryptionHeader is the plaintext header of an encrypted database.
+type encryptionHeader struct {
+	kdf     byte
+	salt    [16]byte
+	logN    byte
+	r, p    byte
+	nonce   [12]byte
+	encoded []byte
+}
+
+func (h *encryptionHeader) encode() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(encryptionMagic)
+	buf.WriteByte(h.kdf)
+	buf.Write(h.salt[:])
+	buf.Write([]byte{h.logN, h.r, h.p})
+	buf.Write(h.nonce[:])
+	return buf.Bytes()
+}
+
+func parseEncryptionHeader(data []byte) (*encryptionHeader, error) {
+	if len(data) != encryptionHeaderSize || string(data[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, fmt.Errorf("invalid encryption header")
+	}
+	h := &encryptionHeader{encoded: data}
+	rest := data[len(encryptionMagic):]
+	h.kdf = rest[0]
+	copy(h.salt[:], rest[1:17])
+	h.logN, h.r, h.p = rest[17], rest[18], rest[19]
+	copy(h.nonce[:], rest[20:32])
+	return h, nil
+}
+
+// newEncryptionHeader returns a header with a fresh salt and nonce for the
+// key.
+func (k *DatabaseKey) newEncryptionHeader() (*encryptionHeader, error) {
+	h := &encryptionHeader{kdf: kdfKeyFile}
+	if k.passphrase != nil {
+		h.kdf, h.logN, h.r, h.p = kdfScrypt, scryptLogN, scryptR, scryptP
+		if _, err := rand.Read(h.salt[:]); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := rand.Read(h.nonce[:]); err != nil {
+		return nil, err
+	}
+	h.encoded = h.encode()
+	return h, nil
+}
+
+// aead returns the cipher of the database with the given header.
+func (k *DatabaseKey) aead(h *encryptionHeader) (cipher.AEAD, error) {
+	var key []byte
+	switch h.kdf {
+	case kdfScrypt:
+		if k.passphrase == nil {
+			return nil, fmt.Errorf("the database is encrypted with a passphrase, not a key file")
+		}
+		if h.logN > 30 || h.r == 0 || h.p == 0 {
+			return nil, fmt.Errorf("invalid key derivation parameters")
+		}
+		var err error
+		if key, err = scrypt.Key(k.passphrase, h.salt[:], 1<<h.logN, int(h.r), int(h.p), 32); err != nil {
+			return nil, err
+		}
+	case kdfKeyFile:
+		if k.secret == nil {
+			return nil, fmt.Errorf("the database is encrypted with a key file, not a passphrase")
+		}
+		key = k.secret
+	default:
+		return nil, fmt.Errorf("unknown key derivation %d", h.kdf)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// segmentNonce and segmentData derive the nonce and additional data of
+// segment i.
+func segmentNonce(h *encryptionHeader, i uint64) []byte {
+	nonce := h.nonce
+	counter := binary.BigEndian.Uint64(nonce[4:]) ^ i
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce[:]
+}
+
+func segmentData(h *encryptionHeader, i uint64, last bool) []byte {
+	data := binary.BigEndian.AppendUint64(append([]byte{}, h.encoded...), i)
+	if last {
+		return append(data, 1)
+	}
+	return append(data, 0)
+}
+
+// encryptWriter seals everything written to it in segments. Close must be
+// called to write the last segment.
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header *encryptionHeader
+	index  uint64
+	buf    []byte
+}
+
+// newEncryptWriter writes the header of a new encrypted database to w and
+// returns the writer for its content.
+func newEncryptWriter(w io.Writer, key *DatabaseKey) (*encryptWriter, error) {
+	header, err := key.newEncryptionHeader()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := key.aead(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header.encoded); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, header: header}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+	// Keep the last segment back for Close, which marks it as the last
+	for len(e.buf) > encryptionSegmentSize {
+		if err := e.seal(e.buf[:encryptionSegmentSize], false); err != nil {
+			return 0, err
+		}
+		e.buf = append(e.buf[:0], e.buf[encryptionSegmentSize:]...)
+	}
+	return len(p), nil
+}
+
+func (e *encryptWriter) seal(plaintext []byte, last bool) error {
+	sealed := e.aead.Seal(nil, segmentNonce(e.header, e.index), plaintext, segmentData(e.header, e.index, last))
+	e.index++
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// Close writes the last segment.
+func (e *encryptWriter) Close() error {
+	return e.seal(e.buf, true)
+}
+
+// decryptReader opens the segments of an encrypted database.
+type decryptReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	header *encryptionHeader
+	index  uint64
+	plain  []byte
+	done   bool
+}
+
+// newDecryptReader reads the header of an encrypted database from r and
+// returns the reader for its content.
+func newDecryptReader(r *bufio.Reader, key *DatabaseKey) (*decryptReader, error) {
+	data := make([]byte, encryptionHeaderSize)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("could not read encryption header: %w", err)
+	}
+	header, err := parseEncryptionHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := key.aead(header)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: r, aead: aead, header: header}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open reads and opens the next segment.
+func (d *decryptReader) open() error {
+	sealed := make([]byte, encryptionSegmentSize+d.aead.Overhead())
+	n, err := io.ReadFull(d.r, sealed)
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		_, peekErr := d.r.Peek(1)
+		last = peekErr == io.EOF
+	}
+	plain, err := d.aead.Open(nil, segmentNonce(d.header, d.index), sealed[:n], segmentData(d.header, d.index, last))
+	if err != nil {
+		return ErrWrongKey
+	}
+	d.index++
+	d.plain = plain
+	d.done = last
+	return nil
+}
+
+// isEncrypted reports whether the data read by r is an encrypted database.
+func isEncrypted(r *bufio.Reader) bool {
+	magic, err := r.Peek(len(encryptionMagic))
+	return err == nil && string(magic) == encryptionMagic
+}
+
+// IsEncryptedDatabase reports whether the database file is encrypted.
+func IsEncryptedDatabase(checksumFilePath string) (bool, error) {
+	f, err := os.Open(checksumFilePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return isEncrypted(bufio.NewReader(f)), nil
+}
@@ -0,0 +1,114 @@
+package checksum
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	checksumDB := testDatabase(5000)
+	selection := Selection{MinSize: 10, Extensions: []string{".txt"}}
+	keys := map[string]*DatabaseKey{
+		"passphrase": NewPassphraseKey("correct horse battery staple"),
+		"key file":   testKeyFile(t, 0x5a),
+	}
+	for name, key := range keys {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), DatabaseFileName)
+			if err := checksumDB.SaveAs(path, FormatJSON, key, selection); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(data, []byte(encryptionMagic)) {
+				t.Errorf("database does not start with %s", encryptionMagic)
+			}
+			if bytes.Contains(data, []byte("file-1.txt")) {
+				t.Error("encrypted database holds a path in the clear")
+			}
+			if encrypted, err := IsEncryptedDatabase(path); err != nil || !encrypted {
+				t.Errorf("IsEncryptedDatabase = %v, %v, want true", encrypted, err)
+			}
+
+			loaded, err := LoadDatabase(path, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(loaded, checksumDB) {
+				t.Error("decrypted database differs from the saved one")
+			}
+			recorded, err := ReadSelection(path, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !recorded.equal(selection) {
+				t.Errorf("recorded selection = %v, want %v", recorded, selection)
+			}
+		})
+	}
+}
+
+func TestEncryptedWithoutOrWrongKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DatabaseFileName)
+	if err := testDatabase(10).SaveAs(path, FormatJSON, testKeyFile(t, 0x5a), Selection{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDatabase(path, nil); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("loading without a key: %v, want %v", err, ErrEncrypted)
+	}
+	if _, err := LoadDatabase(path, testKeyFile(t, 0xa5)); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("loading with the wrong key: %v, want %v", err, ErrWrongKey)
+	}
+	if _, err := LoadDatabase(path, NewPassphraseKey("passphrase")); err == nil {
+		t.Error("a database encrypted with a key file was opened with a passphrase")
+	}
+}
+
+func TestEncryptedTruncated(t *testing.T) {
+	key := testKeyFile(t, 0x5a)
+	path := filepath.Join(t.TempDir(), DatabaseFileName)
+	if err := testDatabase(5000).SaveAs(path, FormatJSON, key, Selection{}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealedSegment := encryptionSegmentSize + 16
+	if len(data) < encryptionHeaderSize+2*sealedSegment {
+		t.Fatalf("database of %d bytes spans less than three segments", len(data))
+	}
+
+	flipped := bytes.Clone(data)
+	flipped[encryptionHeaderSize+10] ^= 1
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		// Cutting at a segment boundary leaves only complete segments, so
+		// it is caught by the last-segment flag alone
+		{"at a segment boundary", data[:encryptionHeaderSize+sealedSegment]},
+		{"inside a segment", data[:len(data)-100]},
+		{"by one byte", data[:len(data)-1]},
+		{"after the header", data[:encryptionHeaderSize]},
+		{"a flipped bit", flipped},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			damaged := filepath.Join(t.TempDir(), DatabaseFileName)
+			if err := os.WriteFile(damaged, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadDatabase(damaged, key); !errors.Is(err, ErrWrongKey) {
+				t.Errorf("loading a damaged database: %v, want %v", err, ErrWrongKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package checksum
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// defaultSortMemory is the memory an externalSorter holds before it
+// spills, when no budget is given.
+const defaultSortMemory = 64 * 1024 * 1024
+
+// sortRecord is one record of an external sort, ordered by Key, then
+// Value.
+type sortRecord struct {
+	Key, Value, Extra string
+}
+
+// size estimates the memory the record takes.
+func (r sortRecord) size() int {
+	return len(r.Key) + len(r.Value) + len(r.Extra) + 64
+}
+
+func (r sortRecord) less(o sortRecord) bool {
+	if r.Key != o.Key {
+		return r.Key < o.Key
+	}
+	return r.Value < o.Value
+}
+
+// externalSorter sorts more records than fit in memory. Records are kept
+// in memory up to limit bytes, then sorted and spilled to a run file; the
+// iterator merges the runs.
+type externalSorter struct {
+	dir   string
+	limit int
+	buf   []sortRecord
+	size  int
+	runs  []string
+}
+
+func newExternalSorter(dir string, limit int) *externalSorter {
+	if limit <= 0 {
+		limit = defaultSortMemory
+	}
+	return &externalSorter{dir: dir, limit: limit}
+}
+
+func (s *externalSorter) add(r sortRecord) error {
+	s.buf = append(s.buf, r)
+	s.size += r.size()
+	if s.size >= s.limit {
+		return s.spill()
+	}
+	return nil
+}
+
+func (s *externalSorter) sortBuffer() {
+	sort.Slice(s.buf, func(i, j int) bool { return s.buf[i].less(s.buf[j]) })
+}
+
+// spill writes the sorted records in memory to a new run file.
+func (s *externalSorter) spill() error {
+	s.sortBuffer()
+	f, err := os.CreateTemp(s.dir, "md5checker-sort-*.run")
+	if err != nil {
+		return fmt.Errorf("could not create sort file: %w", err)
+	}
+	s.runs = append(s.runs, f.Name())
+	w := bufio.NewWriterSize(f, 256*1024)
+	var buf []byte
+	for _, r := range s.buf {
+		buf = buf[:0]
+		for _, field := range []string{r.Key, r.Value, r.Extra} {
+			buf = binary.AppendUvarint(buf, uint64(len(field)))
+			buf = append(buf, field...)
+		}
+		if _, err := w.Write(buf); err != nil {
+			f.Close()
+			return fmt.Errorf("could not write sort file: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write sort file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("could not write sort file: %w", err)
+	}
+	s.buf, s.size = nil, 0
+	return nil
+}
+
+// remove deletes the run files.
+func (s *externalSorter) remove() {
+	for _, run := range s.runs {
+		os.Remove(run)
+	}
+	s.runs = nil
+}
+
+// iterate returns an iterator over every record added, in order. No
+// records may be added afterwards.
+func (s *externalSorter) iterate() (*sortIterator, error) {
+	s.sortBuffer()
+	it := &sortIterator{}
+	if len(s.buf) > 0 {
+		it.sources = append(it.sources, &memorySource{records: s.buf})
+	}
+	for _, run := range s.runs {
+		f, err := os.Open(run)
+		if err != nil {
+			it.close()
+			return nil, fmt.Errorf("could not open sort file: %w", err)
+		}
+		it.files = append(it.files, f)
+		it.sources = append(it.sources, &runSource{r: bufio.NewReaderSize(f, 64*1024)})
+	}
+	for i, source := range it.sources {
+		r, ok, err := source.next()
+		if err != nil {
+			it.close()
+			return nil, err
+		}
+		if ok {
+			it.heap = append(it.heap, heapItem{r, i})
+		}
+	}
+	heap.Init(&it.heap)
+	return it, nil
+}
+
+type recordSource interface {
+	next() (sortRecord, bool, error)
+}
+
+type memorySource struct {
+	records []sortRecord
+}
+
+func (m *memorySource) next() (sortRecord, bool, error) {
+	if len(m.records) == 0 {
+		return sortRecord{}, false, nil
+	}
+	r := m.records[0]
+	m.records = m.records[1:]
+	return r, true, nil
+}
+
+type runSource struct {
+	r *bufio.Reader
+}
+
+func (s *runSource) next() (sortRecord, bool, error) {
+	var fields [3]string
+	for i := range fields {
+		n, err := binary.ReadUvarint(s.r)
+		if i == 0 && errors.Is(err, io.EOF) {
+			return sortRecord{}, false, nil
+		}
+		if err != nil {
+			return sortRecord{}, false, fmt.Errorf("could not read sort file: %w", err)
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(s.r, data); err != nil {
+			return sortRecord{}, false, fmt.Errorf("could not read sort file: %w", err)
+		}
+		fields[i] = string(data)
+	}
+	return sortRecord{Key: fields[0], Value: fields[1], Extra: fields[2]}, true, nil
+}
+
+type heapItem struct {
+	record sortRecord
+	source int
+}
+
+type recordHeap []heapItem
+
+func (h recordHeap) Len() int           { return len(h) }
+func (h recordHeap) Less(i, j int) bool { return h[i].record.less(h[j].record) }
+func (h recordHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *recordHeap) Push(x any)        { *h = append(*h, x.(heapItem)) }
+func (h *recordHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// sortIterator merges the sorted sources of an externalSorter.
+type sortIterator struct {
+	sources []recordSource
+	files   []*os.File
+	heap    recordHeap
+	err     error
+}
+
+// peek returns the next record without consuming it.
+func (it *sortIterator) peek() (sortRecord, bool) {
+	if it.err != nil || len(it.heap) == 0 {
+		return sortRecord{}, false
+	}
+	return it.heap[0].record, true
+}
+
+// next consumes and returns the next record.
+func (it *sortIterator) next() (sortRecord, bool) {
+	r, ok := it.peek()
+	if !ok {
+		return r, false
+	}
+	source := it.heap[0].source
+	following, more, err := it.sources[source].next()
+	switch {
+	case err != nil:
+		it.err = err
+	case more:
+		it.heap[0].record = following
+		heap.Fix(&it.heap, 0)
+	default:
+		heap.Pop(&it.heap)
+	}
+	return r, true
+}
+
+func (it *sortIterator) close() {
+	for _, f := range it.files {
+		f.Close()
+	}
+}
@@ -0,0 +1,50 @@
+package checksum
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestExternalSorter(t *testing.T) {
+	dir := t.TempDir()
+	s := newExternalSorter(dir, 1000)
+	rng := rand.New(rand.NewSource(1))
+	var want []sortRecord
+	for i := range 500 {
+		// Few keys, so records with the same key land in different runs
+		r := sortRecord{Key: fmt.Sprintf("key-%02d", rng.Intn(40)), Value: fmt.Sprintf("value-%03d", i), Extra: fmt.Sprint(i % 3)}
+		want = append(want, r)
+		if err := s.add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Slice(want, func(i, j int) bool { return want[i].less(want[j]) })
+	if len(s.runs) < 10 {
+		t.Fatalf("the sorter spilled %d runs, want at least 10", len(s.runs))
+	}
+
+	it, err := s.iterate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []sortRecord
+	for r, ok := it.next(); ok; r, ok = it.next() {
+		got = append(got, r)
+	}
+	it.close()
+	if it.err != nil {
+		t.Fatal(it.err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("the merged runs hold %d records out of order or changed, want the %d added in order", len(got), len(want))
+	}
+
+	s.remove()
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d run files left after remove", len(entries))
+	}
+}
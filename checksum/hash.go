@@ -0,0 +1,88 @@
+package checksum
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// hashAlgorithms maps every supported algorithm to its constructor.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// NewHash returns a hash for the algorithm, MD5 when it is empty or unknown.
+func NewHash(algorithm string) hash.Hash {
+	if newHash, ok := hashAlgorithms[algorithm]; ok {
+		return newHash()
+	}
+	return md5.New()
+}
+
+// AlgorithmName returns the algorithm name with the default filled in.
+func AlgorithmName(algorithm string) string {
+	if algorithm == "" {
+		return "md5"
+	}
+	return algorithm
+}
+
+// DetectAlgorithm guesses the algorithm of a database from the length of
+// its hashes. It returns "" for an empty database.
+func DetectAlgorithm(db Database) string {
+	for hash := range db {
+		for name, newHash := range hashAlgorithms {
+			if len(hash) == newHash().Size()*2 {
+				return name
+			}
+		}
+		return ""
+	}
+	return ""
+}
+
+// HashFile returns the hex encoded digest of the file at filePath and the
+// number of bytes read. Reading stops when ctx is done.
+func HashFile(ctx context.Context, filePath, algorithm string) (string, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := NewHash(algorithm)
+	n, err := io.Copy(hash, contextReader{ctx, file})
+	if err != nil {
+		return "", n, err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), n, nil
+}
+
+// contextReader stops reading once its context is done, and paces reads to
+// the throttle of the context, if any.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	if t := throttleFrom(r.ctx); t != nil {
+		if waitErr := t.waitBytes(r.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
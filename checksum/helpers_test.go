@@ -0,0 +1,92 @@
+package checksum
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// The fixtures shared by the tests of this package. File trees on disk come
+// from checksumtest.WriteTree, which the tests of md5checker use as well.
+
+// testDatabase returns a database of n paths in a few directories, with
+// hashes that do not compress, so it spans several encryption segments once
+// n is in the thousands.
+func testDatabase(n int) Database {
+	files := make(map[string]string, n)
+	for i := range n {
+		sum := md5.Sum([]byte(fmt.Sprint(i)))
+		files[fmt.Sprintf("dir%d/file-%d.txt", i%7, i)] = hex.EncodeToString(sum[:])
+	}
+	return NewDatabase(files)
+}
+
+// testKeyFile returns the key of a key file filled with b.
+func testKeyFile(t *testing.T, b byte) *DatabaseKey {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "db.key")
+	if err := os.WriteFile(path, bytes.Repeat([]byte{b}, 32), 0600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadDatabaseKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// scanTestTree validates opts and adds the files it selects to checksumDB.
+func scanTestTree(t *testing.T, opts Options, checksumDB Database) *ScanSummary {
+	t.Helper()
+	if err := opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := (&Scanner{Options: opts}).Update(context.Background(), checksumDB, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return summary
+}
+
+// verifyTestTree validates opts and verifies the tree against the database
+// saved at its database path.
+func verifyTestTree(t *testing.T, opts Options) *VerifyReport {
+	t.Helper()
+	if err := opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	report, err := (&Verifier{Options: opts}).Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// sortedPaths returns the paths of the database, slash-separated and
+// sorted.
+func sortedPaths(checksumDB Database) []string {
+	var paths []string
+	for path := range checksumDB.PathIndex() {
+		paths = append(paths, filepath.ToSlash(path))
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// reportedPaths lists the results of a report as "CATEGORY path", in the
+// order of Categories.
+func reportedPaths(report *VerifyReport) []string {
+	var reported []string
+	for _, category := range Categories {
+		for _, r := range report.Results[category] {
+			reported = append(reported, category+" "+filepath.ToSlash(r.Path))
+		}
+	}
+	return reported
+}
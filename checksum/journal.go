@@ -0,0 +1,252 @@
+package checksum
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Journal record types.
+const (
+	JournalAdded   = "added"
+	JournalRemoved = "removed"
+	JournalChanged = "changed"
+)
+
+// JournalRecord is one change to the database: a path added, removed or
+// whose content changed. Every record holds the hash of the record before
+// it, so rewriting or dropping an earlier record breaks the chain.
+type JournalRecord struct {
+	Seq            int        `json:"Seq"`
+	Time           string     `json:"Time"`
+	Type           string     `json:"Type"`
+	Path           string     `json:"Path"`
+	ContentHash    string     `json:"ContentHash,omitempty"`
+	OldContentHash string     `json:"OldContentHash,omitempty"`
+	Entry          *PathEntry `json:"Entry,omitempty"`
+	Chunks         *ChunkInfo `json:"Chunks,omitempty"`
+	Prev           string     `json:"Prev"`
+	RecordHash     string     `json:"RecordHash"`
+}
+
+// hash returns the SHA-256 of the record without its RecordHash.
+func (r JournalRecord) hash() string {
+	r.RecordHash = ""
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// JournalPath returns the path of the change journal of a database.
+func JournalPath(checksumFilePath string) string {
+	return checksumFilePath + ".journal"
+}
+
+// ReadJournal reads the journal at path and checks its hash chain. A
+// missing journal has no records. The error names the first record that
+// does not chain to the one before it.
+func ReadJournal(path string) ([]JournalRecord, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open journal: %w", err)
+	}
+	defer f.Close()
+
+	var records []JournalRecord
+	prev := ""
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var record JournalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return records, fmt.Errorf("journal line %d is damaged: %w", line, err)
+		}
+		switch {
+		case record.Seq != len(records)+1:
+			return records, fmt.Errorf("journal line %d: record %d follows record %d", line, record.Seq, len(records))
+		case record.Prev != prev:
+			return records, fmt.Errorf("journal record %d does not chain to record %d", record.Seq, len(records))
+		case record.RecordHash != record.hash():
+			return records, fmt.Errorf("journal record %d was altered", record.Seq)
+		}
+		records = append(records, record)
+		prev = record.RecordHash
+	}
+	if err := scanner.Err(); err != nil {
+		return records, fmt.Errorf("could not read journal: %w", err)
+	}
+	return records, nil
+}
+
+// ReplayJournal rebuilds the database from the records up to and including
+// until, or from all records when until is zero. The LastSeen of every path
+// is the time of its last record.
+func ReplayJournal(records []JournalRecord, until time.Time) Database {
+	checksumDB := make(Database)
+	for _, record := range records {
+		recordTime, err := time.Parse(time.RFC3339, record.Time)
+		if err == nil && !until.IsZero() && recordTime.After(until) {
+			break
+		}
+		switch record.Type {
+		case JournalAdded:
+			addJournalPath(checksumDB, record)
+		case JournalRemoved:
+			removeJournalPath(checksumDB, record.OldContentHash, record.Path)
+		case JournalChanged:
+			removeJournalPath(checksumDB, record.OldContentHash, record.Path)
+			addJournalPath(checksumDB, record)
+		}
+	}
+	return checksumDB
+}
+
+func addJournalPath(checksumDB Database, record JournalRecord) {
+	infoData, exists := checksumDB[record.ContentHash]
+	if !exists {
+		infoData = InfoData{
+			ContentMD5:    record.ContentHash,
+			RelativePaths: []PathEntry{},
+			FirstCreated:  record.Time,
+		}
+	}
+	entry := PathEntry{Path: record.Path, FirstSeen: record.Time}
+	if record.Entry != nil {
+		entry = *record.Entry
+	}
+	entry.LastSeen = record.Time
+	infoData.RelativePaths = append(infoData.RelativePaths, entry)
+	if record.Chunks != nil {
+		infoData.Chunks = record.Chunks
+	}
+	infoData.LastContentUpdate = record.Time
+	checksumDB[record.ContentHash] = infoData
+}
+
+func removeJournalPath(checksumDB Database, hash, path string) {
+	infoData, exists := checksumDB[hash]
+	if !exists {
+		return
+	}
+	var newPaths []PathEntry
+	for _, p := range infoData.RelativePaths {
+		if p.Path != path {
+			newPaths = append(newPaths, p)
+		}
+	}
+	if len(newPaths) == 0 {
+		delete(checksumDB, hash)
+		return
+	}
+	infoData.RelativePaths = newPaths
+	checksumDB[hash] = infoData
+}
+
+// Journal is the append-only, hash-chained log of the changes made to a
+// database. It is appended to after every save, recording the difference
+// between the state its records describe and the saved database, so the
+// first append records the whole database as added.
+type Journal struct {
+	path  string
+	state map[string]string
+	seq   int
+	head  string
+}
+
+// OpenJournal reads the journal at path for appending. A journal whose
+// chain is broken is refused.
+func OpenJournal(path string) (*Journal, error) {
+	records, err := ReadJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{path: path, state: ReplayJournal(records, time.Time{}).PathIndex()}
+	if len(records) > 0 {
+		j.seq = records[len(records)-1].Seq
+		j.head = records[len(records)-1].RecordHash
+	}
+	return j, nil
+}
+
+// Len returns the number of records in the journal.
+func (j *Journal) Len() int {
+	return j.seq
+}
+
+// Head returns the hash of the last record, which commits to the whole
+// history before it.
+func (j *Journal) Head() string {
+	return j.head
+}
+
+// Append records the changes between the journal state and the database
+// and returns the number of records written.
+func (j *Journal) Append(checksumDB Database) (int, error) {
+	currentTime := time.Now().UTC().Format(time.RFC3339)
+	index := checksumDB.PathIndex()
+	var records []JournalRecord
+	for _, path := range sortedKeys(index) {
+		hash, oldHash := index[path], j.state[path]
+		if hash == oldHash {
+			continue
+		}
+		record := JournalRecord{Type: JournalAdded, Path: path, ContentHash: hash, Chunks: checksumDB[hash].Chunks}
+		if oldHash != "" {
+			record.Type, record.OldContentHash = JournalChanged, oldHash
+		}
+		if entry := findPathEntry(checksumDB[hash].RelativePaths, path); entry != nil {
+			copied := *entry
+			record.Entry = &copied
+		}
+		records = append(records, record)
+	}
+	for _, path := range sortedKeys(j.state) {
+		if _, exists := index[path]; !exists {
+			records = append(records, JournalRecord{Type: JournalRemoved, Path: path, OldContentHash: j.state[path]})
+		}
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	var buf bytes.Buffer
+	seq, head := j.seq, j.head
+	for i := range records {
+		seq++
+		records[i].Seq, records[i].Time, records[i].Prev = seq, currentTime, head
+		records[i].RecordHash = records[i].hash()
+		head = records[i].RecordHash
+		data, err := json.Marshal(records[i])
+		if err != nil {
+			return 0, err
+		}
+		buf.Write(append(data, '\n'))
+	}
+
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("could not open journal: %w", err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return 0, fmt.Errorf("could not write journal: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return 0, fmt.Errorf("could not write journal: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return 0, fmt.Errorf("could not write journal: %w", err)
+	}
+	j.seq, j.head, j.state = seq, head, index
+	return len(records), nil
+}
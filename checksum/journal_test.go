@@ -0,0 +1,125 @@
+package checksum
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestJournal appends two saves to a new journal: a, b and c added,
+// then a changed, b removed and d added.
+func writeTestJournal(t *testing.T) (path string, first, second Database) {
+	t.Helper()
+	path = JournalPath(filepath.Join(t.TempDir(), DatabaseFileName))
+	first = NewDatabase(map[string]string{"a": "h1", "b": "h2", "dir/c": "h3"})
+	second = NewDatabase(map[string]string{"a": "h4", "dir/c": "h3", "d": "h3"})
+
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, checksumDB := range []Database{first, second} {
+		if _, err := journal.Append(checksumDB); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path, first, second
+}
+
+func TestJournalChain(t *testing.T) {
+	path, _, second := writeTestJournal(t)
+
+	records, err := ReadJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changes []string
+	for i, record := range records {
+		changes = append(changes, record.Type+" "+record.Path)
+		if record.Seq != i+1 {
+			t.Errorf("record %d has Seq %d", i+1, record.Seq)
+		}
+		if i > 0 && record.Prev != records[i-1].RecordHash {
+			t.Errorf("record %d does not chain to the one before it", record.Seq)
+		}
+	}
+	want := []string{"added a", "added b", "added dir/c", "changed a", "added d", "removed b"}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("records = %v, want %v", changes, want)
+	}
+
+	// Reopening continues the chain and finds nothing new to record
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if journal.Len() != len(records) || journal.Head() != records[len(records)-1].RecordHash {
+		t.Errorf("reopened journal at %d, head %s, want %d, %s", journal.Len(), journal.Head(), len(records), records[len(records)-1].RecordHash)
+	}
+	if n, err := journal.Append(second); err != nil || n != 0 {
+		t.Errorf("appending an unchanged database wrote %d records (%v), want none", n, err)
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	path, first, second := writeTestJournal(t)
+	records, err := ReadJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ReplayJournal(records, time.Time{}).PathIndex(); !reflect.DeepEqual(got, second.PathIndex()) {
+		t.Errorf("replay of all records = %v, want %v", got, second.PathIndex())
+	}
+
+	// Both saves ran within the same second; move the second one an hour
+	// on to replay up to a point between them
+	saved, err := time.Parse(time.RFC3339, records[0].Time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 3; i < len(records); i++ {
+		records[i].Time = saved.Add(time.Hour).Format(time.RFC3339)
+	}
+	if got := ReplayJournal(records, saved.Add(time.Minute)).PathIndex(); !reflect.DeepEqual(got, first.PathIndex()) {
+		t.Errorf("replay up to the first save = %v, want %v", got, first.PathIndex())
+	}
+	if got := ReplayJournal(records, saved.Add(-time.Minute)); len(got) != 0 {
+		t.Errorf("replay up to before the first record = %v, want an empty database", got.PathIndex())
+	}
+}
+
+func TestJournalTampered(t *testing.T) {
+	path, _, _ := writeTestJournal(t)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"altered record", bytes.Replace(data, []byte(`"Path":"b"`), []byte(`"Path":"x"`), 1), "record 2 was altered"},
+		{"dropped record", bytes.Join(append(lines[:1:1], lines[2:]...), nil), "record 3 follows record 1"},
+		{"damaged line", append(bytes.Clone(data), "{\n"...), "journal line 7 is damaged"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ReadJournal(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ReadJournal: %v, want an error containing %q", err, tt.wantErr)
+			}
+			if _, err := OpenJournal(path); err == nil {
+				t.Error("OpenJournal accepted a broken chain")
+			}
+		})
+	}
+}
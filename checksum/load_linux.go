@@ -0,0 +1,23 @@
+//go:build linux
+
+package checksum
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// loadAverage returns the 1-minute load average from /proc/loadavg.
+func loadAverage() (float64, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected /proc/loadavg contents")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
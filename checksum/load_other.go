@@ -0,0 +1,8 @@
+//go:build !linux
+
+package checksum
+
+// loadAverage is not available on this platform.
+func loadAverage() (float64, error) {
+	return 0, errLoadUnsupported
+}
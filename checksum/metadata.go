@@ -0,0 +1,131 @@
+package checksum
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// FileOwner is the numeric owner of a file.
+type FileOwner struct {
+	UID uint32 `json:"UID"`
+	GID uint32 `json:"GID"`
+}
+
+// FileMetadata is the file system metadata recorded for a path. Owner is
+// not available on every platform; Xattrs is only recorded on request and
+// maps each extended attribute (including POSIX ACLs) to the MD5 of its
+// value.
+type FileMetadata struct {
+	Mode    string            `json:"Mode"`
+	Size    int64             `json:"Size"`
+	ModTime string            `json:"ModTime"`
+	Owner   *FileOwner        `json:"Owner,omitempty"`
+	Xattrs  map[string]string `json:"Xattrs"`
+}
+
+// readMetadata collects the metadata of the file at filePath without
+// following symlinks.
+func readMetadata(filePath string, withXattrs bool) (*FileMetadata, error) {
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	metadata := &FileMetadata{
+		Mode:    info.Mode().String(),
+		Size:    info.Size(),
+		ModTime: info.ModTime().UTC().Format(time.RFC3339Nano),
+		Owner:   fileOwner(info),
+	}
+	if withXattrs {
+		metadata.Xattrs, err = readXattrs(filePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return metadata, nil
+}
+
+// metadataChanges lists the attributes that differ between the recorded and
+// the current metadata. Extended attributes are only compared when they were
+// recorded.
+func metadataChanges(recorded, current *FileMetadata) []string {
+	var changes []string
+	if recorded.Mode != current.Mode {
+		changes = append(changes, fmt.Sprintf("mode: %s → %s", recorded.Mode, current.Mode))
+	}
+	if recorded.Owner != nil && current.Owner != nil && *recorded.Owner != *current.Owner {
+		changes = append(changes, fmt.Sprintf("owner: %d:%d → %d:%d",
+			recorded.Owner.UID, recorded.Owner.GID, current.Owner.UID, current.Owner.GID))
+	}
+	if recorded.Size != current.Size {
+		changes = append(changes, fmt.Sprintf("size: %d → %d", recorded.Size, current.Size))
+	}
+	if recorded.ModTime != current.ModTime {
+		changes = append(changes, fmt.Sprintf("mtime: %s → %s", recorded.ModTime, current.ModTime))
+	}
+	if recorded.Xattrs != nil {
+		var names []string
+		for name := range recorded.Xattrs {
+			names = append(names, name)
+		}
+		for name := range current.Xattrs {
+			if _, exists := recorded.Xattrs[name]; !exists {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			before, hadBefore := recorded.Xattrs[name]
+			after, hasNow := current.Xattrs[name]
+			switch {
+			case !hadBefore:
+				changes = append(changes, fmt.Sprintf("xattr %s: added", name))
+			case !hasNow:
+				changes = append(changes, fmt.Sprintf("xattr %s: removed", name))
+			case before != after:
+				changes = append(changes, fmt.Sprintf("xattr %s: changed", name))
+			}
+		}
+	}
+	return changes
+}
+
+// checkMetadata moves OK results whose recorded metadata no longer matches
+// the file on disk to METADATA_CHANGED. Only paths recorded with metadata
+// are checked.
+func checkMetadata(baseLocationPath string, checksumDB Database, results map[string][]Result) {
+	var stillOK []Result
+	for _, r := range results["OK"] {
+		var recorded *FileMetadata
+		if pathEntry := findPathEntry(checksumDB[r.ContentHash].RelativePaths, r.Path); pathEntry != nil {
+			recorded = pathEntry.Metadata
+		}
+		if changes := changedMetadata(baseLocationPath, r.Path, recorded); len(changes) > 0 {
+			r.ChangedAttributes = changes
+			results["METADATA_CHANGED"] = append(results["METADATA_CHANGED"], r)
+		} else {
+			stillOK = append(stillOK, r)
+		}
+	}
+	if stillOK == nil {
+		stillOK = []Result{}
+	}
+	results["OK"] = stillOK
+}
+
+// changedMetadata returns the differences between the recorded metadata of
+// a path and the file on disk, none when nothing was recorded or the file
+// cannot be read.
+func changedMetadata(baseLocationPath, relPath string, recorded *FileMetadata) []string {
+	if recorded == nil {
+		return nil
+	}
+	current, err := readMetadata(filepath.Join(baseLocationPath, relPath), recorded.Xattrs != nil)
+	if err != nil {
+		return nil
+	}
+	return metadataChanges(recorded, current)
+}
@@ -0,0 +1,19 @@
+//go:build !unix
+
+package checksum
+
+import "os"
+
+// fileOwner is not supported on this platform.
+func fileOwner(info os.FileInfo) *FileOwner {
+	return nil
+}
+
+// fileID identifies a file independently of its path.
+type fileID struct{}
+
+// hardlinkID is not supported on this platform, so hardlinks are hashed
+// once per path.
+func hardlinkID(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
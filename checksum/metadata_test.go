@@ -0,0 +1,80 @@
+package checksum
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"md5checker/checksum/checksumtest"
+)
+
+func TestMetadataChanges(t *testing.T) {
+	recorded := &FileMetadata{
+		Mode:    "-rw-r-----",
+		Size:    10,
+		ModTime: "2026-01-01T00:00:00Z",
+		Owner:   &FileOwner{UID: 0, GID: 42},
+		Xattrs:  map[string]string{"user.kept": "a", "user.changed": "b", "user.removed": "c"},
+	}
+	current := &FileMetadata{
+		Mode:    "-rwxrwxrwx",
+		Size:    10,
+		ModTime: "2026-01-02T00:00:00Z",
+		Owner:   &FileOwner{UID: 1000, GID: 1000},
+		Xattrs:  map[string]string{"user.kept": "a", "user.changed": "B", "user.added": "d"},
+	}
+	want := []string{
+		"mode: -rw-r----- → -rwxrwxrwx",
+		"owner: 0:42 → 1000:1000",
+		"mtime: 2026-01-01T00:00:00Z → 2026-01-02T00:00:00Z",
+		"xattr user.added: added",
+		"xattr user.changed: changed",
+		"xattr user.removed: removed",
+	}
+	if got := metadataChanges(recorded, current); !reflect.DeepEqual(got, want) {
+		t.Errorf("metadataChanges = %q, want %q", got, want)
+	}
+
+	// Attributes that were not recorded are not compared
+	recorded.Owner, recorded.Xattrs = nil, nil
+	if got := metadataChanges(recorded, current); len(got) != 2 {
+		t.Errorf("metadataChanges without owner and xattrs = %q, want mode and mtime only", got)
+	}
+	if got := metadataChanges(current, current); got != nil {
+		t.Errorf("metadataChanges of the same metadata = %q, want none", got)
+	}
+}
+
+func TestMetadataTouchAcceptedByAdd(t *testing.T) {
+	root := checksumtest.WriteTree(t, map[string]string{"a.txt": "alpha", "b.txt": "bravo"})
+	opts := Options{Root: root, Metadata: true}
+	checksumDB := make(Database)
+	save := func() {
+		if err := checksumDB.Save(filepath.Join(root, DatabaseFileName), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scanTestTree(t, opts, checksumDB)
+	save()
+
+	touched := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(root, "a.txt"), touched, touched); err != nil {
+		t.Fatal(err)
+	}
+	report := verifyTestTree(t, opts)
+	if got, want := reportedPaths(report), []string{"OK b.txt", "METADATA_CHANGED a.txt"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("verify after a touch reported %v, want %v", got, want)
+	}
+
+	// Adding again records the new mtime, as the content is unchanged
+	if summary := scanTestTree(t, opts, checksumDB); summary.Added != 0 {
+		t.Errorf("add after a touch added %d paths, want 0", summary.Added)
+	}
+	save()
+	report = verifyTestTree(t, opts)
+	if got, want := reportedPaths(report), []string{"OK a.txt", "OK b.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("verify after add reported %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package checksum
+
+import (
+	"os"
+	"syscall"
+)
+
+func fileOwner(info os.FileInfo) *FileOwner {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &FileOwner{UID: uint32(stat.Uid), GID: uint32(stat.Gid)}
+}
+
+// fileID identifies a file independently of its path.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// hardlinkID returns the identity of a file with more than one link.
+func hardlinkID(info os.FileInfo) (fileID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
@@ -0,0 +1,186 @@
+package checksum
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Options holds the settings shared by the Scanner and the Verifier.
+type Options struct {
+	// Root is the directory to scan, the working directory when empty.
+	// Database is the path of the checksum database, relative to Root
+	// unless absolute, and checksums.json.gz when empty.
+	Root     string
+	Database string
+	// Algorithm is the content hash: md5 (default), sha1, sha256 or sha512.
+	Algorithm string
+	// Include and Exclude are glob patterns matched against the file name,
+	// or against the relative path when they contain a slash. "**" matches
+	// any number of directories. A nil Exclude uses DefaultExcludes.
+	Include []string
+	Exclude []string
+	// Selection further filters the files by size, age, extension and
+	// hidden names. A verify with the zero Selection uses the one recorded
+	// in the database.
+	Selection Selection
+	// Paths, if set, limits a scan to these paths relative to the root:
+	// files, directories with everything below them, or patterns as in
+	// Include matched against the whole path. Database paths outside them
+	// are neither compared, reported as deleted, nor pruned.
+	Paths []string
+	// Concurrency is the number of files hashed in parallel.
+	Concurrency int
+	// ReadTimeout, if set, gives up on a file that takes longer to read,
+	// e.g. on a hung network mount.
+	ReadTimeout time.Duration
+	// IgnoreFiles are further paths that are never scanned, such as files
+	// the caller writes next to the database.
+	IgnoreFiles []string
+
+	// Archives descends into zip, tar and gzip archives and records each
+	// member under a virtual path.
+	Archives bool
+	// ChunkMode is "fixed" or "cdc" to store chunk hashes per entry, so
+	// modifications can be located inside large files. ChunkSize is the
+	// (average) chunk size in bytes.
+	ChunkMode string
+	ChunkSize int64
+	// Metadata records mode, owner, size and mtime per path; Xattrs also
+	// records extended attributes and ACLs.
+	Metadata bool
+	Xattrs   bool
+	// Symlinks is the symlink policy: hash (default), record, follow or
+	// skip.
+	Symlinks string
+
+	// MaxBytesPerSec and MaxFilesPerSec cap the rate at which files are
+	// read, 0 meaning no limit. MaxLoad pauses hashing while the 1-minute
+	// load average is above it (Linux only).
+	MaxBytesPerSec int64
+	MaxFilesPerSec float64
+	MaxLoad        float64
+
+	// Key, if set, is used to check the database signature before the
+	// database is trusted. A verify with a missing or bad signature fails,
+	// or with SignatureWarn only flags it in the report.
+	Key           *Key
+	SignatureWarn bool
+
+	// DatabaseKey decrypts an encrypted database.
+	DatabaseKey *DatabaseKey
+
+	// MemoryLimit, if set, is the memory in bytes a verify may use for the
+	// database and the hashed files. Both are streamed and sorted on disk
+	// instead of being held in memory, and OK files are only counted.
+	MemoryLimit int64
+
+	// Shard, if set, verifies a single shard of a sharded database: the
+	// key of the shard, "." for the files in the root. Only the files of
+	// the shard are scanned.
+	Shard string
+
+	// Sidecars keeps the database as one SidecarFileName manifest per
+	// directory instead of a single database file.
+	Sidecars bool
+
+	// pathFilter, if set, further restricts the scanned files and the
+	// directories walked.
+	pathFilter func(relPath string, isDir bool) bool
+}
+
+// DatabaseFileName is the default name of the checksum database in the
+// scanned root.
+const DatabaseFileName = "checksums.json.gz"
+
+// DefaultExcludes are the exclude patterns used when none are configured:
+// the tool's own files and all md5checker binaries.
+var DefaultExcludes = []string{"0", DatabaseFileName, DatabaseFileName + ".sig", DatabaseFileName + ".tree", DatabaseFileName + ".journal", DatabaseFileName + ".shards", "checksums.history.jsonl", "checksums.checkpoint.jsonl", "checksums.sample.json", "checksums.report.*", "checksums.snapshots", SidecarFileName, "md5checker*"}
+
+// RootPath returns the absolute directory to scan.
+func (o Options) RootPath() string {
+	if o.Root == "" {
+		wd, _ := os.Getwd()
+		return wd
+	}
+	root, err := filepath.Abs(o.Root)
+	if err != nil {
+		return o.Root
+	}
+	return root
+}
+
+// DatabasePath returns the absolute path of the checksum database.
+func (o Options) DatabasePath() string {
+	if o.Database == "" {
+		return filepath.Join(o.RootPath(), DatabaseFileName)
+	}
+	if filepath.IsAbs(o.Database) {
+		return o.Database
+	}
+	return filepath.Join(o.RootPath(), o.Database)
+}
+
+// DatabaseDir returns the directory holding the database.
+func (o Options) DatabaseDir() string {
+	return filepath.Dir(o.DatabasePath())
+}
+
+func (o Options) excludePatterns() []string {
+	if o.Exclude == nil {
+		return DefaultExcludes
+	}
+	return o.Exclude
+}
+
+// Validate checks the options and fills in defaults.
+func (o *Options) Validate() error {
+	if !validChunkMode(o.ChunkMode) {
+		return fmt.Errorf("invalid chunk mode '%s', expected 'fixed' or 'cdc'", o.ChunkMode)
+	}
+	if !validSymlinkPolicy(o.Symlinks) {
+		return fmt.Errorf("invalid symlink policy '%s', expected 'hash', 'record', 'follow' or 'skip'", o.Symlinks)
+	}
+	if o.Algorithm == "" {
+		o.Algorithm = "md5"
+	}
+	if _, ok := hashAlgorithms[o.Algorithm]; !ok {
+		return fmt.Errorf("invalid hash algorithm '%s', expected 'md5', 'sha1', 'sha256' or 'sha512'", o.Algorithm)
+	}
+	for _, pattern := range append(o.Include, o.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+		}
+	}
+	if err := o.Selection.validate(); err != nil {
+		return err
+	}
+	scope, err := normalizeScope(o.Paths)
+	if err != nil {
+		return err
+	}
+	o.Paths = scope
+	if o.MaxBytesPerSec < 0 || o.MaxFilesPerSec < 0 || o.MaxLoad < 0 || o.MemoryLimit < 0 {
+		return fmt.Errorf("rate, load and memory limits must not be negative")
+	}
+	if o.Sidecars && (o.Shard != "" || o.MemoryLimit > 0 || o.Key != nil) {
+		return fmt.Errorf("sidecar manifests cannot be combined with shards, a memory limit or signatures")
+	}
+	if o.MaxLoad > 0 {
+		if _, err := loadAverage(); err != nil {
+			return err
+		}
+	}
+	if o.Concurrency < 1 {
+		o.Concurrency = 1
+	}
+	if o.ChunkSize == 0 {
+		o.ChunkSize = defaultChunkSize
+	}
+	if info, err := os.Stat(o.RootPath()); err != nil || !info.IsDir() {
+		return fmt.Errorf("root '%s' is not a directory", o.RootPath())
+	}
+	return nil
+}
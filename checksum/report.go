@@ -0,0 +1,235 @@
+package checksum
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxRangesShown limits the changed byte ranges written per modified file.
+const maxRangesShown = 10
+
+// WriteText writes the report in the human readable layout of the
+// command line tool.
+func (report *VerifyReport) WriteText(w io.Writer) {
+	results := report.Results
+
+	// Output results
+	fmt.Fprintln(w, "\n╔════════════════════════════════════════════════════════════════╗")
+	fmt.Fprintln(w, "║              VERIFICATION RESULTS SUMMARY                      ║")
+	fmt.Fprintln(w, "╚════════════════════════════════════════════════════════════════╝")
+	fmt.Fprintf(w, "  Total files on disk checked: %d\n", report.FilesChecked)
+	fmt.Fprintf(w, "  Total unique checksums in DB: %d\n", report.UniqueChecksums)
+	fmt.Fprintf(w, "  Database: %s\n", report.Database)
+	if report.Shard != "" {
+		fmt.Fprintf(w, "  Shard: %s (files of other shards are not checked)\n", report.Shard)
+	}
+	if report.Selection != nil {
+		fmt.Fprintf(w, "  Selection: %s\n", report.Selection)
+	}
+	if len(report.Scope) > 0 {
+		fmt.Fprintf(w, "  Scope: %s (files outside it are not checked)\n", strings.Join(report.Scope, ", "))
+	}
+	if report.SignedBy != "" {
+		fmt.Fprintf(w, "  Signature: ✓ valid, signed by key %s\n", report.SignedBy)
+	}
+	if report.SignatureError != "" {
+		fmt.Fprintf(w, "  ⚠ SIGNATURE INVALID: %s\n", report.SignatureError)
+		fmt.Fprintln(w, "  ⚠ The database may have been tampered with; the results below cannot be trusted.")
+	}
+	if sample := report.Sample; sample != nil {
+		fmt.Fprintf(w, "  Sample: %d of %d files (%.1f%%)", sample.FilesSampled, sample.FilesTotal, percentOf(sample.FilesSampled, sample.FilesTotal))
+		if sample.BytesTotal > 0 {
+			fmt.Fprintf(w, ", %s of %s", FormatBytes(sample.BytesSampled), FormatBytes(sample.BytesTotal))
+		}
+		fmt.Fprintf(w, ", seed %d\n", sample.Seed)
+		fmt.Fprintf(w, "  Coverage: cycle %d run %d, %d of %d files checked this cycle (%.1f%%)", sample.Cycle, sample.Run, sample.CycleCovered, sample.FilesTotal, percentOf(sample.CycleCovered, sample.FilesTotal))
+		if sample.CycleComplete {
+			fmt.Fprint(w, ", cycle complete")
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "  Confidence: %.0f%% that at most %.2f%% of all files are damaged\n", sample.Confidence*100, sample.MaxDamagedPercent)
+	}
+	if report.Incomplete {
+		fmt.Fprintf(w, "  ⚠ INCOMPLETE: interrupted, %d files were not checked\n", report.FilesPending)
+	}
+	fmt.Fprintln(w, "────────────────────────────────────────────────────────────────")
+
+	if report.OKNotListed > 0 {
+		fmt.Fprintf(w, "\n✓ OK (%d): not listed, verified within a memory limit\n", report.OKNotListed)
+	}
+	WriteResults(w, results)
+	WriteSkipped(w, report.Skipped)
+	writeFileErrors(w, report.Errors)
+	WriteWarnings(w, report.Warnings)
+
+	fmt.Fprintln(w, "────────────────────────────────────────────────────────────────")
+	totalDiscrepancies := report.Discrepancies()
+	if report.Incomplete {
+		fmt.Fprintf(w, "⚠ Verification incomplete: %d discrepancies among the files checked so far.\n", totalDiscrepancies)
+	} else if totalDiscrepancies == 0 {
+		fmt.Fprintln(w, "✓ All files are verified and match the checksum database.")
+	} else {
+		fmt.Fprintf(w, "⚠ Found %d discrepancies. Review the details above.\n", totalDiscrepancies)
+	}
+	fmt.Fprintln(w, "════════════════════════════════════════════════════════════════")
+}
+
+// percentOf returns n as a percentage of total.
+func percentOf(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+// WriteResults writes every non-empty result category.
+func WriteResults(w io.Writer, results map[string][]Result) {
+	writeResults(w, "OK", results["OK"])
+	writeResults(w, "MODIFIED", results["MODIFIED"])
+	writeResults(w, "METADATA_CHANGED", results["METADATA_CHANGED"])
+	writeResults(w, "RENAMED", results["RENAMED"])
+	writeResults(w, "MOVED", results["MOVED"])
+	writeResults(w, "NEW", results["NEW"])
+	writeResults(w, "DELETED", results["DELETED"])
+}
+
+func writeResults(w io.Writer, category string, results []Result) {
+	if len(results) == 0 {
+		return
+	}
+
+	// Use colored symbols
+	symbol := "•"
+	switch category {
+	case "OK":
+		symbol = "✓"
+	case "MODIFIED", "METADATA_CHANGED":
+		symbol = "⚠"
+	case "MOVED", "RENAMED":
+		symbol = "↔"
+	case "NEW":
+		symbol = "+"
+	case "DELETED":
+		symbol = "✗"
+	}
+
+	fmt.Fprintf(w, "\n%s %s (%d):\n", symbol, category, len(results))
+	for _, r := range results {
+		switch category {
+		case "OK":
+			fmt.Fprintf(w, "  • %s\n", r.Path)
+		case "MODIFIED":
+			fmt.Fprintf(w, "  • %s\n", r.Path)
+			fmt.Fprintf(w, "    Original: %s\n", r.OriginalContentHash[:8]+"...")
+			fmt.Fprintf(w, "    Current:  %s\n", r.ContentHash[:8]+"...")
+			if len(r.ChangedRanges) > 0 {
+				fmt.Fprintf(w, "    Changed:  %d range(s), %.2f%% of the file\n", len(r.ChangedRanges), r.ChangedPercent)
+				for i, changed := range r.ChangedRanges {
+					if i == maxRangesShown {
+						fmt.Fprintf(w, "      … and %d more\n", len(r.ChangedRanges)-maxRangesShown)
+						break
+					}
+					fmt.Fprintf(w, "      bytes %s\n", FormatByteRange(changed))
+				}
+			}
+		case "METADATA_CHANGED":
+			fmt.Fprintf(w, "  • %s\n", r.Path)
+			for _, change := range r.ChangedAttributes {
+				fmt.Fprintf(w, "    %s\n", change)
+			}
+		case "MOVED":
+			fmt.Fprintf(w, "  • %s\n", r.Path)
+			fmt.Fprintf(w, "    Hash: %s\n", r.ContentHash[:8]+"...")
+			fmt.Fprintf(w, "    Previously at: %s\n", strings.Join(r.KnownOldPaths, ", "))
+		case "NEW":
+			fmt.Fprintf(w, "  • %s (Hash: %s)\n", r.Path, r.ContentHash[:8]+"...")
+		case "DELETED":
+			fmt.Fprintf(w, "  • %s (Hash: %s)\n", r.Path, r.OriginalContentHash[:8]+"...")
+		case "RENAMED":
+			fmt.Fprintf(w, "  • Hash: %s\n", r.ContentHash[:8]+"...")
+			fmt.Fprintf(w, "    Old path(s): %s\n", strings.Join(r.OldPaths, ", "))
+			fmt.Fprintf(w, "    New path(s): %s\n", strings.Join(r.NewPaths, ", "))
+		}
+	}
+}
+
+// WriteSkipped lists the paths the scanner did not hash.
+func WriteSkipped(w io.Writer, skipped []SkippedFile) {
+	if len(skipped) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n⊘ SKIPPED (%d):\n", len(skipped))
+	for _, s := range skipped {
+		fmt.Fprintf(w, "  • %s (%s)\n", s.Path, s.Reason)
+	}
+}
+
+func writeFileErrors(w io.Writer, errs []FileError) {
+	if len(errs) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n⊘ UNREADABLE (%d):\n", len(errs))
+	for _, e := range errs {
+		fmt.Fprintf(w, "  • %s (%s)\n", e.Path, e.Error)
+	}
+}
+
+// WriteWarnings lists the archives that were hashed as single files.
+func WriteWarnings(w io.Writer, warnings []FileError) {
+	if len(warnings) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n⚠ WARNINGS (%d):\n", len(warnings))
+	for _, e := range warnings {
+		fmt.Fprintf(w, "  • %s (%s)\n", e.Path, e.Error)
+	}
+}
+
+// WriteJSON writes the report as indented JSON.
+func (report *VerifyReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// WriteCSV writes one row per result. RENAMED results list their old and
+// new paths in the Details column.
+func (report *VerifyReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Category", "Path", "ContentHash", "OriginalContentHash", "Details"})
+	if report.SignatureError != "" {
+		cw.Write([]string{"SIGNATURE_INVALID", report.Database, "", "", report.SignatureError})
+	}
+	for _, category := range Categories {
+		for _, r := range report.Results[category] {
+			var details string
+			switch category {
+			case "MOVED":
+				details = "previously at " + strings.Join(r.KnownOldPaths, "; ")
+			case "RENAMED":
+				details = strings.Join(r.OldPaths, "; ") + " -> " + strings.Join(r.NewPaths, "; ")
+			case "METADATA_CHANGED":
+				details = strings.Join(r.ChangedAttributes, "; ")
+			case "MODIFIED":
+				if len(r.ChangedRanges) > 0 {
+					details = fmt.Sprintf("%d range(s), %.2f%% changed", len(r.ChangedRanges), r.ChangedPercent)
+				}
+			}
+			cw.Write([]string{category, r.Path, r.ContentHash, r.OriginalContentHash, details})
+		}
+	}
+	for _, s := range report.Skipped {
+		cw.Write([]string{"SKIPPED", s.Path, "", "", s.Reason})
+	}
+	for _, e := range report.Errors {
+		cw.Write([]string{"UNREADABLE", e.Path, "", "", e.Error})
+	}
+	for _, e := range report.Warnings {
+		cw.Write([]string{"WARNING", e.Path, "", "", e.Error})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,261 @@
+package checksum
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// sampleConfidence is the confidence level of the damage bound reported for
+// a sampled verification, and sampleZ its one-sided normal quantile: only
+// the upper end of the interval is reported.
+const (
+	sampleConfidence = 0.95
+	sampleZ          = 1.644854
+)
+
+// Sample turns a verification into a spot-check of part of the database.
+// Each run checks the next files of a seeded random order, so consecutive
+// runs cover the whole database once per cycle before a new order is drawn.
+type Sample struct {
+	// Files is the number of files checked per run and Percent the share of
+	// the total bytes. Window, if set, is the number of runs a cycle should
+	// take, and raises the files per run to cover the database in time.
+	// When several are set, a run checks enough files to meet all of them.
+	Files   int
+	Percent float64
+	Window  int
+	// State is where the rotation stands. Verify advances it after a
+	// complete run; callers persist it between runs.
+	State SampleState
+}
+
+// SampleState is the position of a sampled verification in its cycle.
+// Checked holds the sample keys of the files checked so far in the cycle,
+// so files added to the database during a cycle are still checked in it
+// and are not counted as covered before they are.
+type SampleState struct {
+	Seed         uint64   `json:"Seed"`
+	Cycle        int      `json:"Cycle"`
+	Runs         int      `json:"Runs"`
+	Checked      []uint64 `json:"Checked,omitempty"`
+	CycleStarted string   `json:"CycleStarted,omitempty"`
+}
+
+// SampleCoverage describes what a sampled verification covered, and how
+// much the result says about the unchecked files.
+type SampleCoverage struct {
+	Seed          uint64 `json:"Seed"`
+	Cycle         int    `json:"Cycle"`
+	Run           int    `json:"Run"`
+	FilesSampled  int    `json:"FilesSampled"`
+	FilesTotal    int    `json:"FilesTotal"`
+	BytesSampled  int64  `json:"BytesSampled,omitempty"`
+	BytesTotal    int64  `json:"BytesTotal,omitempty"`
+	CycleCovered  int    `json:"CycleCovered"`
+	CycleComplete bool   `json:"CycleComplete"`
+	// Confidence is the confidence level at which at most MaxDamagedPercent
+	// of all files are damaged, given the damaged files in the sample.
+	Confidence        float64 `json:"Confidence"`
+	MaxDamagedPercent float64 `json:"MaxDamagedPercent"`
+}
+
+// LoadSampleState reads the rotation state of sampled verifications. A
+// missing file is the start of the first cycle.
+func LoadSampleState(path string) (SampleState, error) {
+	var state SampleState
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("could not read sample state '%s': %w", path, err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("could not parse sample state '%s': %w", path, err)
+	}
+	return state, nil
+}
+
+// Save writes the rotation state of sampled verifications.
+func (s SampleState) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("could not write sample state '%s': %w", path, err)
+	}
+	return nil
+}
+
+// sampleUnit is one file on disk in the sample order. Archive members are
+// sampled with their archive.
+type sampleUnit struct {
+	path string
+	key  uint64
+	size int64
+}
+
+// sampleSelection is the outcome of selectSample: the files to check and
+// the state after checking them.
+type sampleSelection struct {
+	paths    map[string]bool
+	next     SampleState
+	coverage SampleCoverage
+}
+
+// sampleKey orders the files of a cycle. Deriving it from the path keeps
+// the order stable when files are added to or removed from the database
+// during a cycle.
+func sampleKey(seed uint64, cycle int, path string) uint64 {
+	sum := sha256.Sum256([]byte(strconv.FormatUint(seed, 10) + ":" + strconv.Itoa(cycle) + ":" + path))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// selectSample picks the files of the next run from the database.
+func (s *Sample) selectSample(baseLocationPath string, checksumDB Database) (*sampleSelection, error) {
+	if s.Files < 0 || s.Percent < 0 || s.Percent > 100 || s.Window < 0 {
+		return nil, fmt.Errorf("invalid sample size")
+	}
+	state := s.State
+	if state.CycleStarted == "" {
+		state.CycleStarted = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	seen := make(map[string]bool)
+	var units []sampleUnit
+	for _, infoData := range checksumDB {
+		for _, p := range infoData.RelativePaths {
+			path := p.Path
+			if archivePath, _, ok := splitArchivePath(path); ok {
+				path = archivePath
+			}
+			if !seen[path] {
+				seen[path] = true
+				units = append(units, sampleUnit{path: path, key: sampleKey(state.Seed, state.Cycle, path)})
+			}
+		}
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].key < units[j].key })
+
+	coverage := SampleCoverage{Seed: state.Seed, Cycle: state.Cycle + 1, Run: state.Runs + 1, FilesTotal: len(units), Confidence: sampleConfidence}
+	limit := s.Files
+	if s.Window > 0 {
+		limit = max(limit, (len(units)+s.Window-1)/s.Window)
+	}
+	var byteLimit int64
+	if s.Percent > 0 {
+		for i := range units {
+			if info, err := os.Stat(filepath.Join(baseLocationPath, units[i].path)); err == nil {
+				units[i].size = info.Size()
+			}
+			coverage.BytesTotal += units[i].size
+		}
+		byteLimit = int64(math.Ceil(float64(coverage.BytesTotal) * s.Percent / 100))
+	}
+
+	// Files already checked in this cycle are skipped. Checked keys of
+	// files no longer in the database are dropped.
+	checked := make(map[uint64]bool, len(state.Checked))
+	for _, key := range state.Checked {
+		checked[key] = true
+	}
+	var covered []uint64
+	var remaining []sampleUnit
+	for _, unit := range units {
+		if checked[unit.key] {
+			covered = append(covered, unit.key)
+		} else {
+			remaining = append(remaining, unit)
+		}
+	}
+
+	selection := &sampleSelection{paths: make(map[string]bool)}
+	taken := 0
+	for taken < len(remaining) {
+		if (limit == 0 || taken >= limit) && (byteLimit == 0 || coverage.BytesSampled >= byteLimit) {
+			break
+		}
+		unit := remaining[taken]
+		selection.paths[unit.path] = true
+		coverage.BytesSampled += unit.size
+		taken++
+	}
+	coverage.FilesSampled = taken
+	coverage.CycleCovered = len(covered) + taken
+
+	// Advance the rotation, starting a new order once every file was covered
+	state.Runs++
+	for _, unit := range remaining[:taken] {
+		covered = append(covered, unit.key)
+	}
+	slices.Sort(covered)
+	state.Checked = covered
+	if taken == len(remaining) {
+		coverage.CycleComplete = true
+		state = SampleState{Seed: state.Seed, Cycle: state.Cycle + 1}
+	}
+	selection.next = state
+	selection.coverage = coverage
+	return selection, nil
+}
+
+// existingFiles returns the absolute paths of the sampled files that are
+// still on disk, in a stable order. Missing files are reported as DELETED.
+func existingFiles(baseLocationPath string, paths map[string]bool) []string {
+	var files []string
+	for path := range paths {
+		filePath := filepath.Join(baseLocationPath, path)
+		if _, err := os.Lstat(filePath); err == nil {
+			files = append(files, filePath)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// restrictDatabase returns the part of the database stored at the sampled
+// paths, including the members of sampled archives.
+func restrictDatabase(checksumDB Database, paths map[string]bool) Database {
+	restricted := make(Database)
+	for hash, infoData := range checksumDB {
+		var kept []PathEntry
+		for _, p := range infoData.RelativePaths {
+			path := p.Path
+			if archivePath, _, ok := splitArchivePath(path); ok {
+				path = archivePath
+			}
+			if paths[path] {
+				kept = append(kept, p)
+			}
+		}
+		if len(kept) > 0 {
+			infoData.RelativePaths = kept
+			restricted[hash] = infoData
+		}
+	}
+	return restricted
+}
+
+// damageBound returns the one-sided upper Wilson score bound for the share
+// of damaged files, in percent, after finding damaged of n sampled files
+// damaged.
+func damageBound(damaged, n int) float64 {
+	if n == 0 {
+		return 100
+	}
+	p := float64(damaged) / float64(n)
+	z2 := sampleZ * sampleZ
+	nf := float64(n)
+	upper := (p + z2/(2*nf) + sampleZ*math.Sqrt(p*(1-p)/nf+z2/(4*nf*nf))) / (1 + z2/nf)
+	return math.Min(upper, 1) * 100
+}
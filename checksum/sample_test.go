@@ -0,0 +1,159 @@
+package checksum
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// runSample selects the next sample and advances the state like a complete
+// verification.
+func runSample(t *testing.T, s *Sample, checksumDB Database) *sampleSelection {
+	t.Helper()
+	selection, err := s.selectSample(t.TempDir(), checksumDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.State = selection.next
+	return selection
+}
+
+func TestSelectSampleRotation(t *testing.T) {
+	checksumDB := testDatabase(100)
+	s := &Sample{Files: 30, State: SampleState{Seed: 7}}
+
+	checked := make(map[string]int)
+	var sizes []int
+	for run := 1; run <= 4; run++ {
+		selection := runSample(t, s, checksumDB)
+		sizes = append(sizes, len(selection.paths))
+		for path := range selection.paths {
+			checked[path]++
+		}
+		if c := selection.coverage; c.Run != run || c.CycleComplete != (run == 4) {
+			t.Errorf("run %d: coverage %+v", run, c)
+		}
+	}
+	if want := []int{30, 30, 30, 10}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("sample sizes = %v, want %v", sizes, want)
+	}
+	if len(checked) != 100 {
+		t.Errorf("a cycle checked %d of 100 files", len(checked))
+	}
+	for path, n := range checked {
+		if n != 1 {
+			t.Errorf("%s was checked %d times in one cycle", path, n)
+		}
+	}
+	if want := (SampleState{Seed: 7, Cycle: 1}); !reflect.DeepEqual(s.State, want) {
+		t.Errorf("state after a complete cycle = %+v, want %+v", s.State, want)
+	}
+
+	// The next cycle draws a new order
+	first := runSample(t, s, checksumDB)
+	again := runSample(t, &Sample{Files: 30, State: SampleState{Seed: 7}}, checksumDB)
+	if reflect.DeepEqual(first.paths, again.paths) {
+		t.Error("the second cycle checks the files in the same order as the first")
+	}
+}
+
+func TestSelectSampleDatabaseChanges(t *testing.T) {
+	s := &Sample{Files: 5}
+	runSample(t, s, testDatabase(10))
+
+	// A file added during the cycle is still checked in it, and a removed
+	// file no longer counts as covered
+	files := make(map[string]string)
+	for path, hash := range testDatabase(10).PathIndex() {
+		files[path] = hash
+	}
+	files["added.txt"] = "h-added"
+	var removed string
+	for path := range files {
+		if path != "added.txt" && stateCovers(s.State, path) {
+			removed = path
+			break
+		}
+	}
+	delete(files, removed)
+
+	s.Files = 6
+	selection := runSample(t, s, NewDatabase(files))
+	if !selection.paths["added.txt"] || len(selection.paths) != 6 {
+		t.Errorf("second run checked %d files (added.txt: %v), want the 6 unchecked ones", len(selection.paths), selection.paths["added.txt"])
+	}
+	if c := selection.coverage; !c.CycleComplete || c.CycleCovered != 10 || c.FilesTotal != 10 {
+		t.Errorf("coverage = %+v, want all 10 files covered", c)
+	}
+}
+
+// stateCovers reports whether the path was checked in the current cycle.
+func stateCovers(s SampleState, path string) bool {
+	key := sampleKey(s.Seed, s.Cycle, path)
+	for _, k := range s.Checked {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSelectSampleWindow(t *testing.T) {
+	s := &Sample{Files: 1, Window: 4}
+	if selection := runSample(t, s, testDatabase(100)); len(selection.paths) != 25 {
+		t.Errorf("a 4-run window checked %d of 100 files, want 25", len(selection.paths))
+	}
+	for _, invalid := range []Sample{{Files: -1}, {Percent: 101}, {Window: -2}} {
+		if _, err := invalid.selectSample(t.TempDir(), testDatabase(1)); err == nil {
+			t.Errorf("%+v was accepted", invalid)
+		}
+	}
+}
+
+func TestSampleStatePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checksums.sample.json")
+	if state, err := LoadSampleState(path); err != nil || !reflect.DeepEqual(state, SampleState{}) {
+		t.Errorf("missing state = %+v, %v, want the start of the first cycle", state, err)
+	}
+
+	s := &Sample{Files: 3, State: SampleState{Seed: 42}}
+	runSample(t, s, testDatabase(10))
+	if err := s.State.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSampleState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, s.State) {
+		t.Errorf("loaded state %+v, want %+v", loaded, s.State)
+	}
+
+	// A run resumed from the saved state picks up where the last one stopped
+	resumed := runSample(t, &Sample{Files: 3, State: loaded}, testDatabase(10))
+	for path := range resumed.paths {
+		if stateCovers(loaded, path) {
+			t.Errorf("%s was checked again after loading the state", path)
+		}
+	}
+}
+
+func TestDamageBound(t *testing.T) {
+	tests := []struct {
+		damaged, n int
+		want       float64
+	}{
+		{0, 0, 100},
+		{0, 100, 2.6343},
+		{5, 100, 9.9161},
+		{0, 1000, 0.2698},
+		{1, 10, 34.7719},
+		{10, 10, 100},
+	}
+	for _, tt := range tests {
+		if got := damageBound(tt.damaged, tt.n); math.Abs(got-tt.want) > 0.0001 {
+			t.Errorf("damageBound(%d, %d) = %.4f, want %.4f", tt.damaged, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,454 @@
+package checksum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Symlink policies for Options.symlinks.
+const (
+	SymlinksHash   = "hash"   // hash the target of file links, do not descend into directory links
+	SymlinksRecord = "record" // record the link target itself as the entry's content
+	SymlinksFollow = "follow" // follow file and directory links with loop detection
+	SymlinksSkip   = "skip"   // ignore symlinks
+)
+
+// specialFileTypes are the file types that are never opened, as reading a
+// FIFO or device can block forever.
+const specialFileTypes = fs.ModeNamedPipe | fs.ModeSocket | fs.ModeDevice | fs.ModeCharDevice | fs.ModeIrregular
+
+func validSymlinkPolicy(policy string) bool {
+	switch policy {
+	case "", SymlinksHash, SymlinksRecord, SymlinksFollow, SymlinksSkip:
+		return true
+	}
+	return false
+}
+
+// SkippedFile is a path the scanner deliberately did not hash.
+type SkippedFile struct {
+	Path   string `json:"Path"`
+	Reason string `json:"Reason"`
+}
+
+// collectFiles walks the root of opts and returns every file selected by
+// its include and exclude patterns, applying its symlink policy. Special
+// files and symlinks that are not hashed are returned as skipped. Files and
+// directories its Selection filters out are left out silently. The
+// database, sidecar manifests and the paths in IgnoreFiles are never
+// scanned.
+func collectFiles(ctx context.Context, opts Options) ([]string, []SkippedFile) {
+	baseLocationPath := opts.RootPath()
+	var filesToProcess []string
+	var skipped []SkippedFile
+	ignored := append([]string{opts.DatabasePath(), SignaturePath(opts.DatabasePath()), TreePath(opts.DatabasePath()), JournalPath(opts.DatabasePath()), ShardDir(opts.DatabasePath())}, opts.IgnoreFiles...)
+	excludes := opts.excludePatterns()
+	now := time.Now()
+	skip := func(path, reason string) {
+		relPath, _ := filepath.Rel(baseLocationPath, path)
+		skipped = append(skipped, SkippedFile{Path: relPath, Reason: reason})
+	}
+
+	// Real paths of the directories already walked, used in follow mode to
+	// avoid symlink loops and scanning the same directory twice
+	visitedDirs := make(map[string]bool)
+
+	var walk func(root string)
+	walk = func(root string) {
+		filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+			if ctx.Err() != nil {
+				return filepath.SkipAll
+			}
+			if err != nil {
+				return nil
+			}
+			relPath, _ := filepath.Rel(baseLocationPath, path)
+			if d.IsDir() {
+				if contains(ignored, filepath.Clean(path)) {
+					return filepath.SkipDir
+				}
+				if relPath != "." && matchesAny(excludes, relPath) {
+					return filepath.SkipDir
+				}
+				if relPath != "." && !opts.inScope(relPath, true) {
+					return filepath.SkipDir
+				}
+				if relPath != "." && opts.Selection.SkipHidden && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				if opts.Symlinks == SymlinksFollow {
+					realPath, err := filepath.EvalSymlinks(path)
+					if err != nil {
+						return filepath.SkipDir
+					}
+					if visitedDirs[realPath] {
+						skip(path, "symlink to a directory that was already scanned")
+						return filepath.SkipDir
+					}
+					visitedDirs[realPath] = true
+				}
+				return nil
+			}
+			if contains(ignored, filepath.Clean(path)) || d.Name() == SidecarFileName || matchesAny(excludes, relPath) {
+				return nil
+			}
+			if len(opts.Include) > 0 && !matchesAny(opts.Include, relPath) {
+				return nil
+			}
+			if !opts.inScope(relPath, false) {
+				return nil
+			}
+			if !opts.Selection.IsZero() {
+				info, err := os.Stat(path)
+				if err != nil {
+					info, err = d.Info()
+				}
+				if err == nil && !opts.Selection.selects(d.Name(), info, now) {
+					return nil
+				}
+			}
+
+			if d.Type()&fs.ModeSymlink != 0 {
+				switch opts.Symlinks {
+				case SymlinksSkip:
+					skip(path, "symlink")
+					return nil
+				case SymlinksRecord:
+					filesToProcess = append(filesToProcess, path)
+					return nil
+				}
+				target, err := os.Stat(path)
+				switch {
+				case err != nil:
+					skip(path, "broken symlink")
+				case target.IsDir() && opts.Symlinks == SymlinksFollow:
+					walk(path + string(os.PathSeparator))
+				case target.IsDir():
+					skip(path, "symlink to directory (not followed)")
+				case !target.Mode().IsRegular():
+					skip(path, "symlink to special file")
+				default:
+					filesToProcess = append(filesToProcess, path)
+				}
+				return nil
+			}
+
+			if d.Type()&specialFileTypes != 0 {
+				skip(path, "special file ("+describeFileType(d.Type())+")")
+				return nil
+			}
+			filesToProcess = append(filesToProcess, filepath.Clean(path))
+			return nil
+		})
+	}
+	walk(baseLocationPath)
+	return filesToProcess, skipped
+}
+
+func describeFileType(mode fs.FileMode) string {
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeCharDevice != 0:
+		return "character device"
+	case mode&fs.ModeDevice != 0:
+		return "device"
+	}
+	return "irregular file"
+}
+
+// matchesAny reports whether the relative path matches one of the glob
+// patterns. Patterns without a slash are matched against the base name,
+// others against the whole slash-separated path, where "**" matches any
+// number of directories.
+func matchesAny(patterns []string, relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(relPath)); ok {
+				return true
+			}
+			continue
+		}
+		if matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(relPath, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// symlinkHash is the content hash recorded for a symlink in record mode.
+func symlinkHash(target, algorithm string) string {
+	hash := NewHash(algorithm)
+	hash.Write([]byte("symlink:" + filepath.ToSlash(target)))
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// hashedEntry is the result of hashing one file or archive member.
+type hashedEntry struct {
+	hash     string
+	chunks   *ChunkInfo
+	metadata *FileMetadata
+}
+
+// entryHasher hashes the files of one scan. Hardlinked files are only read
+// once: later links reuse the entry of the first one.
+type entryHasher struct {
+	opts   Options
+	mu     sync.Mutex
+	inodes map[fileID]*inodeHash
+}
+
+// inodeHash is the entry of a hardlinked file, shared by its links. done is
+// closed once the first link was hashed, and ok is set if that succeeded.
+type inodeHash struct {
+	done  chan struct{}
+	entry hashedEntry
+	ok    bool
+}
+
+func newEntryHasher(opts Options) *entryHasher {
+	return &entryHasher{opts: opts, inodes: make(map[fileID]*inodeHash)}
+}
+
+// hashEntries hashes the file at filePath and returns its entries keyed by
+// relative path. In archive mode, archives are expanded into one entry per
+// member; archives that cannot be read as such fall back to being hashed as
+// a single file, and warning says why. expanded reports whether the file
+// was expanded. Chunk hashes and metadata are recorded for regular files
+// when requested.
+func (h *entryHasher) hashEntries(ctx context.Context, filePath, relPath string) (entries map[string]hashedEntry, size int64, expanded bool, warning string, err error) {
+	opts := h.opts
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return nil, 0, false, "", err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 && opts.Symlinks == SymlinksRecord {
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return nil, 0, false, "", err
+		}
+		entry := hashedEntry{hash: symlinkHash(target, opts.Algorithm)}
+		if opts.Metadata || opts.Xattrs {
+			if entry.metadata, err = readMetadata(filePath, false); err != nil {
+				return nil, 0, false, "", err
+			}
+		}
+		return map[string]hashedEntry{relPath: entry}, 0, false, "", nil
+	}
+
+	if opts.Archives && isArchive(filePath) {
+		members, n, archiveErr := hashArchive(ctx, filePath, relPath, opts.Algorithm)
+		if archiveErr == nil {
+			entries = make(map[string]hashedEntry, len(members))
+			for memberPath, hash := range members {
+				entries[memberPath] = hashedEntry{hash: hash}
+			}
+			return entries, n, true, "", nil
+		}
+		if ctx.Err() != nil {
+			return nil, n, false, "", ctx.Err()
+		}
+		warning = fmt.Sprintf("could not expand archive, hashed as a single file: %v", archiveErr)
+	}
+	var entry hashedEntry
+	if opts.Metadata || opts.Xattrs {
+		if entry.metadata, err = readMetadata(filePath, opts.Xattrs); err != nil {
+			return nil, 0, false, "", err
+		}
+	}
+
+	// Reuse the hash of a hardlink that was already read, waiting for it
+	// while another worker is still reading it
+	var id fileID
+	linked := false
+	if stat, statErr := os.Stat(filePath); statErr == nil {
+		id, linked = hardlinkID(stat)
+	}
+	var owned *inodeHash
+	if linked {
+		h.mu.Lock()
+		known, seen := h.inodes[id]
+		if !seen {
+			owned = &inodeHash{done: make(chan struct{})}
+			h.inodes[id] = owned
+		}
+		h.mu.Unlock()
+		if seen {
+			select {
+			case <-known.done:
+			case <-ctx.Done():
+				return nil, 0, false, "", ctx.Err()
+			}
+			// A link that could not be read is read again through this one
+			if known.ok {
+				reused := known.entry
+				reused.metadata = entry.metadata
+				return map[string]hashedEntry{relPath: reused}, 0, false, warning, nil
+			}
+		}
+	}
+	if owned != nil {
+		defer close(owned.done)
+	}
+
+	if opts.ChunkMode != "" {
+		entry.hash, entry.chunks, err = hashFileChunks(ctx, filePath, opts.ChunkMode, opts.ChunkSize, opts.Algorithm)
+		if err == nil {
+			size = entry.chunks.Length
+		}
+	} else {
+		entry.hash, size, err = HashFile(ctx, filePath, opts.Algorithm)
+	}
+	if err != nil {
+		return nil, size, false, "", err
+	}
+	if owned != nil {
+		owned.entry, owned.ok = entry, true
+	}
+	return map[string]hashedEntry{relPath: entry}, size, false, warning, nil
+}
+
+// hashedFile is the outcome of hashing one file of a scan.
+type hashedFile struct {
+	filePath string
+	relPath  string
+	entries  map[string]hashedEntry
+	size     int64
+	expanded bool
+	elapsed  time.Duration
+	err      error
+	// warning is set when an archive could not be expanded and was
+	// hashed as a single file instead.
+	warning string
+	// resumed is set when the entries were taken from a checkpoint.
+	resumed bool
+}
+
+// hashEntriesTimeout runs hashEntries with the per-file read timeout. A
+// read that hangs, e.g. on a dead network mount, cannot be interrupted: it
+// is abandoned and left to finish in the background.
+func (h *entryHasher) hashEntriesTimeout(ctx context.Context, filePath, relPath string) (map[string]hashedEntry, int64, bool, string, error) {
+	if h.opts.ReadTimeout <= 0 {
+		return h.hashEntries(ctx, filePath, relPath)
+	}
+	fileCtx, cancel := context.WithTimeout(ctx, h.opts.ReadTimeout)
+	defer cancel()
+
+	type result struct {
+		entries  map[string]hashedEntry
+		size     int64
+		expanded bool
+		warning  string
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		r.entries, r.size, r.expanded, r.warning, r.err = h.hashEntries(fileCtx, filePath, relPath)
+		done <- r
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil && ctx.Err() == nil && errors.Is(r.err, context.DeadlineExceeded) {
+			r.err = fmt.Errorf("read timed out after %s", h.opts.ReadTimeout)
+		}
+		return r.entries, r.size, r.expanded, r.warning, r.err
+	case <-fileCtx.Done():
+		if ctx.Err() != nil {
+			return nil, 0, false, "", ctx.Err()
+		}
+		return nil, 0, false, "", fmt.Errorf("read timed out after %s", h.opts.ReadTimeout)
+	}
+}
+
+// hashFiles hashes the files with opts.Concurrency workers and calls fn for
+// every file from the calling goroutine, in completion order. Workers keep
+// to the rate and load limits of the options. Once ctx is done no further
+// files are started, and files interrupted by it are not passed to fn.
+func (h *entryHasher) hashFiles(ctx context.Context, filesToProcess []string, fn func(hashedFile)) {
+	baseLocationPath := h.opts.RootPath()
+	limits := newThrottle(h.opts)
+	ctx = withThrottle(ctx, limits)
+	workers := max(h.opts.Concurrency, 1)
+	paths := make(chan string)
+	done := make(chan hashedFile)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filePath := range paths {
+				if limits != nil && limits.waitFile(ctx) != nil {
+					continue
+				}
+				f := hashedFile{filePath: filePath}
+				f.relPath, _ = filepath.Rel(baseLocationPath, filePath)
+				start := time.Now()
+				f.entries, f.size, f.expanded, f.warning, f.err = h.hashEntriesTimeout(ctx, filePath, f.relPath)
+				f.elapsed = time.Since(start)
+				if f.err != nil && ctx.Err() != nil {
+					continue
+				}
+				done <- f
+			}
+		}()
+	}
+	go func() {
+		defer func() {
+			close(paths)
+			wg.Wait()
+			close(done)
+		}()
+		for _, filePath := range filesToProcess {
+			select {
+			case paths <- filePath:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for f := range done {
+		fn(f)
+	}
+}
+
+func contains(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package checksum
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"md5checker/checksum/checksumtest"
+)
+
+// collectTestFiles validates opts and returns the files collectFiles
+// selects and the skipped ones as "path: reason", slash-separated and
+// sorted.
+func collectTestFiles(t *testing.T, opts Options) (files, skipped []string) {
+	t.Helper()
+	if err := opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	filesToProcess, skippedFiles := collectFiles(t.Context(), opts)
+	for _, filePath := range filesToProcess {
+		relPath, err := filepath.Rel(opts.RootPath(), filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, filepath.ToSlash(relPath))
+	}
+	for _, s := range skippedFiles {
+		skipped = append(skipped, filepath.ToSlash(s.Path)+": "+s.Reason)
+	}
+	sort.Strings(files)
+	sort.Strings(skipped)
+	return files, skipped
+}
+
+func TestCollectFiles(t *testing.T) {
+	root := checksumtest.WriteTree(t, map[string]string{
+		"a.txt":                      "a",
+		"docs/b.md":                  "b",
+		"docs/deep/c.txt":            "c",
+		"build/out.bin":              "out",
+		"0/zero.txt":                 "excluded by default",
+		"checksums.json.gz":          "the database",
+		"checksums.history.jsonl":    "history",
+		"md5checker.log":             "log",
+		"docs/.checksums.json.gz":    "a sidecar",
+		"ignored/listed-in-opts.txt": "ignored",
+	})
+
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{
+			name: "default excludes",
+			opts: Options{},
+			want: []string{"a.txt", "build/out.bin", "docs/b.md", "docs/deep/c.txt", "ignored/listed-in-opts.txt"},
+		},
+		{
+			name: "exclude replaces the defaults, the database stays out",
+			opts: Options{Exclude: []string{"build"}},
+			want: []string{"0/zero.txt", "a.txt", "checksums.history.jsonl", "docs/b.md", "docs/deep/c.txt", "ignored/listed-in-opts.txt", "md5checker.log"},
+		},
+		{
+			name: "include by name and by path",
+			opts: Options{Include: []string{"*.md", "docs/deep/**"}},
+			want: []string{"docs/b.md", "docs/deep/c.txt"},
+		},
+		{
+			name: "ignored files",
+			opts: Options{IgnoreFiles: []string{filepath.Join(root, "ignored", "listed-in-opts.txt")}},
+			want: []string{"a.txt", "build/out.bin", "docs/b.md", "docs/deep/c.txt"},
+		},
+		{
+			name: "scope",
+			opts: Options{Paths: []string{"docs"}},
+			want: []string{"docs/b.md", "docs/deep/c.txt"},
+		},
+	}
+	for _, tt := range tests {
+		tt.opts.Root = root
+		files, skipped := collectTestFiles(t, tt.opts)
+		if !reflect.DeepEqual(files, tt.want) {
+			t.Errorf("%s: collected %q, want %q", tt.name, files, tt.want)
+		}
+		if len(skipped) > 0 {
+			t.Errorf("%s: skipped %q, want none", tt.name, skipped)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+//go:build unix
+
+package checksum
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"md5checker/checksum/checksumtest"
+)
+
+func TestCollectFilesSymlinks(t *testing.T) {
+	root := checksumtest.WriteTree(t, map[string]string{
+		"file.txt":     "file",
+		"dir/in.txt":   "in",
+		"other/ok.txt": "ok",
+	})
+	links := map[string]string{
+		"file-link":    "file.txt",
+		"dir-link":     "dir",
+		"broken-link":  "missing.txt",
+		"dir/loop":     "..",
+		"other/parent": "../other",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := syscall.Mkfifo(filepath.Join(root, "fifo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		policy  string
+		files   []string
+		skipped []string
+	}{
+		{
+			policy: SymlinksHash,
+			files:  []string{"dir/in.txt", "file-link", "file.txt", "other/ok.txt"},
+			skipped: []string{
+				"broken-link: broken symlink",
+				"dir-link: symlink to directory (not followed)",
+				"dir/loop: symlink to directory (not followed)",
+				"fifo: special file (named pipe)",
+				"other/parent: symlink to directory (not followed)",
+			},
+		},
+		{
+			policy:  SymlinksRecord,
+			files:   []string{"broken-link", "dir-link", "dir/in.txt", "dir/loop", "file-link", "file.txt", "other/ok.txt", "other/parent"},
+			skipped: []string{"fifo: special file (named pipe)"},
+		},
+		{
+			policy: SymlinksSkip,
+			files:  []string{"dir/in.txt", "file.txt", "other/ok.txt"},
+			skipped: []string{
+				"broken-link: symlink",
+				"dir-link: symlink",
+				"dir/loop: symlink",
+				"fifo: special file (named pipe)",
+				"file-link: symlink",
+				"other/parent: symlink",
+			},
+		},
+	}
+	for _, tt := range tests {
+		files, skipped := collectTestFiles(t, Options{Root: root, Symlinks: tt.policy})
+		if !reflect.DeepEqual(files, tt.files) {
+			t.Errorf("%s: collected %q, want %q", tt.policy, files, tt.files)
+		}
+		if !reflect.DeepEqual(skipped, tt.skipped) {
+			t.Errorf("%s: skipped %q, want %q", tt.policy, skipped, tt.skipped)
+		}
+	}
+
+	// Following links scans every directory once, whichever way it is
+	// reached, and stops at loops
+	files, skipped := collectTestFiles(t, Options{Root: root, Symlinks: SymlinksFollow})
+	seen := make(map[string]bool)
+	for _, f := range files {
+		seen[filepath.Base(f)] = true
+	}
+	for _, name := range []string{"file.txt", "file-link", "in.txt", "ok.txt"} {
+		if !seen[name] {
+			t.Errorf("follow: %s was not collected (got %q)", name, files)
+		}
+	}
+	if len(files) != 4 {
+		t.Errorf("follow: collected %q, want each file once", files)
+	}
+	for _, s := range skipped {
+		if !strings.Contains(s, "already scanned") && !strings.Contains(s, "broken symlink") && !strings.Contains(s, "special file") {
+			t.Errorf("follow: unexpected skip %q", s)
+		}
+	}
+}
+
+func TestHardlinksHashedOnce(t *testing.T) {
+	content := strings.Repeat("hardlinked content\n", 4000)
+	root := checksumtest.WriteTree(t, map[string]string{"original.txt": content})
+	var paths []string
+	for i := range 16 {
+		link := filepath.Join(root, "link-"+string(rune('a'+i)))
+		if err := os.Link(filepath.Join(root, "original.txt"), link); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, link)
+	}
+	paths = append(paths, filepath.Join(root, "original.txt"))
+
+	// The first read is slowed down, so the other links start meanwhile
+	opts := Options{Root: root, Concurrency: 8, MaxBytesPerSec: 512 * 1024}
+	if err := opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var read int64
+	hashes := make(map[string]bool)
+	newEntryHasher(opts).hashFiles(t.Context(), paths, func(f hashedFile) {
+		if f.err != nil {
+			t.Fatal(f.err)
+		}
+		read += f.size
+		for _, entry := range f.entries {
+			hashes[entry.hash] = true
+		}
+	})
+	// Links hashed while the first one was still being read wait for it
+	if read != int64(len(content)) {
+		t.Errorf("read %d bytes for %d links, want the %d bytes of one file", read, len(paths), len(content))
+	}
+	if len(hashes) != 1 {
+		t.Errorf("links got %d different hashes, want 1", len(hashes))
+	}
+}
@@ -0,0 +1,354 @@
+package checksum
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// Progress describes one file that was hashed during a scan.
+type Progress struct {
+	Path    string
+	Done    int
+	Total   int
+	Size    int64
+	Elapsed time.Duration
+	Err     error
+}
+
+// ProgressFunc is called after every file that was hashed, one call at a
+// time.
+type ProgressFunc func(Progress)
+
+// FileError is a file that could not be hashed.
+type FileError struct {
+	Path  string `json:"Path"`
+	Error string `json:"Error"`
+}
+
+// ScanSummary counts what a Scanner update changed in the database.
+type ScanSummary struct {
+	FilesScanned int
+	Processed    int
+	Added        int
+	Updated      int
+	Pruned       int
+	Errors       []FileError
+	Skipped      []SkippedFile
+	// Warnings lists the archives that could not be expanded and were
+	// recorded as single files.
+	Warnings []FileError
+	// Resumed counts the files taken from the checkpoint unhashed.
+	Resumed int
+	// Interrupted is set when the context was cancelled before every file
+	// was hashed; Pending files were not reached.
+	Interrupted bool
+	Pending     int
+}
+
+// Scanner hashes the files under the root of its options and records them
+// in a database. With a Checkpoint, every hashed file is logged to it and
+// files it already holds with an unchanged size and modification time are
+// not hashed again.
+type Scanner struct {
+	Options    Options
+	Progress   ProgressFunc
+	Checkpoint *Checkpoint
+}
+
+// NewScanner returns a Scanner for opts, which are validated first.
+func NewScanner(opts Options) (*Scanner, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return &Scanner{Options: opts}, nil
+}
+
+// HashFiles hashes every selected file and returns a map of relative path to
+// content hash, together with the skipped and unreadable files. It stops
+// early when ctx is done.
+func (s *Scanner) HashFiles(ctx context.Context) (map[string]string, []SkippedFile, []FileError) {
+	files := make(map[string]string)
+	var errs []FileError
+	skipped, _ := s.hashFiles(ctx, func(f hashedFile) {
+		if f.err != nil {
+			errs = append(errs, FileError{Path: f.relPath, Error: f.err.Error()})
+			return
+		}
+		for entryPath, entry := range f.entries {
+			files[entryPath] = entry.hash
+		}
+	})
+	return files, skipped, errs
+}
+
+// hashFiles collects and hashes the selected files, reporting progress and
+// calling fn for each of them. It returns the skipped files and the
+// relative paths of the files not reached before ctx was done.
+func (s *Scanner) hashFiles(ctx context.Context, fn func(hashedFile)) ([]SkippedFile, []string) {
+	filesToProcess, skipped := collectFiles(ctx, s.Options)
+	return skipped, s.hashList(ctx, filesToProcess, fn)
+}
+
+// hashList hashes the given files like hashFiles and returns the relative
+// paths of the files not reached before ctx was done.
+func (s *Scanner) hashList(ctx context.Context, filesToProcess []string, fn func(hashedFile)) []string {
+	baseLocationPath := s.Options.RootPath()
+	reached := make(map[string]bool, len(filesToProcess))
+	done := func(f hashedFile) {
+		reached[f.filePath] = true
+		fn(f)
+		if s.Progress != nil {
+			s.Progress(Progress{Path: f.relPath, Done: len(reached), Total: len(filesToProcess), Size: f.size, Elapsed: f.elapsed, Err: f.err})
+		}
+	}
+
+	// Take unchanged files from the checkpoint and remember the state of
+	// the others before they are hashed
+	filesToHash := filesToProcess
+	var infos map[string]os.FileInfo
+	if s.Checkpoint != nil {
+		filesToHash = nil
+		infos = make(map[string]os.FileInfo)
+		for _, filePath := range filesToProcess {
+			if ctx.Err() != nil {
+				break
+			}
+			info, err := statPolicy(filePath, s.Options.Symlinks)
+			if err != nil {
+				filesToHash = append(filesToHash, filePath)
+				continue
+			}
+			relPath, _ := filepath.Rel(baseLocationPath, filePath)
+			if f, ok := s.Checkpoint.resume(filePath, relPath, info); ok {
+				done(f)
+				continue
+			}
+			infos[filePath] = info
+			filesToHash = append(filesToHash, filePath)
+		}
+	}
+
+	newEntryHasher(s.Options).hashFiles(ctx, filesToHash, func(f hashedFile) {
+		if info, ok := infos[f.filePath]; ok && f.err == nil {
+			s.Checkpoint.record(f, info)
+		}
+		done(f)
+	})
+
+	var pending []string
+	if len(reached) < len(filesToProcess) {
+		for _, filePath := range filesToProcess {
+			if !reached[filePath] {
+				relPath, _ := filepath.Rel(baseLocationPath, filePath)
+				pending = append(pending, relPath)
+			}
+		}
+	}
+	return pending
+}
+
+// Update hashes every selected file into db and prunes the paths that no
+// longer exist. In add mode (regenerateAll false) paths already in the
+// database keep their checksum. Adding to a database of another algorithm
+// is refused, as every file would appear changed.
+//
+// When ctx is cancelled, Update stops hashing and returns ctx.Err() with
+// the summary marked as interrupted. db then holds the files hashed so far
+// and has not been pruned; callers may save it as a checkpoint or discard
+// it.
+func (s *Scanner) Update(ctx context.Context, db Database, regenerateAll bool) (*ScanSummary, error) {
+	algorithm := AlgorithmName(s.Options.Algorithm)
+	if existing := DetectAlgorithm(db); existing != "" && existing != algorithm && !regenerateAll {
+		return nil, fmt.Errorf("the database uses %s but %s was requested, use %s or regenerate the database", existing, algorithm, existing)
+	}
+	hashPattern := regexp.MustCompile(fmt.Sprintf("^[a-f0-9]{%d}$", NewHash(algorithm).Size()*2))
+	summary := &ScanSummary{}
+
+	// The hash of every path, kept up to date as paths are recorded
+	index := db.PathIndex()
+
+	// Archives expanded into members and the member paths seen this run
+	expandedArchives := make(map[string]bool)
+	seenMembers := make(map[string]bool)
+
+	var pending []string
+	summary.Skipped, pending = s.hashFiles(ctx, func(f hashedFile) {
+		summary.FilesScanned++
+		if f.resumed {
+			summary.Resumed++
+		}
+		// Hashes hold one entry per member for expanded archives
+		if f.err != nil {
+			summary.Errors = append(summary.Errors, FileError{Path: f.relPath, Error: f.err.Error()})
+			return
+		}
+		if f.expanded {
+			expandedArchives[f.relPath] = true
+		}
+		if f.warning != "" {
+			summary.Warnings = append(summary.Warnings, FileError{Path: f.relPath, Error: f.warning})
+		}
+
+		currentTime := time.Now().UTC().Format(time.RFC3339)
+		for entryPath, entry := range f.entries {
+			if !hashPattern.MatchString(entry.hash) {
+				summary.Errors = append(summary.Errors, FileError{Path: entryPath, Error: fmt.Sprintf("generated hash '%s' is not a valid %s hash", entry.hash, algorithm)})
+				continue
+			}
+			if f.expanded {
+				seenMembers[entryPath] = true
+			}
+
+			added, updated, processed := updateDatabase(db, index, entryPath, entry, regenerateAll, currentTime)
+			if added {
+				summary.Added++
+			}
+			if updated {
+				summary.Updated++
+			}
+			if processed {
+				summary.Processed++
+			}
+		}
+	})
+
+	if err := ctx.Err(); err != nil {
+		summary.Interrupted = true
+		summary.Pending = len(pending)
+		return summary, err
+	}
+
+	// Prune missing paths across all entries in scope
+	baseLocationPath := s.Options.RootPath()
+	for hash, infoData := range db {
+		var newPaths []PathEntry
+		for _, p := range infoData.RelativePaths {
+			if !s.Options.inScope(p.Path, false) || pathStillExists(baseLocationPath, p.Path, s.Options.Symlinks, expandedArchives, seenMembers) {
+				newPaths = append(newPaths, p)
+			} else {
+				summary.Pruned++
+			}
+		}
+		if len(newPaths) == 0 {
+			delete(db, hash)
+		} else {
+			infoData.RelativePaths = newPaths
+			db[hash] = infoData
+		}
+	}
+	return summary, nil
+}
+
+// statPolicy returns the file information of a scanned path under a
+// symlink policy: that of the link itself for recorded symlinks, and of the
+// file read otherwise. It is compared against the checkpoint and decides
+// whether a path survives pruning.
+func statPolicy(filePath, symlinks string) (os.FileInfo, error) {
+	if symlinks == SymlinksRecord {
+		return os.Lstat(filePath)
+	}
+	return os.Stat(filePath)
+}
+
+// updateDatabase records one hashed path in the database and in index, the
+// hash of every path in it. In add mode (regenerateAll false) paths already
+// in the database keep their checksum and only have LastSeen refreshed when
+// unchanged. It reports whether a new path was added, an existing path was
+// updated, and whether the path was processed at all.
+func updateDatabase(checksumDB Database, index map[string]string, fileRelativePath string, entry hashedEntry, regenerateAll bool, currentTime string) (added, updated, processed bool) {
+	fileContentHash := entry.hash
+
+	// Check if this file path already exists in ANY hash entry
+	existingHash := index[fileRelativePath]
+
+	// If regenerateAll is false and file already exists in DB, skip it
+	if !regenerateAll && existingHash != "" {
+		if existingHash == fileContentHash {
+			// File hasn't changed, just update LastSeen and the metadata,
+			// so a touch is not reported again on every verify
+			if pathEntry := findPathEntry(checksumDB[existingHash].RelativePaths, fileRelativePath); pathEntry != nil {
+				pathEntry.LastSeen = currentTime
+				if entry.metadata != nil {
+					pathEntry.Metadata = entry.metadata
+				}
+				updated = true
+			}
+		}
+		// Skip processing - don't update if content changed
+		return false, updated, false
+	}
+
+	// If regenerateAll is true and file exists with different hash, remove old entry
+	if regenerateAll && existingHash != "" && existingHash != fileContentHash {
+		// Remove from old hash entry
+		oldInfo := checksumDB[existingHash]
+		var newPaths []PathEntry
+		for _, p := range oldInfo.RelativePaths {
+			if p.Path != fileRelativePath {
+				newPaths = append(newPaths, p)
+			}
+		}
+		if len(newPaths) == 0 {
+			delete(checksumDB, existingHash)
+		} else {
+			oldInfo.RelativePaths = newPaths
+			checksumDB[existingHash] = oldInfo
+		}
+	}
+
+	infoData, exists := checksumDB[fileContentHash]
+	if !exists {
+		infoData = InfoData{
+			ContentMD5:        fileContentHash,
+			RelativePaths:     []PathEntry{},
+			FirstCreated:      currentTime,
+			LastContentUpdate: currentTime,
+		}
+	}
+
+	pathEntry := findPathEntry(infoData.RelativePaths, fileRelativePath)
+	if pathEntry != nil {
+		pathEntry.LastSeen = currentTime
+		if entry.metadata != nil {
+			pathEntry.Metadata = entry.metadata
+		}
+		updated = true
+	} else {
+		newEntry := PathEntry{
+			Path:      fileRelativePath,
+			FirstSeen: currentTime,
+			LastSeen:  currentTime,
+			Metadata:  entry.metadata,
+		}
+		infoData.RelativePaths = append(infoData.RelativePaths, newEntry)
+		added = true
+	}
+
+	if entry.chunks != nil {
+		infoData.Chunks = entry.chunks
+	}
+	infoData.LastContentUpdate = currentTime
+	checksumDB[fileContentHash] = infoData
+	index[fileRelativePath] = fileContentHash
+	return added, updated, true
+}
+
+// pathStillExists decides whether a database path survives pruning. Archive
+// member paths are kept while their archive exists, unless the archive was
+// expanded in this run and the member was not found in it. Only recorded
+// symlinks exist by themselves; under the other policies a link whose
+// target is gone is pruned like the file it stood for.
+func pathStillExists(baseLocationPath, p, symlinks string, expandedArchives, seenMembers map[string]bool) bool {
+	if archivePath, _, ok := splitArchivePath(p); ok {
+		if expandedArchives[archivePath] {
+			return seenMembers[p]
+		}
+		p = archivePath
+	}
+	_, err := statPolicy(filepath.Join(baseLocationPath, p), symlinks)
+	return err == nil
+}
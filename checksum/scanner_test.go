@@ -0,0 +1,49 @@
+package checksum
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"md5checker/checksum/checksumtest"
+)
+
+func TestUpdateAddAndRegenerate(t *testing.T) {
+	root := checksumtest.WriteTree(t, map[string]string{"a.txt": "alpha", "b.txt": "bravo", "copy.txt": "alpha"})
+	opts := Options{Root: root}
+	checksumDB := make(Database)
+	if summary := scanTestTree(t, opts, checksumDB); summary.Added != 3 {
+		t.Fatalf("first scan added %d paths, want 3", summary.Added)
+	}
+	before := checksumDB.PathIndex()
+
+	// Add keeps the checksum of a changed path; regenerate moves the path
+	// to its new content and drops content no path holds any more
+	if err := os.WriteFile(filepath.Join(root, "b.txt"), []byte("bravo, changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if summary := scanTestTree(t, opts, checksumDB); summary.Added != 0 || summary.Updated != 2 {
+		t.Errorf("add added %d and updated %d paths, want 0 and 2", summary.Added, summary.Updated)
+	}
+	if !reflect.DeepEqual(checksumDB.PathIndex(), before) {
+		t.Errorf("add changed the checksums: %v, want %v", checksumDB.PathIndex(), before)
+	}
+
+	if err := opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&Scanner{Options: opts}).Update(t.Context(), checksumDB, true); err != nil {
+		t.Fatal(err)
+	}
+	after := checksumDB.PathIndex()
+	if after["b.txt"] == before["b.txt"] || after["a.txt"] != before["a.txt"] || after["copy.txt"] != after["a.txt"] {
+		t.Errorf("regenerate left %v, want only b.txt changed", after)
+	}
+	if _, ok := checksumDB[before["b.txt"]]; ok {
+		t.Error("the old content of b.txt is still in the database")
+	}
+	if len(checksumDB) != 2 {
+		t.Errorf("database holds %d contents, want 2", len(checksumDB))
+	}
+}
@@ -0,0 +1,95 @@
+package checksum
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// normalizeScope cleans the scope paths of a scan into slash-separated
+// paths relative to the root. A scope holding the root itself is dropped,
+// as it covers every file.
+func normalizeScope(paths []string) ([]string, error) {
+	var scope []string
+	for _, p := range paths {
+		cleaned := path.Clean(filepath.ToSlash(p))
+		if filepath.IsAbs(p) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+			return nil, fmt.Errorf("path '%s' is not inside the root", p)
+		}
+		if _, err := path.Match(cleaned, ""); err != nil {
+			return nil, fmt.Errorf("invalid path pattern '%s': %w", p, err)
+		}
+		if cleaned == "." {
+			return nil, nil
+		}
+		scope = append(scope, cleaned)
+	}
+	return scope, nil
+}
+
+// inScope reports whether a path relative to the root is selected by the
+// scope paths and the path filter of opts. A file is in scope when it or
+// one of its directories matches a scope path; a directory also when files
+// below it may match one. Archive members follow their archive.
+func (o Options) inScope(relPath string, isDir bool) bool {
+	if archivePath, _, ok := splitArchivePath(relPath); ok {
+		relPath = archivePath
+	}
+	if o.pathFilter != nil && !o.pathFilter(relPath, isDir) {
+		return false
+	}
+	if len(o.Paths) == 0 {
+		return true
+	}
+	segments := strings.Split(filepath.ToSlash(relPath), "/")
+	for _, p := range o.Paths {
+		pattern := strings.Split(p, "/")
+		for i := 1; i <= len(segments); i++ {
+			if matchSegments(pattern, segments[:i]) {
+				return true
+			}
+		}
+		if isDir && matchPrefix(pattern, segments) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPrefix reports whether paths below the directory segments can match
+// pattern.
+func matchPrefix(pattern, segments []string) bool {
+	for len(segments) > 0 {
+		if len(pattern) == 0 {
+			return false
+		}
+		if pattern[0] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return true
+}
+
+// scopeDatabase returns the paths of the database that are in scope, so
+// that files outside it are neither compared nor reported as deleted.
+func scopeDatabase(checksumDB Database, opts Options) Database {
+	scoped := make(Database)
+	for hash, infoData := range checksumDB {
+		var kept []PathEntry
+		for _, p := range infoData.RelativePaths {
+			if opts.inScope(p.Path, false) {
+				kept = append(kept, p)
+			}
+		}
+		if len(kept) > 0 {
+			infoData.RelativePaths = kept
+			scoped[hash] = infoData
+		}
+	}
+	return scoped
+}
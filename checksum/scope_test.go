@@ -0,0 +1,113 @@
+package checksum
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"md5checker/checksum/checksumtest"
+)
+
+func TestNormalizeScope(t *testing.T) {
+	tests := []struct {
+		paths   []string
+		want    []string
+		wantErr bool
+	}{
+		{paths: []string{"docs", "./src/", "a//b/../c"}, want: []string{"docs", "src", "a/c"}},
+		{paths: []string{"docs", "."}, want: nil},
+		{paths: []string{"**/*.go"}, want: []string{"**/*.go"}},
+		{paths: []string{"../outside"}, wantErr: true},
+		{paths: []string{"docs/../.."}, wantErr: true},
+		{paths: []string{"/abs"}, wantErr: true},
+		{paths: []string{"bad["}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeScope(tt.paths)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("normalizeScope(%q) error = %v, want error %v", tt.paths, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("normalizeScope(%q) = %q, want %q", tt.paths, got, tt.want)
+		}
+	}
+}
+
+func TestInScope(t *testing.T) {
+	opts := Options{Paths: []string{"docs", "src/*.go", "**/keep.txt"}}
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"docs", true, true},
+		{"docs/a.txt", false, true},
+		{"docs/deep/b.txt", false, true},
+		{"docs.txt", false, false},
+		{"src", true, true},
+		{"src/main.go", false, true},
+		{"src/main.txt", false, false},
+		// Every directory may hold a keep.txt
+		{"src/sub", true, true},
+		{"other", true, true},
+		{"other/keep.txt", false, true},
+		{"other/drop.txt", false, false},
+		{"docs/archive.zip!/inner/file.txt", false, true},
+		{"other/archive.zip!/keep.txt", false, false},
+	}
+	for _, tt := range tests {
+		if got := opts.inScope(filepath.FromSlash(tt.path), tt.isDir); got != tt.want {
+			t.Errorf("inScope(%s, dir %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+	if (Options{Paths: []string{"src/*.go"}}).inScope(filepath.FromSlash("src/sub"), true) {
+		t.Error("a directory no scope path can match below is in scope")
+	}
+	if !(Options{}).inScope("anything", false) {
+		t.Error("a scan without scope paths left a file out")
+	}
+}
+
+func TestScopedScanAndVerify(t *testing.T) {
+	root := checksumtest.WriteTree(t, map[string]string{
+		"docs/a.txt":  "a",
+		"docs/b.txt":  "b",
+		"src/main.go": "main",
+		"other/x.txt": "x",
+	})
+	checksumDB := make(Database)
+	scanTestTree(t, Options{Root: root}, checksumDB)
+
+	// Change files inside and outside the scope
+	os.Remove(filepath.Join(root, "other", "x.txt"))
+	os.Remove(filepath.Join(root, "docs", "b.txt"))
+	os.WriteFile(filepath.Join(root, "src", "main.go"), []byte("changed"), 0644)
+	os.WriteFile(filepath.Join(root, "docs", "new.txt"), []byte("new"), 0644)
+	os.WriteFile(filepath.Join(root, "other", "y.txt"), []byte("y"), 0644)
+
+	scoped := Options{Root: root, Paths: []string{"docs"}}
+	if err := checksumDB.Save(filepath.Join(root, DatabaseFileName), nil); err != nil {
+		t.Fatal(err)
+	}
+	report := verifyTestTree(t, scoped)
+	reported := reportedPaths(report)
+	want := []string{"OK docs/a.txt", "NEW docs/new.txt", "DELETED docs/b.txt"}
+	if !reflect.DeepEqual(reported, want) {
+		t.Errorf("scoped verify reported %v, want %v", reported, want)
+	}
+	if !reflect.DeepEqual(report.Scope, []string{"docs"}) {
+		t.Errorf("report scope = %v, want [docs]", report.Scope)
+	}
+
+	// A scoped add prunes and adds inside the scope only
+	summary := scanTestTree(t, scoped, checksumDB)
+	if summary.Pruned != 1 || summary.Added != 1 {
+		t.Errorf("scoped add pruned %d and added %d paths, want 1 and 1", summary.Pruned, summary.Added)
+	}
+	wantPaths := []string{"docs/a.txt", "docs/new.txt", "other/x.txt", "src/main.go"}
+	if got := sortedPaths(checksumDB); !reflect.DeepEqual(got, wantPaths) {
+		t.Errorf("database after a scoped add = %v, want %v", got, wantPaths)
+	}
+}
@@ -0,0 +1,302 @@
+package checksum
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Selection filters the scanned files by size, age, extension and hidden
+// names, on top of the include and exclude patterns. It is recorded in the
+// database header, so later runs select the same files. The zero Selection
+// selects every file.
+type Selection struct {
+	// MinSize and MaxSize bound the file size in bytes, 0 meaning no
+	// bound.
+	MinSize int64 `json:"MinSize,omitempty"`
+	MaxSize int64 `json:"MaxSize,omitempty"`
+	// NewerThan selects files modified less than this long before the
+	// scan, OlderThan those modified more than this long before it.
+	NewerThan time.Duration `json:"NewerThan,omitempty"`
+	OlderThan time.Duration `json:"OlderThan,omitempty"`
+	// Extensions, if set, selects only files with these extensions;
+	// SkipExtensions skips files with them. Extensions are matched
+	// case-insensitively, with or without the leading dot.
+	Extensions     []string `json:"Extensions,omitempty"`
+	SkipExtensions []string `json:"SkipExtensions,omitempty"`
+	// SkipHidden skips files and directories whose name starts with a
+	// dot, such as .git.
+	SkipHidden bool `json:"SkipHidden,omitempty"`
+}
+
+// selectionExtraID is the gzip extra subfield holding the selection of a
+// JSON database.
+const selectionExtraID = "MS"
+
+// IsZero reports whether the selection selects every file.
+func (s Selection) IsZero() bool {
+	return s.MinSize == 0 && s.MaxSize == 0 && s.NewerThan == 0 && s.OlderThan == 0 &&
+		len(s.Extensions) == 0 && len(s.SkipExtensions) == 0 && !s.SkipHidden
+}
+
+// validate checks the selection and normalizes its extensions.
+func (s *Selection) validate() error {
+	if s.MinSize < 0 || s.MaxSize < 0 || s.NewerThan < 0 || s.OlderThan < 0 {
+		return fmt.Errorf("file sizes and ages must not be negative")
+	}
+	if s.MaxSize > 0 && s.MinSize > s.MaxSize {
+		return fmt.Errorf("the minimum file size is larger than the maximum")
+	}
+	if s.NewerThan > 0 && s.OlderThan >= s.NewerThan {
+		return fmt.Errorf("no file can be both newer than %s and older than %s", formatAge(s.NewerThan), formatAge(s.OlderThan))
+	}
+	s.Extensions = normalizeExtensions(s.Extensions)
+	s.SkipExtensions = normalizeExtensions(s.SkipExtensions)
+	return nil
+}
+
+// normalizeExtensions lowercases extensions and gives them a leading dot,
+// so "PDF", "*.pdf" and ".pdf" are the same.
+func normalizeExtensions(extensions []string) []string {
+	var normalized []string
+	for _, ext := range extensions {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "*"))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if !slices.Contains(normalized, ext) {
+			normalized = append(normalized, ext)
+		}
+	}
+	return normalized
+}
+
+// selects reports whether a file with the given name and information is
+// selected by a scan started at now.
+func (s Selection) selects(name string, info fs.FileInfo, now time.Time) bool {
+	if s.SkipHidden && strings.HasPrefix(name, ".") {
+		return false
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if len(s.Extensions) > 0 && !slices.Contains(s.Extensions, ext) {
+		return false
+	}
+	if slices.Contains(s.SkipExtensions, ext) {
+		return false
+	}
+	if s.MinSize > 0 && info.Size() < s.MinSize {
+		return false
+	}
+	if s.MaxSize > 0 && info.Size() > s.MaxSize {
+		return false
+	}
+	age := now.Sub(info.ModTime())
+	if s.NewerThan > 0 && age > s.NewerThan {
+		return false
+	}
+	if s.OlderThan > 0 && age < s.OlderThan {
+		return false
+	}
+	return true
+}
+
+// deselects reports whether the database path still exists but is not
+// selected, e.g. because the file grew past MaxSize or was last modified
+// longer ago than NewerThan. Such a path was not scanned rather than
+// deleted.
+func (s Selection) deselects(baseLocationPath, relPath string, now time.Time) bool {
+	if s.IsZero() {
+		return false
+	}
+	if archivePath, _, ok := splitArchivePath(relPath); ok {
+		relPath = archivePath
+	}
+	fullPath := filepath.Join(baseLocationPath, relPath)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		if info, err = os.Lstat(fullPath); err != nil {
+			return false
+		}
+	}
+	if s.SkipHidden {
+		for _, dir := range strings.Split(filepath.ToSlash(filepath.Dir(relPath)), "/") {
+			if strings.HasPrefix(dir, ".") && dir != "." {
+				return true
+			}
+		}
+	}
+	return !s.selects(filepath.Base(relPath), info, now)
+}
+
+// String describes the selection for reports.
+func (s Selection) String() string {
+	var parts []string
+	if s.MinSize > 0 {
+		parts = append(parts, "at least "+FormatBytes(s.MinSize))
+	}
+	if s.MaxSize > 0 {
+		parts = append(parts, "at most "+FormatBytes(s.MaxSize))
+	}
+	if s.NewerThan > 0 {
+		parts = append(parts, "modified within "+formatAge(s.NewerThan))
+	}
+	if s.OlderThan > 0 {
+		parts = append(parts, "unmodified for "+formatAge(s.OlderThan))
+	}
+	if len(s.Extensions) > 0 {
+		parts = append(parts, "only "+strings.Join(s.Extensions, " "))
+	}
+	if len(s.SkipExtensions) > 0 {
+		parts = append(parts, "not "+strings.Join(s.SkipExtensions, " "))
+	}
+	if s.SkipHidden {
+		parts = append(parts, "no hidden files")
+	}
+	if len(parts) == 0 {
+		return "all files"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatAge prints whole days as such, e.g. 30d.
+func formatAge(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+// equal reports whether two selections select the same files.
+func (s Selection) equal(o Selection) bool {
+	a, _ := s.encode()
+	b, _ := o.encode()
+	return bytes.Equal(a, b)
+}
+
+// encode returns the selection as recorded in a database header, nil for
+// the zero Selection.
+func (s Selection) encode() ([]byte, error) {
+	if s.IsZero() {
+		return nil, nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode file selection: %w", err)
+	}
+	return data, nil
+}
+
+func decodeSelection(data []byte) (Selection, error) {
+	var s Selection
+	if len(data) == 0 {
+		return s, nil
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("could not decode file selection: %w", err)
+	}
+	return s, nil
+}
+
+// gzipExtra returns the gzip extra field holding an encoded selection.
+func gzipExtra(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if len(data) > 0xffff-4 {
+		return nil, fmt.Errorf("file selection is too large")
+	}
+	extra := append([]byte(selectionExtraID), 0, 0)
+	binary.LittleEndian.PutUint16(extra[2:], uint16(len(data)))
+	return append(extra, data...), nil
+}
+
+// selectionFromGzipExtra finds the selection in a gzip extra field.
+func selectionFromGzipExtra(extra []byte) []byte {
+	for len(extra) >= 4 {
+		n := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+n {
+			break
+		}
+		if string(extra[:2]) == selectionExtraID {
+			return extra[4 : 4+n]
+		}
+		extra = extra[4+n:]
+	}
+	return nil
+}
+
+// ReadSelection returns the file selection recorded in the header of a
+// database, the zero Selection if there is none. Only the header is read.
+func ReadSelection(checksumFilePath string, key *DatabaseKey) (Selection, error) {
+	f, err := os.Open(checksumFilePath)
+	if err != nil {
+		return Selection{}, fmt.Errorf("could not open checksum database file '%s': %w", checksumFilePath, err)
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	switch {
+	case isShardIndex(br):
+		index, err := ReadShardIndex(checksumFilePath)
+		if err != nil || index.Selection == nil {
+			return Selection{}, err
+		}
+		return *index.Selection, nil
+	case isBinary(br):
+		info, err := f.Stat()
+		if err != nil {
+			return Selection{}, err
+		}
+		footer, err := readBinaryFooter(f, info.Size())
+		if err != nil {
+			return Selection{}, fmt.Errorf("could not read checksum database: %w", err)
+		}
+		return decodeSelection(footer.selection)
+	}
+	var r io.Reader = br
+	if isEncrypted(br) {
+		if key == nil {
+			return Selection{}, fmt.Errorf("could not open checksum database '%s': %w", checksumFilePath, ErrEncrypted)
+		}
+		if r, err = newDecryptReader(br, key); err != nil {
+			return Selection{}, fmt.Errorf("could not decrypt checksum database: %w", err)
+		}
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return Selection{}, fmt.Errorf("could not create gzip reader: %w", err)
+	}
+	defer gz.Close()
+	return decodeSelection(selectionFromGzipExtra(gz.Header.Extra))
+}
+
+// RecordedSelection returns the file selection recorded for the database
+// of opts: in its header, or in sidecar mode in that of the first sidecar
+// found. A missing database has the zero Selection.
+func RecordedSelection(opts Options) (Selection, error) {
+	checksumFilePath := opts.DatabasePath()
+	if opts.Sidecars {
+		dirs, err := findSidecars(context.Background(), opts)
+		if err != nil || len(dirs) == 0 {
+			return Selection{}, err
+		}
+		checksumFilePath = filepath.Join(opts.RootPath(), dirs[0], SidecarFileName)
+	}
+	if _, err := os.Stat(checksumFilePath); os.IsNotExist(err) {
+		return Selection{}, nil
+	}
+	return ReadSelection(checksumFilePath, opts.DatabaseKey)
+}
@@ -0,0 +1,146 @@
+package checksum
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"md5checker/checksum/checksumtest"
+)
+
+// testFileInfo is the file information selects looks at.
+type testFileInfo struct {
+	fs.FileInfo
+	size    int64
+	modTime time.Time
+}
+
+func (i testFileInfo) Size() int64        { return i.size }
+func (i testFileInfo) ModTime() time.Time { return i.modTime }
+
+func TestSelectionValidate(t *testing.T) {
+	invalid := []Selection{
+		{MinSize: -1},
+		{MinSize: 100, MaxSize: 10},
+		{NewerThan: 24 * time.Hour, OlderThan: 48 * time.Hour},
+	}
+	for _, s := range invalid {
+		if err := s.validate(); err == nil {
+			t.Errorf("%+v passed validation", s)
+		}
+	}
+
+	s := Selection{Extensions: []string{"PDF", "*.docx", " .txt", "pdf", ""}, SkipExtensions: []string{"TMP"}}
+	if err := s.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{".pdf", ".docx", ".txt"}; !reflect.DeepEqual(s.Extensions, want) {
+		t.Errorf("extensions = %q, want %q", s.Extensions, want)
+	}
+	if want := []string{".tmp"}; !reflect.DeepEqual(s.SkipExtensions, want) {
+		t.Errorf("skipped extensions = %q, want %q", s.SkipExtensions, want)
+	}
+}
+
+func TestSelectionSelects(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	file := func(size int64, age time.Duration) fs.FileInfo {
+		return testFileInfo{size: size, modTime: now.Add(-age)}
+	}
+	tests := []struct {
+		name      string
+		selection Selection
+		file      string
+		info      fs.FileInfo
+		want      bool
+	}{
+		{"zero selection", Selection{}, ".hidden", file(0, 0), true},
+		{"below min size", Selection{MinSize: 10}, "a", file(9, 0), false},
+		{"at min size", Selection{MinSize: 10}, "a", file(10, 0), true},
+		{"above max size", Selection{MaxSize: 10}, "a", file(11, 0), false},
+		{"newer than", Selection{NewerThan: 7 * day}, "a", file(1, 6*day), true},
+		{"not newer than", Selection{NewerThan: 7 * day}, "a", file(1, 8*day), false},
+		{"older than", Selection{OlderThan: 30 * day}, "a", file(1, 31*day), true},
+		{"not older than", Selection{OlderThan: 30 * day}, "a", file(1, 29*day), false},
+		{"extension", Selection{Extensions: []string{".pdf"}}, "report.PDF", file(1, 0), true},
+		{"other extension", Selection{Extensions: []string{".pdf"}}, "report.txt", file(1, 0), false},
+		{"skipped extension", Selection{SkipExtensions: []string{".tmp"}}, "x.tmp", file(1, 0), false},
+		{"hidden", Selection{SkipHidden: true}, ".env", file(1, 0), false},
+		{"not hidden", Selection{SkipHidden: true}, "env", file(1, 0), true},
+	}
+	for _, tt := range tests {
+		if got := tt.selection.selects(tt.file, tt.info, now); got != tt.want {
+			t.Errorf("%s: selects(%s) = %v, want %v", tt.name, tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestSelectedScanAndVerify(t *testing.T) {
+	root := checksumtest.WriteTree(t, map[string]string{
+		"small.txt":      "tiny",
+		"doc.PDF":        "a pdf document",
+		"big.txt":        "a text file over the size limit",
+		"notes.tmp":      "temporary",
+		".env":           "hidden file",
+		".git/config":    "in a hidden directory",
+		"old/report.txt": "an old report",
+	})
+	old := time.Now().Add(-90 * 24 * time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "old", "report.txt"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	selection := Selection{MaxSize: 20, NewerThan: 30 * 24 * time.Hour, SkipExtensions: []string{"tmp"}, SkipHidden: true}
+	opts := Options{Root: root, Selection: selection}
+	if err := opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	checksumDB := make(Database)
+	scanTestTree(t, opts, checksumDB)
+	if want := []string{"doc.PDF", "small.txt"}; !reflect.DeepEqual(sortedPaths(checksumDB), want) {
+		t.Errorf("selected files = %v, want %v", sortedPaths(checksumDB), want)
+	}
+
+	// Verify picks up the selection recorded in the database: unselected
+	// files are not NEW, and a file that grew past the size limit is not
+	// DELETED
+	if err := checksumDB.SaveAs(filepath.Join(root, DatabaseFileName), FormatJSON, nil, opts.Selection); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "small.txt"), []byte("no longer small enough to select"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	verifyOpts := Options{Root: root}
+	report := verifyTestTree(t, verifyOpts)
+	if want := []string{"OK doc.PDF"}; !reflect.DeepEqual(reportedPaths(report), want) {
+		t.Errorf("verify reported %v, want %v", reportedPaths(report), want)
+	}
+	if report.Selection == nil || !report.Selection.equal(opts.Selection) {
+		t.Errorf("report selection = %v, want %v", report.Selection, opts.Selection)
+	}
+
+	recorded, err := RecordedSelection(verifyOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !recorded.equal(opts.Selection) {
+		t.Errorf("recorded selection = %v, want %v", recorded, opts.Selection)
+	}
+}
+
+func TestSelectionHeaderWithoutSelection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DatabaseFileName)
+	if err := testDatabase(3).SaveAs(path, FormatJSON, nil, Selection{}); err != nil {
+		t.Fatal(err)
+	}
+	if recorded, err := ReadSelection(path, nil); err != nil || !recorded.IsZero() {
+		t.Errorf("ReadSelection = %v, %v, want the zero selection", recorded, err)
+	}
+	if recorded, err := RecordedSelection(Options{Root: t.TempDir()}); err != nil || !recorded.IsZero() {
+		t.Errorf("RecordedSelection of a missing database = %v, %v, want the zero selection", recorded, err)
+	}
+}
@@ -0,0 +1,433 @@
+package checksum
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Shard modes: one shard per top-level directory, or shards of a bounded
+// number of paths each.
+const (
+	ShardByDirectory = "dir"
+	ShardByEntries   = "entries"
+)
+
+// shardIndexMagic starts the index of a sharded database. The rest of the
+// file is the ShardIndex as JSON.
+const shardIndexMagic = "MD5CSHARDS1\n"
+
+// errShardedEncrypted is returned when a sharded database would be
+// encrypted: the index lists its top-level directories in the clear.
+var errShardedEncrypted = errors.New("sharded databases cannot be encrypted")
+
+// ShardIndex is the index of a sharded database, stored at the database
+// path. The shards are ordinary databases in the shard directory, each
+// holding the paths of one shard; a content hash stored under paths of
+// several shards has an entry in each.
+type ShardIndex struct {
+	Mode       string  `json:"Mode"`
+	MaxEntries int     `json:"MaxEntries,omitempty"`
+	Format     string  `json:"Format"`
+	Shards     []Shard `json:"Shards"`
+	// Selection is the file selection the database was scanned with.
+	Selection *Selection `json:"Selection,omitempty"`
+}
+
+// Shard is one shard of a sharded database. Key is the top-level
+// directory of its paths, "" for the files in the root, or in entries mode
+// the first path of its range, slash-separated so the index reads the same
+// on every platform. SHA256 is the hash of the shard file, so a
+// signature of the index covers every shard. Digest identifies the
+// contents apart from timestamps, to tell whether the shard changed.
+type Shard struct {
+	Key    string `json:"Key"`
+	File   string `json:"File"`
+	Paths  int    `json:"Paths"`
+	Digest string `json:"Digest"`
+	SHA256 string `json:"SHA256"`
+}
+
+// ShardDir returns the directory holding the shards of a database.
+func ShardDir(checksumFilePath string) string {
+	return checksumFilePath + ".shards"
+}
+
+func isShardIndex(r *bufio.Reader) bool {
+	magic, err := r.Peek(len(shardIndexMagic))
+	return err == nil && string(magic) == shardIndexMagic
+}
+
+// IsShardedDatabase reports whether the database file is a shard index.
+func IsShardedDatabase(checksumFilePath string) (bool, error) {
+	f, err := os.Open(checksumFilePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return isShardIndex(bufio.NewReader(f)), nil
+}
+
+// ReadShardIndex reads the index of a sharded database.
+func ReadShardIndex(checksumFilePath string) (*ShardIndex, error) {
+	data, err := os.ReadFile(checksumFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open checksum database file '%s': %w", checksumFilePath, err)
+	}
+	rest, ok := strings.CutPrefix(string(data), shardIndexMagic)
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a sharded database", checksumFilePath)
+	}
+	var index ShardIndex
+	if err := json.Unmarshal([]byte(rest), &index); err != nil {
+		return nil, fmt.Errorf("could not parse shard index: %w", err)
+	}
+	// Indexes written on Windows before keys were slash-separated
+	for i := range index.Shards {
+		index.Shards[i].Key = filepath.ToSlash(index.Shards[i].Key)
+	}
+	return &index, nil
+}
+
+// shardKey returns the key of the shard holding relPath in directory mode.
+func shardKey(relPath string) string {
+	dir, _, found := strings.Cut(filepath.ToSlash(relPath), "/")
+	if !found {
+		return ""
+	}
+	return dir
+}
+
+// Find returns the position of the shard that holds relPath, or -1.
+func (index *ShardIndex) Find(relPath string) int {
+	relPath = filepath.ToSlash(relPath)
+	if index.Mode == ShardByDirectory {
+		key := shardKey(relPath)
+		for i, shard := range index.Shards {
+			if shard.Key == key {
+				return i
+			}
+		}
+		return -1
+	}
+	if len(index.Shards) == 0 {
+		return -1
+	}
+	i := sort.Search(len(index.Shards), func(i int) bool { return index.Shards[i].Key > relPath })
+	return max(i-1, 0)
+}
+
+// Lookup returns the position of the shard with the given key, or -1.
+func (index *ShardIndex) Lookup(key string) int {
+	for i, shard := range index.Shards {
+		if shard.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// LoadShard reads shard i of a sharded database, after checking it
+// against the index.
+func LoadShard(checksumFilePath string, index *ShardIndex, i int, key *DatabaseKey) (Database, error) {
+	path, err := checkShard(checksumFilePath, index, i)
+	if err != nil {
+		return nil, err
+	}
+	return LoadDatabase(path, key)
+}
+
+// checkShard checks the file of shard i against the hash in the index and
+// returns its path.
+func checkShard(checksumFilePath string, index *ShardIndex, i int) (string, error) {
+	shard := index.Shards[i]
+	path := ShardPath(checksumFilePath, index, i)
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read shard '%s': %w", shard.Key, err)
+	}
+	if sum != shard.SHA256 {
+		return "", fmt.Errorf("shard '%s' (%s) does not match the index: it was changed outside md5checker", shard.Key, shard.File)
+	}
+	return path, nil
+}
+
+// selectShard returns the position of the shard named by Options.Shard,
+// where "." names the files in the root or the first range.
+func (index *ShardIndex) selectShard(name string) (int, error) {
+	key := filepath.ToSlash(name)
+	if key == "." {
+		key = ""
+	}
+	i := index.Lookup(key)
+	if i < 0 {
+		return -1, fmt.Errorf("the database has no shard '%s'", name)
+	}
+	return i, nil
+}
+
+// scopeToShard restricts the scanned files to those of shard i. In
+// directory mode, the other top-level directories are not walked at all.
+func (index *ShardIndex) scopeToShard(opts *Options, i int) {
+	opts.pathFilter = func(relPath string, isDir bool) bool {
+		if !isDir {
+			return index.Find(relPath) == i
+		}
+		if index.Mode != ShardByDirectory || strings.ContainsRune(relPath, filepath.Separator) {
+			return true
+		}
+		return relPath == index.Shards[i].Key
+	}
+}
+
+// ShardPath returns the path of the file of shard i.
+func ShardPath(checksumFilePath string, index *ShardIndex, i int) string {
+	return filepath.Join(filepath.Dir(checksumFilePath), filepath.FromSlash(index.Shards[i].File))
+}
+
+// loadShardedDatabase reads and merges every shard of a sharded database.
+func loadShardedDatabase(checksumFilePath string, key *DatabaseKey) (Database, error) {
+	index, err := ReadShardIndex(checksumFilePath)
+	if err != nil {
+		return nil, err
+	}
+	checksumDB := make(Database)
+	for i := range index.Shards {
+		shardDB, err := LoadShard(checksumFilePath, index, i, key)
+		if err != nil {
+			return nil, err
+		}
+		for hash, infoData := range shardDB {
+			checksumDB[hash] = mergeEntries(checksumDB[hash], infoData)
+		}
+	}
+	return checksumDB, nil
+}
+
+// mergeEntries combines the entries of one content hash from two shards.
+func mergeEntries(a, b InfoData) InfoData {
+	if a.ContentMD5 == "" {
+		return b
+	}
+	a.RelativePaths = append(a.RelativePaths, b.RelativePaths...)
+	a.FirstCreated = min(a.FirstCreated, b.FirstCreated)
+	a.LastContentUpdate = max(a.LastContentUpdate, b.LastContentUpdate)
+	if a.Chunks == nil {
+		a.Chunks = b.Chunks
+	}
+	return a
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// shardDigest hashes the contents of a shard without the LastSeen and
+// LastContentUpdate timestamps, which every add refreshes.
+func shardDigest(shardDB Database) string {
+	h := sha256.New()
+	for _, hash := range sortedKeys(shardDB) {
+		infoData := shardDB[hash]
+		infoData.LastContentUpdate = ""
+		paths := make([]PathEntry, len(infoData.RelativePaths))
+		for i, p := range infoData.RelativePaths {
+			p.LastSeen = ""
+			paths[i] = p
+		}
+		infoData.RelativePaths = paths
+		data, _ := json.Marshal(infoData)
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// partition splits the database into shards: by top-level directory, or
+// along the ranges of the previous index, splitting ranges that grew past
+// twice maxEntries. It returns the shard keys in order.
+func (db Database) partition(mode string, maxEntries int, previous *ShardIndex) ([]string, map[string]Database) {
+	index := &ShardIndex{Mode: mode}
+	if mode == ShardByEntries {
+		index.Shards = []Shard{{Key: ""}}
+		if previous != nil && previous.Mode == mode && len(previous.Shards) > 0 {
+			index.Shards = previous.Shards
+		}
+	}
+	paths := make(map[string][]string)
+	for hash, infoData := range db {
+		for _, p := range infoData.RelativePaths {
+			key := shardKey(p.Path)
+			if mode == ShardByEntries {
+				key = index.Shards[index.Find(p.Path)].Key
+			}
+			paths[key] = append(paths[key], hash+"\x00"+p.Path)
+		}
+	}
+
+	// Split ranges that grew too large into pieces of maxEntries
+	if mode == ShardByEntries {
+		for _, key := range sortedKeys(paths) {
+			members := paths[key]
+			if len(members) <= 2*maxEntries && (previous != nil && previous.Mode == mode) {
+				continue
+			}
+			sort.Slice(members, func(i, j int) bool {
+				_, a, _ := strings.Cut(members[i], "\x00")
+				_, b, _ := strings.Cut(members[j], "\x00")
+				return filepath.ToSlash(a) < filepath.ToSlash(b)
+			})
+			delete(paths, key)
+			for start := 0; start < len(members); start += maxEntries {
+				piece := members[start:min(start+maxEntries, len(members))]
+				pieceKey := key
+				if start > 0 {
+					_, pieceKey, _ = strings.Cut(piece[0], "\x00")
+					pieceKey = filepath.ToSlash(pieceKey)
+				}
+				paths[pieceKey] = piece
+			}
+		}
+	}
+
+	shards := make(map[string]Database, len(paths))
+	for key, members := range paths {
+		shardDB := make(Database)
+		for _, member := range members {
+			hash, path, _ := strings.Cut(member, "\x00")
+			infoData, exists := shardDB[hash]
+			if !exists {
+				infoData = db[hash]
+				infoData.RelativePaths = nil
+			}
+			infoData.RelativePaths = append(infoData.RelativePaths, *findPathEntry(db[hash].RelativePaths, path))
+			shardDB[hash] = infoData
+		}
+		shards[key] = shardDB
+	}
+	return sortedKeys(shards), shards
+}
+
+// SaveSharded writes the database as a sharded database in the given mode
+// and format. Only the shards whose contents changed since the previous
+// index are written; unchanged shards keep their earlier LastSeen times.
+// New shard files are written before the index replaces the old one, so an
+// interrupted save leaves the previous database intact. It returns the
+// number of shards written and the total number of shards. The selection
+// is recorded in the index.
+func (db Database) SaveSharded(checksumFilePath, mode string, maxEntries int, format string, key *DatabaseKey, selection Selection) (written, total int, err error) {
+	if key != nil {
+		return 0, 0, errShardedEncrypted
+	}
+	if mode != ShardByDirectory && mode != ShardByEntries {
+		return 0, 0, fmt.Errorf("invalid shard mode '%s', expected '%s' or '%s'", mode, ShardByDirectory, ShardByEntries)
+	}
+	if mode == ShardByEntries && maxEntries < 1 {
+		return 0, 0, fmt.Errorf("shards need at least one entry each")
+	}
+	var previous *ShardIndex
+	if sharded, _ := IsShardedDatabase(checksumFilePath); sharded {
+		if previous, err = ReadShardIndex(checksumFilePath); err != nil {
+			return 0, 0, err
+		}
+	}
+	dir := ShardDir(checksumFilePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, 0, fmt.Errorf("could not create shard directory: %w", err)
+	}
+
+	keys, shards := db.partition(mode, maxEntries, previous)
+	index := ShardIndex{Mode: mode, Format: format, Shards: []Shard{}}
+	if mode == ShardByEntries {
+		index.MaxEntries = maxEntries
+	}
+	if !selection.IsZero() {
+		index.Selection = &selection
+	}
+	ext := ".json.gz"
+	if format == FormatBinary {
+		ext = ".bin"
+	}
+	for _, shardKey := range keys {
+		shardDB := shards[shardKey]
+		shard := Shard{Key: shardKey, Digest: shardDigest(shardDB)}
+		for _, p := range shardDB {
+			shard.Paths += len(p.RelativePaths)
+		}
+		if previous != nil && previous.Format == format {
+			if i := previous.Lookup(shardKey); i >= 0 && previous.Shards[i].Digest == shard.Digest {
+				old := previous.Shards[i]
+				if sum, err := fileSHA256(ShardPath(checksumFilePath, previous, i)); err == nil && sum == old.SHA256 {
+					shard.File, shard.SHA256 = old.File, old.SHA256
+					index.Shards = append(index.Shards, shard)
+					continue
+				}
+			}
+		}
+		keyHash := sha256.Sum256([]byte(shardKey))
+		name := hex.EncodeToString(keyHash[:8]) + "-" + shard.Digest[:8] + ext
+		path := filepath.Join(dir, name)
+		if err := shardDB.SaveAs(path, format, nil, Selection{}); err != nil {
+			return written, 0, err
+		}
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return written, 0, err
+		}
+		shard.File = filepath.ToSlash(filepath.Join(filepath.Base(dir), name))
+		shard.SHA256 = sum
+		index.Shards = append(index.Shards, shard)
+		written++
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return written, 0, err
+	}
+	file, err := os.CreateTemp(filepath.Dir(checksumFilePath), filepath.Base(checksumFilePath)+".*.tmp")
+	if err != nil {
+		return written, 0, fmt.Errorf("could not create checksum file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if _, err := file.WriteString(shardIndexMagic + string(data) + "\n"); err != nil {
+		return written, 0, fmt.Errorf("could not write checksum file: %w", err)
+	}
+	if err := replaceDatabase(file, checksumFilePath); err != nil {
+		return written, 0, err
+	}
+
+	// Remove the shard files the new index no longer refers to
+	referenced := make(map[string]bool, len(index.Shards))
+	for _, shard := range index.Shards {
+		referenced[filepath.Base(filepath.FromSlash(shard.File))] = true
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if !referenced[entry.Name()] {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+	return written, len(index.Shards), nil
+}
+
+// RemoveShards deletes the shard directory of a database that is no longer
+// sharded.
+func RemoveShards(checksumFilePath string) error {
+	return os.RemoveAll(ShardDir(checksumFilePath))
+}
@@ -0,0 +1,158 @@
+package checksum
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPartitionByDirectory(t *testing.T) {
+	checksumDB := NewDatabase(map[string]string{
+		filepath.FromSlash("a/1.txt"):   "h1",
+		filepath.FromSlash("a/b/2.txt"): "h2",
+		filepath.FromSlash("b/3.txt"):   "h1",
+		"root.txt":                      "h3",
+	})
+	keys, shards := checksumDB.partition(ShardByDirectory, 0, nil)
+	if want := []string{"", "a", "b"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("shard keys %q, want %q", keys, want)
+	}
+	want := map[string][]string{
+		"":  {"root.txt"},
+		"a": {"a/1.txt", "a/b/2.txt"},
+		"b": {"b/3.txt"},
+	}
+	for key, paths := range want {
+		if got := sortedPaths(shards[key]); !reflect.DeepEqual(got, paths) {
+			t.Errorf("shard '%s' holds %q, want %q", key, got, paths)
+		}
+	}
+	// A content stored in two directories has an entry in both shards
+	if _, ok := shards["a"]["h1"]; !ok {
+		t.Error("shard 'a' has no entry for h1")
+	}
+	if _, ok := shards["b"]["h1"]; !ok {
+		t.Error("shard 'b' has no entry for h1")
+	}
+}
+
+func TestPartitionByEntries(t *testing.T) {
+	checksumDB := testDatabase(100)
+	keys, shards := checksumDB.partition(ShardByEntries, 30, nil)
+	if len(keys) != 4 {
+		t.Fatalf("got %d shards, want 4", len(keys))
+	}
+	index := &ShardIndex{Mode: ShardByEntries}
+	total := 0
+	for _, key := range keys {
+		if strings.Contains(key, `\`) {
+			t.Errorf("shard key %q is not slash-separated", key)
+		}
+		n := len(shards[key].PathIndex())
+		if n > 30 {
+			t.Errorf("shard '%s' holds %d paths, want at most 30", key, n)
+		}
+		total += n
+		index.Shards = append(index.Shards, Shard{Key: key})
+	}
+	if total != 100 {
+		t.Errorf("shards hold %d paths, want 100", total)
+	}
+	for i, key := range keys {
+		for path := range shards[key].PathIndex() {
+			if got := index.Find(path); got != i {
+				t.Errorf("Find(%s) = %d, want %d", path, got, i)
+			}
+		}
+	}
+}
+
+// saveShardedTest saves checksumDB sharded by entries and returns the
+// number of shards written.
+func saveShardedTest(t *testing.T, checksumDB Database, dbPath, format string) int {
+	t.Helper()
+	written, _, err := checksumDB.SaveSharded(dbPath, ShardByEntries, 10, format, nil, Selection{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return written
+}
+
+func TestShardedRoundTrip(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatBinary} {
+		t.Run(format, func(t *testing.T) {
+			dbPath := filepath.Join(t.TempDir(), "db")
+			checksumDB := testDatabase(50)
+			if written := saveShardedTest(t, checksumDB, dbPath, format); written != 5 {
+				t.Fatalf("wrote %d shards, want 5", written)
+			}
+			loaded, err := LoadDatabase(dbPath, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(loaded.PathIndex(), checksumDB.PathIndex()) {
+				t.Error("the sharded database does not load back the paths it was saved with")
+			}
+		})
+	}
+}
+
+func TestShardedSkipsUnchangedShards(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "db")
+	checksumDB := testDatabase(50)
+	saveShardedTest(t, checksumDB, dbPath, FormatJSON)
+	if written := saveShardedTest(t, checksumDB, dbPath, FormatJSON); written != 0 {
+		t.Errorf("saving again wrote %d shards, want 0", written)
+	}
+
+	// A scan refreshes LastSeen, which leaves the shards as they are
+	for hash, infoData := range checksumDB {
+		for i := range infoData.RelativePaths {
+			infoData.RelativePaths[i].LastSeen = "2030-01-01T00:00:00Z"
+		}
+		checksumDB[hash] = infoData
+	}
+	if written := saveShardedTest(t, checksumDB, dbPath, FormatJSON); written != 0 {
+		t.Errorf("saving new LastSeen times wrote %d shards, want 0", written)
+	}
+
+	// Moving one path to new content rewrites only its shard
+	hash := checksumDB.PathIndex()[filepath.FromSlash("dir3/file-3.txt")]
+	infoData := checksumDB[hash]
+	delete(checksumDB, hash)
+	infoData.ContentMD5 = "0123456789abcdef0123456789abcdef"
+	checksumDB[infoData.ContentMD5] = infoData
+	if written := saveShardedTest(t, checksumDB, dbPath, FormatJSON); written != 1 {
+		t.Errorf("changing one path wrote %d shards, want 1", written)
+	}
+	entries, err := os.ReadDir(ShardDir(dbPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Errorf("shard directory holds %d files, want 5", len(entries))
+	}
+}
+
+func TestShardedDetectsTamperedShard(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "db")
+	saveShardedTest(t, testDatabase(20), dbPath, FormatJSON)
+	index, err := ReadShardIndex(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shardPath := ShardPath(dbPath, index, 1)
+	data, err := os.ReadFile(shardPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(shardPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDatabase(dbPath, nil); err == nil || !strings.Contains(err.Error(), "does not match the index") {
+		t.Errorf("loading a tampered shard gave %v, want a mismatch error", err)
+	}
+}
@@ -0,0 +1,128 @@
+package checksum
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SidecarFileName is the manifest kept in every directory in sidecar mode.
+// It is an ordinary database holding the files of its directory, with
+// paths relative to the directory, so a copied directory carries its own
+// verification data.
+const SidecarFileName = ".checksums.json.gz"
+
+// sidecarDir returns the directory whose sidecar holds relPath. Archive
+// members belong to the directory of their archive.
+func sidecarDir(relPath string) string {
+	if archivePath, _, ok := splitArchivePath(relPath); ok {
+		relPath = archivePath
+	}
+	return filepath.Dir(relPath)
+}
+
+// findSidecars returns the directories under the root of opts that hold a
+// sidecar, relative to the root. Excluded directories are not searched.
+func findSidecars(ctx context.Context, opts Options) ([]string, error) {
+	baseLocationPath := opts.RootPath()
+	excludes := opts.excludePatterns()
+	var dirs []string
+	err := filepath.WalkDir(baseLocationPath, func(path string, d os.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return nil
+		}
+		relPath, _ := filepath.Rel(baseLocationPath, path)
+		if d.IsDir() && relPath != "." && matchesAny(excludes, relPath) {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && d.Name() == SidecarFileName {
+			dirs = append(dirs, filepath.Dir(relPath))
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// LoadSidecars reads the sidecars under the root of opts and combines them
+// into one database, with paths relative to the root.
+func LoadSidecars(ctx context.Context, opts Options) (Database, error) {
+	dirs, err := findSidecars(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	checksumDB := make(Database)
+	for _, dir := range dirs {
+		sidecarDB, err := LoadDatabase(filepath.Join(opts.RootPath(), dir, SidecarFileName), opts.DatabaseKey)
+		if err != nil {
+			return nil, err
+		}
+		for hash, infoData := range sidecarDB {
+			for i := range infoData.RelativePaths {
+				infoData.RelativePaths[i].Path = filepath.Join(dir, infoData.RelativePaths[i].Path)
+			}
+			checksumDB[hash] = mergeEntries(checksumDB[hash], infoData)
+		}
+	}
+	return checksumDB, nil
+}
+
+// SaveSidecars writes the database as one sidecar per directory, each
+// recording the selection of opts. Sidecars whose contents and selection
+// did not change apart from timestamps are left alone, and those of
+// directories without files in the database are removed. It returns the
+// number of sidecars written and the total number.
+func (db Database) SaveSidecars(ctx context.Context, opts Options) (written, total int, err error) {
+	// Group the entries by directory, with paths relative to it
+	sidecars := make(map[string]Database)
+	for hash, infoData := range db {
+		for _, p := range infoData.RelativePaths {
+			dir := sidecarDir(p.Path)
+			sidecarDB := sidecars[dir]
+			if sidecarDB == nil {
+				sidecarDB = make(Database)
+				sidecars[dir] = sidecarDB
+			}
+			entry, exists := sidecarDB[hash]
+			if !exists {
+				entry = infoData
+				entry.RelativePaths = nil
+			}
+			if dir != "." {
+				p.Path = strings.TrimPrefix(p.Path, dir+string(filepath.Separator))
+			}
+			entry.RelativePaths = append(entry.RelativePaths, p)
+			sidecarDB[hash] = entry
+		}
+	}
+
+	existing, err := findSidecars(ctx, opts)
+	if err != nil {
+		return 0, 0, err
+	}
+	baseLocationPath := opts.RootPath()
+	for _, dir := range sortedKeys(sidecars) {
+		path := filepath.Join(baseLocationPath, dir, SidecarFileName)
+		if previous, err := LoadDatabase(path, opts.DatabaseKey); err == nil && shardDigest(previous) == shardDigest(sidecars[dir]) {
+			if selection, err := ReadSelection(path, opts.DatabaseKey); err == nil && selection.equal(opts.Selection) {
+				continue
+			}
+		}
+		if err := sidecars[dir].SaveAs(path, FormatJSON, opts.DatabaseKey, opts.Selection); err != nil {
+			return written, len(sidecars), fmt.Errorf("could not write sidecar in '%s': %w", dir, err)
+		}
+		written++
+	}
+	for _, dir := range existing {
+		if _, kept := sidecars[dir]; !kept {
+			if err := os.Remove(filepath.Join(baseLocationPath, dir, SidecarFileName)); err != nil {
+				return written, len(sidecars), err
+			}
+		}
+	}
+	return written, len(sidecars), nil
+}
@@ -0,0 +1,111 @@
+package checksum
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"md5checker/checksum/checksumtest"
+)
+
+// saveTestSidecars writes checksumDB as sidecars and returns the number
+// written.
+func saveTestSidecars(t *testing.T, checksumDB Database, opts Options) int {
+	t.Helper()
+	written, _, err := checksumDB.SaveSidecars(t.Context(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return written
+}
+
+func TestSidecarsRoundTrip(t *testing.T) {
+	root := checksumtest.WriteTree(t, map[string]string{
+		"a.txt":           "a",
+		"docs/b.txt":      "b",
+		"docs/deep/c.txt": "c",
+		"docs/deep/d.txt": "a",
+	})
+	opts := Options{Root: root, Sidecars: true}
+	checksumDB := make(Database)
+	scanTestTree(t, opts, checksumDB)
+	if written := saveTestSidecars(t, checksumDB, opts); written != 3 {
+		t.Fatalf("wrote %d sidecars, want 3", written)
+	}
+
+	// Each sidecar holds the files of its directory, relative to it
+	docs, err := LoadDatabase(filepath.Join(root, "docs", SidecarFileName), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sortedPaths(docs); !reflect.DeepEqual(got, []string{"b.txt"}) {
+		t.Errorf("sidecar of docs holds %q, want [b.txt]", got)
+	}
+	loaded, err := LoadSidecars(t.Context(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.PathIndex(), checksumDB.PathIndex()) {
+		t.Errorf("the sidecars load back as %v, want %v", loaded.PathIndex(), checksumDB.PathIndex())
+	}
+
+	// Sidecars are not scanned, and unchanged ones are not rewritten
+	scanTestTree(t, opts, checksumDB)
+	if got := sortedPaths(checksumDB); len(got) != 4 {
+		t.Errorf("a second scan recorded %q, want the 4 files", got)
+	}
+	if written := saveTestSidecars(t, checksumDB, opts); written != 0 {
+		t.Errorf("saving again wrote %d sidecars, want 0", written)
+	}
+
+	// A copied directory verifies on its own
+	report := verifyTestTree(t, Options{Root: filepath.Join(root, "docs"), Sidecars: true})
+	want := []string{"OK b.txt", "OK deep/c.txt", "OK deep/d.txt"}
+	if got := reportedPaths(report); !reflect.DeepEqual(got, want) {
+		t.Errorf("verify of docs reported %v, want %v", got, want)
+	}
+}
+
+func TestSidecarsStale(t *testing.T) {
+	root := checksumtest.WriteTree(t, map[string]string{
+		"a.txt":           "a",
+		"docs/b.txt":      "b",
+		"docs/deep/c.txt": "c",
+	})
+	opts := Options{Root: root, Sidecars: true}
+	checksumDB := make(Database)
+	scanTestTree(t, opts, checksumDB)
+	saveTestSidecars(t, checksumDB, opts)
+
+	// A sidecar that no longer matches its directory is reported
+	if err := os.WriteFile(filepath.Join(root, "docs", "b.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(root, "docs", "deep", "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	report := verifyTestTree(t, opts)
+	want := []string{"OK a.txt", "MODIFIED docs/b.txt", "DELETED docs/deep/c.txt"}
+	if got := reportedPaths(report); !reflect.DeepEqual(got, want) {
+		t.Errorf("verify reported %v, want %v", got, want)
+	}
+
+	// Regenerating rewrites the changed sidecar and removes the one of
+	// the directory without files
+	if err := opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&Scanner{Options: opts}).Update(t.Context(), checksumDB, true); err != nil {
+		t.Fatal(err)
+	}
+	if written := saveTestSidecars(t, checksumDB, opts); written != 1 {
+		t.Errorf("saving after regenerate wrote %d sidecars, want 1", written)
+	}
+	if _, err := os.Stat(filepath.Join(root, "docs", "deep", SidecarFileName)); !os.IsNotExist(err) {
+		t.Errorf("the sidecar of an emptied directory was kept (%v)", err)
+	}
+	if got := reportedPaths(verifyTestTree(t, opts)); !reflect.DeepEqual(got, []string{"OK a.txt", "OK docs/b.txt"}) {
+		t.Errorf("verify after regenerate reported %v", got)
+	}
+}
@@ -0,0 +1,269 @@
+package checksum
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Key types. Ed25519 databases are signed with the private key and checked
+// with the public key, which may be kept on the scanned machine; HMAC
+// databases need the same secret for both.
+const (
+	KeyEd25519 = "ed25519"
+	KeyHMAC    = "hmac-sha256"
+)
+
+// PEM block types of key files.
+const (
+	pemPrivateKey = "PRIVATE KEY"
+	pemPublicKey  = "PUBLIC KEY"
+	pemHMACSecret = "MD5CHECKER HMAC SECRET"
+)
+
+var (
+	// ErrNotSigned is returned for a database without a signature file.
+	ErrNotSigned = errors.New("the database is not signed")
+	// ErrBadSignature is returned when the signature does not match the
+	// database, which was changed after it was signed.
+	ErrBadSignature = errors.New("the database signature does not match")
+)
+
+// Key signs checksum databases and checks their signatures.
+type Key struct {
+	Type    string
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+	secret  []byte
+}
+
+// GenerateKey returns a new random key of the given type.
+func GenerateKey(keyType string) (*Key, error) {
+	switch keyType {
+	case KeyEd25519:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return &Key{Type: KeyEd25519, private: private, public: public}, nil
+	case KeyHMAC:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return &Key{Type: KeyHMAC, secret: secret}, nil
+	}
+	return nil, fmt.Errorf("invalid key type '%s', expected '%s' or '%s'", keyType, KeyEd25519, KeyHMAC)
+}
+
+// LoadKey reads an Ed25519 private or public key, or an HMAC secret, from a
+// PEM file.
+func LoadKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key file '%s': %w", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key file '%s' is not PEM encoded", path)
+	}
+	switch block.Type {
+	case pemPrivateKey:
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if private, ok := parsed.(ed25519.PrivateKey); err == nil && ok {
+			return &Key{Type: KeyEd25519, private: private, public: private.Public().(ed25519.PublicKey)}, nil
+		}
+	case pemPublicKey:
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if public, ok := parsed.(ed25519.PublicKey); err == nil && ok {
+			return &Key{Type: KeyEd25519, public: public}, nil
+		}
+	case pemHMACSecret:
+		if len(block.Bytes) > 0 {
+			return &Key{Type: KeyHMAC, secret: block.Bytes}, nil
+		}
+	}
+	return nil, fmt.Errorf("key file '%s' holds no Ed25519 key or HMAC secret", path)
+}
+
+// CanSign reports whether the key can create signatures, which a public
+// key alone cannot.
+func (k *Key) CanSign() bool {
+	return k.private != nil || k.secret != nil
+}
+
+// ID identifies the key in signature files without revealing it.
+func (k *Key) ID() string {
+	var sum []byte
+	if k.Type == KeyHMAC {
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write([]byte("md5checker key id"))
+		sum = mac.Sum(nil)
+	} else {
+		digest := sha256.Sum256(k.public)
+		sum = digest[:]
+	}
+	return hex.EncodeToString(sum[:8])
+}
+
+// PrivatePEM encodes the private key or the HMAC secret.
+func (k *Key) PrivatePEM() ([]byte, error) {
+	if k.Type == KeyHMAC {
+		return pem.EncodeToMemory(&pem.Block{Type: pemHMACSecret, Bytes: k.secret}), nil
+	}
+	if k.private == nil {
+		return nil, fmt.Errorf("no private key")
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(k.private)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemPrivateKey, Bytes: der}), nil
+}
+
+// PublicPEM encodes the public key of an Ed25519 key.
+func (k *Key) PublicPEM() ([]byte, error) {
+	if k.Type != KeyEd25519 {
+		return nil, fmt.Errorf("%s keys have no public key", k.Type)
+	}
+	der, err := x509.MarshalPKIXPublicKey(k.public)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemPublicKey, Bytes: der}), nil
+}
+
+// digestFile streams the file through the hash of the key type: SHA-512
+// for Ed25519ph, or the HMAC itself.
+func (k *Key) digestFile(path string) ([]byte, error) {
+	var h hash.Hash
+	if k.Type == KeyHMAC {
+		h = hmac.New(sha256.New, k.secret)
+	} else {
+		h = sha512.New()
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// DatabaseSignature is the detached signature kept next to a database.
+type DatabaseSignature struct {
+	Type      string `json:"Type"`
+	KeyID     string `json:"KeyID"`
+	SignedAt  string `json:"SignedAt"`
+	Signature string `json:"Signature"`
+}
+
+// SignaturePath returns the path of the signature of a database.
+func SignaturePath(checksumFilePath string) string {
+	return checksumFilePath + ".sig"
+}
+
+// SignDatabase signs the database file and writes its signature next to it.
+// The tree and the journal kept with the database are not covered.
+func SignDatabase(checksumFilePath string, key *Key) error {
+	if !key.CanSign() {
+		return fmt.Errorf("a public key cannot sign, use the private key")
+	}
+	digest, err := key.digestFile(checksumFilePath)
+	if err != nil {
+		return fmt.Errorf("could not read checksum database: %w", err)
+	}
+	signature := digest
+	if key.Type == KeyEd25519 {
+		if signature, err = key.private.Sign(nil, digest, &ed25519.Options{Hash: crypto.SHA512}); err != nil {
+			return fmt.Errorf("could not sign checksum database: %w", err)
+		}
+	}
+	data, err := json.MarshalIndent(DatabaseSignature{
+		Type:      key.Type,
+		KeyID:     key.ID(),
+		SignedAt:  time.Now().UTC().Format(time.RFC3339),
+		Signature: base64.StdEncoding.EncodeToString(signature),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	signaturePath := SignaturePath(checksumFilePath)
+	file, err := os.CreateTemp(filepath.Dir(signaturePath), filepath.Base(signaturePath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create signature file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("could not write signature file: %w", err)
+	}
+	if err := file.Chmod(0644); err != nil {
+		return fmt.Errorf("could not write signature file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("could not write signature file: %w", err)
+	}
+	if err := os.Rename(file.Name(), signaturePath); err != nil {
+		return fmt.Errorf("could not replace signature file: %w", err)
+	}
+	return nil
+}
+
+// CheckDatabaseSignature checks the signature of the database file against
+// key. It returns the signature when it matches, and an error wrapping
+// ErrNotSigned or ErrBadSignature otherwise.
+func CheckDatabaseSignature(checksumFilePath string, key *Key) (*DatabaseSignature, error) {
+	signaturePath := SignaturePath(checksumFilePath)
+	data, err := os.ReadFile(signaturePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: '%s' not found", ErrNotSigned, signaturePath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read signature file: %w", err)
+	}
+	var signature DatabaseSignature
+	if err := json.Unmarshal(data, &signature); err != nil {
+		return nil, fmt.Errorf("%w: could not parse '%s'", ErrBadSignature, signaturePath)
+	}
+	if signature.Type != key.Type || signature.KeyID != key.ID() {
+		return nil, fmt.Errorf("%w: signed with %s key %s, checked with %s key %s", ErrBadSignature, signature.Type, signature.KeyID, key.Type, key.ID())
+	}
+	raw, err := base64.StdEncoding.DecodeString(signature.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not decode '%s'", ErrBadSignature, signaturePath)
+	}
+	digest, err := key.digestFile(checksumFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read checksum database: %w", err)
+	}
+	valid := false
+	if key.Type == KeyHMAC {
+		valid = hmac.Equal(raw, digest)
+	} else {
+		valid = ed25519.VerifyWithOptions(key.public, digest, raw, &ed25519.Options{Hash: crypto.SHA512}) == nil
+	}
+	if !valid {
+		return nil, fmt.Errorf("%w: the database was changed after it was signed on %s", ErrBadSignature, signature.SignedAt)
+	}
+	return &signature, nil
+}
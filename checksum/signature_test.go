@@ -0,0 +1,154 @@
+package checksum
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// signedTestDatabase saves a small database and signs it with key.
+func signedTestDatabase(t *testing.T, key *Key) string {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "checksums.json.gz")
+	if err := testDatabase(10).SaveAs(dbPath, FormatJSON, nil, Selection{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SignDatabase(dbPath, key); err != nil {
+		t.Fatal(err)
+	}
+	return dbPath
+}
+
+// flipByte changes one byte in the middle of the file at path.
+func flipByte(t *testing.T, path string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0x01
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignatureRoundTrip(t *testing.T) {
+	for _, keyType := range []string{KeyEd25519, KeyHMAC} {
+		t.Run(keyType, func(t *testing.T) {
+			key, err := GenerateKey(keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dbPath := signedTestDatabase(t, key)
+			signature, err := CheckDatabaseSignature(dbPath, key)
+			if err != nil {
+				t.Fatalf("checking a fresh signature: %v", err)
+			}
+			if signature.Type != keyType || signature.KeyID != key.ID() {
+				t.Errorf("signature names %s key %s, want %s key %s", signature.Type, signature.KeyID, keyType, key.ID())
+			}
+
+			other, err := GenerateKey(keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := CheckDatabaseSignature(dbPath, other); !errors.Is(err, ErrBadSignature) {
+				t.Errorf("checking with another key gave %v, want ErrBadSignature", err)
+			}
+
+			flipByte(t, dbPath)
+			if _, err := CheckDatabaseSignature(dbPath, key); !errors.Is(err, ErrBadSignature) {
+				t.Errorf("checking a tampered database gave %v, want ErrBadSignature", err)
+			}
+		})
+	}
+}
+
+func TestSignaturePublicKey(t *testing.T) {
+	key, err := GenerateKey(KeyEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPath := signedTestDatabase(t, key)
+	data, err := key.PublicPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPath := filepath.Join(t.TempDir(), "md5checker.key.pub")
+	if err := os.WriteFile(publicPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	public, err := LoadKey(publicPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public.CanSign() {
+		t.Error("a public key reports that it can sign")
+	}
+	if err := SignDatabase(dbPath, public); err == nil {
+		t.Error("signing with a public key succeeded")
+	}
+	if _, err := CheckDatabaseSignature(dbPath, public); err != nil {
+		t.Errorf("checking with the public key: %v", err)
+	}
+}
+
+func TestSignatureMissing(t *testing.T) {
+	key, err := GenerateKey(KeyHMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPath := signedTestDatabase(t, key)
+	if err := os.Remove(SignaturePath(dbPath)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckDatabaseSignature(dbPath, key); !errors.Is(err, ErrNotSigned) {
+		t.Errorf("checking an unsigned database gave %v, want ErrNotSigned", err)
+	}
+}
+
+func TestSignatureCoversShards(t *testing.T) {
+	key, err := GenerateKey(KeyEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(t.TempDir(), "checksums.json.gz")
+	if _, _, err := testDatabase(30).SaveSharded(dbPath, ShardByEntries, 10, FormatJSON, nil, Selection{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SignDatabase(dbPath, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckDatabaseSignature(dbPath, key); err != nil {
+		t.Fatalf("checking a signed shard index: %v", err)
+	}
+
+	// A changed shard no longer matches its hash in the signed index, and
+	// updating the hash breaks the signature
+	index, err := ReadShardIndex(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shardPath := ShardPath(dbPath, index, 1)
+	flipByte(t, shardPath)
+	if _, err := LoadDatabase(dbPath, nil); err == nil {
+		t.Error("loading a database with a tampered shard succeeded")
+	}
+	sum, err := fileSHA256(shardPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = []byte(strings.Replace(string(data), index.Shards[1].SHA256, sum, 1))
+	if err := os.WriteFile(dbPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckDatabaseSignature(dbPath, key); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("checking an index with a rewritten shard hash gave %v, want ErrBadSignature", err)
+	}
+}
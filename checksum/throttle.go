@@ -0,0 +1,142 @@
+package checksum
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// loadCheckInterval is how often the load average is read while hashing,
+// and loadPauseInterval how long hashing pauses before reading it again.
+const (
+	loadCheckInterval = 5 * time.Second
+	loadPauseInterval = 15 * time.Second
+)
+
+// errLoadUnsupported is returned by loadAverage where it is not available.
+var errLoadUnsupported = errors.New("pausing on system load is not supported on this platform")
+
+// rateLimiter spaces out events to a steady rate shared by all workers.
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate float64 // units per second
+	next time.Time
+	// now returns the current time, time.Now unless a test sets it.
+	now func() time.Time
+}
+
+// wait blocks until n units may be used, or until ctx is done.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	return sleep(ctx, l.reserve(n))
+}
+
+// reserve books n units and returns how long to wait before using them.
+func (l *rateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.now != nil {
+		now = l.now()
+	}
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	return delay
+}
+
+// throttle paces a scan to the rate limits of its options and pauses it
+// while the system load is too high.
+type throttle struct {
+	bytes   *rateLimiter
+	files   *rateLimiter
+	maxLoad float64
+
+	mu            sync.Mutex
+	load          float64
+	loadCheckedAt time.Time
+}
+
+// newThrottle returns the throttle for opts, or nil when no limit is set.
+func newThrottle(opts Options) *throttle {
+	if opts.MaxBytesPerSec <= 0 && opts.MaxFilesPerSec <= 0 && opts.MaxLoad <= 0 {
+		return nil
+	}
+	t := &throttle{maxLoad: opts.MaxLoad}
+	if opts.MaxBytesPerSec > 0 {
+		t.bytes = &rateLimiter{rate: float64(opts.MaxBytesPerSec)}
+	}
+	if opts.MaxFilesPerSec > 0 {
+		t.files = &rateLimiter{rate: opts.MaxFilesPerSec}
+	}
+	return t
+}
+
+type throttleKey struct{}
+
+// withThrottle returns a context whose reads through contextReader are
+// paced by t.
+func withThrottle(ctx context.Context, t *throttle) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, throttleKey{}, t)
+}
+
+func throttleFrom(ctx context.Context) *throttle {
+	t, _ := ctx.Value(throttleKey{}).(*throttle)
+	return t
+}
+
+// waitBytes accounts for n bytes read.
+func (t *throttle) waitBytes(ctx context.Context, n int) error {
+	if t.bytes == nil || n <= 0 {
+		return nil
+	}
+	return t.bytes.wait(ctx, n)
+}
+
+// waitFile blocks before a file is hashed until the file rate allows it and
+// the load average is at or below the limit.
+func (t *throttle) waitFile(ctx context.Context) error {
+	if t.files != nil {
+		if err := t.files.wait(ctx, 1); err != nil {
+			return err
+		}
+	}
+	for t.maxLoad > 0 && t.currentLoad() > t.maxLoad {
+		if err := sleep(ctx, loadPauseInterval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// currentLoad returns the 1-minute load average, read at most once per
+// loadCheckInterval. It returns 0 when the load cannot be read.
+func (t *throttle) currentLoad() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.loadCheckedAt) >= loadCheckInterval {
+		t.load, _ = loadAverage()
+		t.loadCheckedAt = time.Now()
+	}
+	return t.load
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,111 @@
+package checksum
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := &rateLimiter{rate: 100, now: clock.Now}
+
+	steps := []struct {
+		advance time.Duration
+		n       int
+		want    time.Duration
+	}{
+		{0, 1, 0},
+		{0, 1, 10 * time.Millisecond},
+		{0, 50, 20 * time.Millisecond},
+		{100 * time.Millisecond, 1, 420 * time.Millisecond},
+		{420 * time.Millisecond, 1, 10 * time.Millisecond},
+		// Idle time is not saved up for a burst
+		{10 * time.Second, 1, 0},
+		{0, 1, 10 * time.Millisecond},
+	}
+	for i, step := range steps {
+		clock.advance(step.advance)
+		if got := l.reserve(step.n); got != step.want {
+			t.Errorf("step %d: reserve(%d) = %v, want %v", i, step.n, got, step.want)
+		}
+	}
+}
+
+func TestRateLimiterShared(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := &rateLimiter{rate: 10, now: clock.Now}
+
+	// Workers reserving at the same instant are spaced out evenly
+	var mu sync.Mutex
+	var delays []time.Duration
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			delay := l.reserve(1)
+			mu.Lock()
+			delays = append(delays, delay)
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+	for i, delay := range delays {
+		if want := time.Duration(i) * 100 * time.Millisecond; delay != want {
+			t.Errorf("worker %d waits %v, want %v", i, delay, want)
+		}
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := &rateLimiter{rate: 1, now: clock.Now}
+	l.reserve(3600)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("wait on a cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestNewThrottle(t *testing.T) {
+	if newThrottle(Options{}) != nil {
+		t.Error("a throttle was created without limits")
+	}
+	ctx := context.Background()
+	if withThrottle(ctx, nil) != ctx {
+		t.Error("withThrottle changed the context without a throttle")
+	}
+	limits := newThrottle(Options{MaxBytesPerSec: 1024})
+	if limits == nil || limits.bytes == nil || limits.files != nil {
+		t.Fatalf("newThrottle with a byte limit = %+v, want a byte limiter only", limits)
+	}
+	if throttleFrom(withThrottle(ctx, limits)) != limits {
+		t.Error("the context does not carry the throttle")
+	}
+	// Without a file limit or a load limit, files do not wait
+	if err := limits.waitFile(ctx); err != nil {
+		t.Error(err)
+	}
+}
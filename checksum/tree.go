@@ -0,0 +1,208 @@
+package checksum
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Tree is a Merkle tree over the directories of a database. Every directory
+// digest covers the names and content hashes of the files below it, so two
+// trees with the same root hold the same content at the same paths, and a
+// differing root can be narrowed down directory by directory. Timestamps
+// and metadata are not part of the digests.
+type Tree struct {
+	Algorithm string `json:"Algorithm"`
+	Root      string `json:"Root"`
+	// Directories maps every directory, "" being the root, to its node.
+	Directories map[string]TreeNode `json:"Directories"`
+}
+
+// TreeNode is one directory of a Tree. FilesDigest covers the files
+// directly in the directory and Digest also the digests of its
+// subdirectories. Files counts every file below the directory.
+type TreeNode struct {
+	Digest      string `json:"Digest"`
+	FilesDigest string `json:"FilesDigest"`
+	Files       int    `json:"Files"`
+}
+
+// TreePath returns the path of the tree stored with a database.
+func TreePath(checksumFilePath string) string {
+	return checksumFilePath + ".tree"
+}
+
+// BuildTree computes the Merkle tree of the database. Archive members are
+// placed below their archive as if it were a directory.
+func BuildTree(checksumDB Database) *Tree {
+	algorithm := DetectAlgorithm(checksumDB)
+	if algorithm == "" {
+		algorithm = "md5"
+	}
+
+	// Group the files and subdirectories of every directory
+	files := map[string]map[string]string{"": {}}
+	children := map[string]map[string]bool{"": {}}
+	for relPath, hash := range checksumDB.PathIndex() {
+		slashPath := filepath.ToSlash(relPath)
+		dir, name := path.Split(slashPath)
+		dir = strings.TrimSuffix(dir, "/")
+		if files[dir] == nil {
+			files[dir] = make(map[string]string)
+		}
+		files[dir][name] = hash
+		for dir != "" {
+			parent, base := path.Split(dir)
+			parent = strings.TrimSuffix(parent, "/")
+			if children[parent] == nil {
+				children[parent] = make(map[string]bool)
+			}
+			if children[parent][base] {
+				break
+			}
+			children[parent][base] = true
+			dir = parent
+		}
+	}
+
+	tree := &Tree{Algorithm: algorithm, Directories: make(map[string]TreeNode)}
+	var digest func(dir string) TreeNode
+	digest = func(dir string) TreeNode {
+		node := TreeNode{}
+		h := NewHash(algorithm)
+		for _, name := range sortedKeys(files[dir]) {
+			fmt.Fprintf(h, "%s\x00%s\n", name, files[dir][name])
+			node.Files++
+		}
+		node.FilesDigest = fmt.Sprintf("%x", h.Sum(nil))
+
+		h = NewHash(algorithm)
+		fmt.Fprintf(h, "files\x00%s\n", node.FilesDigest)
+		for _, name := range sortedKeys(children[dir]) {
+			child := digest(joinTreePath(dir, name))
+			fmt.Fprintf(h, "%s/\x00%s\n", name, child.Digest)
+			node.Files += child.Files
+		}
+		node.Digest = fmt.Sprintf("%x", h.Sum(nil))
+		tree.Directories[dir] = node
+		return node
+	}
+	tree.Root = digest("").Digest
+	return tree
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinTreePath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// LoadTree reads a tree stored with a database.
+func LoadTree(treePath string) (*Tree, error) {
+	data, err := os.ReadFile(treePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read tree file '%s': %w", treePath, err)
+	}
+	var tree Tree
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("could not parse tree file '%s': %w", treePath, err)
+	}
+	return &tree, nil
+}
+
+// Save writes the tree as JSON.
+func (t *Tree) Save(treePath string) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(treePath, data, 0644); err != nil {
+		return fmt.Errorf("could not write tree file '%s': %w", treePath, err)
+	}
+	return nil
+}
+
+// Subdirectories maps every directory to its direct subdirectories.
+func (t *Tree) Subdirectories() map[string][]string {
+	subdirs := make(map[string][]string)
+	for d := range t.Directories {
+		if d != "" {
+			subdirs[parentDir(d)] = append(subdirs[parentDir(d)], d)
+		}
+	}
+	for _, list := range subdirs {
+		sort.Strings(list)
+	}
+	return subdirs
+}
+
+func parentDir(dir string) string {
+	if i := strings.LastIndex(dir, "/"); i >= 0 {
+		return dir[:i]
+	}
+	return ""
+}
+
+// TreeDifference is a directory whose digest differs between two trees.
+// OnlyIn is "A" or "B" when the directory exists in one tree only;
+// FilesDiffer is set when the files directly in it differ.
+type TreeDifference struct {
+	Path        string `json:"Path"`
+	OnlyIn      string `json:"OnlyIn,omitempty"`
+	FilesDiffer bool   `json:"FilesDiffer,omitempty"`
+	Depth       int    `json:"Depth"`
+}
+
+// DiffTrees walks both trees from the root and returns the differing
+// directories in depth-first order, descending only into directories that
+// differ. Directories that exist in one tree only are not descended into.
+func DiffTrees(a, b *Tree) ([]TreeDifference, error) {
+	if a.Algorithm != b.Algorithm {
+		return nil, fmt.Errorf("the trees use different algorithms (%s and %s)", a.Algorithm, b.Algorithm)
+	}
+	subdirsA, subdirsB := a.Subdirectories(), b.Subdirectories()
+	var diffs []TreeDifference
+	var walk func(dir string, depth int)
+	walk = func(dir string, depth int) {
+		nodeA, nodeB := a.Directories[dir], b.Directories[dir]
+		if nodeA.Digest == nodeB.Digest {
+			return
+		}
+		diffs = append(diffs, TreeDifference{Path: dir, FilesDiffer: nodeA.FilesDigest != nodeB.FilesDigest, Depth: depth})
+		subdirs := make(map[string]bool)
+		for _, d := range subdirsA[dir] {
+			subdirs[d] = true
+		}
+		for _, d := range subdirsB[dir] {
+			subdirs[d] = true
+		}
+		for _, d := range sortedKeys(subdirs) {
+			_, inA := a.Directories[d]
+			_, inB := b.Directories[d]
+			switch {
+			case !inB:
+				diffs = append(diffs, TreeDifference{Path: d, OnlyIn: "A", Depth: depth + 1})
+			case !inA:
+				diffs = append(diffs, TreeDifference{Path: d, OnlyIn: "B", Depth: depth + 1})
+			default:
+				walk(d, depth+1)
+			}
+		}
+	}
+	walk("", 0)
+	return diffs, nil
+}
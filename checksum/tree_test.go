@@ -0,0 +1,104 @@
+package checksum
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// treeFiles is the database behind the tree tests, by slash-separated path.
+var treeFiles = map[string]string{
+	"readme.txt":          "0cc175b9c0f1b6a831c399e269772661",
+	"docs/a.md":           "92eb5ffee6ae2fec3ad71c777531578f",
+	"docs/api/b.md":       "4a8a08f09d37b73795649038408b5f33",
+	"src/main.go":         "8277e0910d750195b448797616e091ad",
+	"src/bundle.zip!/x.c": "e1671797c52e15f763380b45e841ec32",
+}
+
+func treeDatabase(files map[string]string) Database {
+	osFiles := make(map[string]string, len(files))
+	for path, hash := range files {
+		osFiles[filepath.FromSlash(path)] = hash
+	}
+	return NewDatabase(osFiles)
+}
+
+func TestTreeRootIsStable(t *testing.T) {
+	tree := BuildTree(treeDatabase(treeFiles))
+	// The digests are compared between sites and versions, so their
+	// encoding must not change
+	const root = "aae54d2d36a182871b38607c656764b1"
+	if tree.Root != root {
+		t.Errorf("root = %s, want %s", tree.Root, root)
+	}
+
+	// Timestamps do not count
+	checksumDB := treeDatabase(treeFiles)
+	for hash, infoData := range checksumDB {
+		infoData.FirstCreated, infoData.LastContentUpdate = "2001-01-01T00:00:00Z", "2002-01-01T00:00:00Z"
+		for i := range infoData.RelativePaths {
+			infoData.RelativePaths[i].LastSeen = "2003-01-01T00:00:00Z"
+		}
+		checksumDB[hash] = infoData
+	}
+	if again := BuildTree(checksumDB); !reflect.DeepEqual(again, tree) {
+		t.Error("the tree changed with the timestamps of the database")
+	}
+
+	wantDirs := []string{"", "docs", "docs/api", "src", "src/bundle.zip!"}
+	if got := sortedKeys(tree.Directories); !reflect.DeepEqual(got, wantDirs) {
+		t.Errorf("directories = %q, want %q", got, wantDirs)
+	}
+	if files := tree.Directories[""].Files; files != len(treeFiles) {
+		t.Errorf("the root counts %d files, want %d", files, len(treeFiles))
+	}
+}
+
+func TestTreeSingleFileEdit(t *testing.T) {
+	before := BuildTree(treeDatabase(treeFiles))
+	edited := make(map[string]string)
+	for path, hash := range treeFiles {
+		edited[path] = hash
+	}
+	edited["docs/api/b.md"] = "d41d8cd98f00b204e9800998ecf8427e"
+	after := BuildTree(treeDatabase(edited))
+
+	// The directory of the file and its parents change, nothing else
+	for dir, node := range before.Directories {
+		changed := node.Digest != after.Directories[dir].Digest
+		if want := dir == "" || dir == "docs" || dir == "docs/api"; changed != want {
+			t.Errorf("digest of '%s' changed: %v, want %v", dir, changed, want)
+		}
+	}
+	if before.Directories["docs"].FilesDigest != after.Directories["docs"].FilesDigest {
+		t.Error("the files digest of 'docs' changed, but none of its own files did")
+	}
+
+	diffs, err := DiffTrees(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TreeDifference{
+		{Path: "", Depth: 0},
+		{Path: "docs", Depth: 1},
+		{Path: "docs/api", FilesDiffer: true, Depth: 2},
+	}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Errorf("DiffTrees = %+v, want %+v", diffs, want)
+	}
+}
+
+func TestTreeSaveAndLoad(t *testing.T) {
+	tree := BuildTree(treeDatabase(treeFiles))
+	path := filepath.Join(t.TempDir(), "checksums.json.gz.tree")
+	if err := tree.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadTree(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, tree) {
+		t.Error("the loaded tree differs from the saved one")
+	}
+}
@@ -0,0 +1,476 @@
+package checksum
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Result is one path, or one group of paths for RENAMED, in a verify
+// category.
+type Result struct {
+	Path                string   `json:"Path,omitempty"`
+	ContentHash         string   `json:"ContentHash,omitempty"`
+	OriginalContentHash string   `json:"OriginalContentHash,omitempty"`
+	KnownOldPaths       []string `json:"KnownOldPaths,omitempty"`
+	OldPaths            []string `json:"OldPaths,omitempty"`
+	NewPaths            []string `json:"NewPaths,omitempty"`
+	// ChangedRanges and ChangedPercent locate a modification when the
+	// original content was stored with chunk hashes.
+	ChangedRanges  []ByteRange `json:"ChangedRanges,omitempty"`
+	ChangedPercent float64     `json:"ChangedPercent,omitempty"`
+	// ChangedAttributes lists the metadata differences of METADATA_CHANGED
+	// results.
+	ChangedAttributes []string `json:"ChangedAttributes,omitempty"`
+}
+
+// VerifyReport holds the outcome of a single verification run.
+type VerifyReport struct {
+	Database        string              `json:"Database"`
+	StartedAt       string              `json:"StartedAt"`
+	FinishedAt      string              `json:"FinishedAt"`
+	DurationSeconds float64             `json:"DurationSeconds"`
+	FilesChecked    int                 `json:"FilesChecked"`
+	BytesHashed     int64               `json:"BytesHashed"`
+	UniqueChecksums int                 `json:"UniqueChecksums"`
+	Results         map[string][]Result `json:"Results"`
+	Skipped         []SkippedFile       `json:"Skipped,omitempty"`
+	// Errors lists the files that exist but could not be read. They are
+	// not reported as DELETED.
+	Errors []FileError `json:"Errors,omitempty"`
+	// Warnings lists the archives that could not be expanded and were
+	// checked as single files.
+	Warnings []FileError `json:"Warnings,omitempty"`
+	// Incomplete is set when the run was interrupted; FilesPending files
+	// were not checked and are left out of the results.
+	Incomplete   bool `json:"Incomplete,omitempty"`
+	FilesPending int  `json:"FilesPending,omitempty"`
+	// SignedBy is the ID of the key that signed the database, when its
+	// signature was checked. SignatureError is set when the check failed
+	// and the options only asked for a warning.
+	SignedBy       string `json:"SignedBy,omitempty"`
+	SignatureError string `json:"SignatureError,omitempty"`
+	// Sample is set for sampled verifications, which only check part of
+	// the database and report no NEW files.
+	Sample *SampleCoverage `json:"Sample,omitempty"`
+	// Shard is set when a single shard of a sharded database was verified.
+	Shard string `json:"Shard,omitempty"`
+	// Scope holds the paths the verify was limited to, if any.
+	Scope []string `json:"Scope,omitempty"`
+	// Selection is the file selection the files were filtered by, if any.
+	Selection *Selection `json:"Selection,omitempty"`
+	// OKNotListed counts the OK files of a verification with a memory
+	// limit, which are not listed in Results to save memory.
+	OKNotListed int `json:"OKNotListed,omitempty"`
+}
+
+// Partial reports whether the run checked only part of the tree: a sample,
+// a single shard or some paths, or was interrupted.
+func (r *VerifyReport) Partial() bool {
+	return r.Sample != nil || r.Shard != "" || len(r.Scope) > 0 || r.Incomplete
+}
+
+// Categories lists the result categories in report order.
+var Categories = []string{"OK", "MODIFIED", "METADATA_CHANGED", "RENAMED", "MOVED", "NEW", "DELETED"}
+
+// Summary returns the number of results in each category.
+func (r *VerifyReport) Summary() map[string]int {
+	summary := make(map[string]int, len(Categories))
+	for _, category := range Categories {
+		summary[category] = len(r.Results[category])
+	}
+	summary["OK"] += r.OKNotListed
+	return summary
+}
+
+// Discrepancies returns the number of results that are not OK, counting
+// unreadable files and a bad database signature as well.
+func (r *VerifyReport) Discrepancies() int {
+	total := CountDiscrepancies(r.Results) + len(r.Errors)
+	if r.SignatureError != "" {
+		total++
+	}
+	return total
+}
+
+// CountDiscrepancies returns the number of results outside the OK category.
+func CountDiscrepancies(results map[string][]Result) int {
+	total := 0
+	for _, category := range Categories {
+		if category != "OK" {
+			total += len(results[category])
+		}
+	}
+	return total
+}
+
+// Verifier checks the files under the root of its options against the
+// checksum database, or only a sample of the database files when Sample is
+// set. With a MemoryLimit in the options, the database is streamed instead
+// of loaded.
+type Verifier struct {
+	Options  Options
+	Progress ProgressFunc
+	Sample   *Sample
+}
+
+// NewVerifier returns a Verifier for opts, which are validated first.
+func NewVerifier(opts Options) (*Verifier, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return &Verifier{Options: opts}, nil
+}
+
+// Verify hashes every selected file and classifies it against the
+// database. With a Sample, only the sampled database files are hashed and
+// classified, and the sample state is advanced unless the run is cut
+// short. When ctx is cancelled, the partial report of the files checked so
+// far is returned, marked incomplete, together with ctx.Err().
+func (v *Verifier) Verify(ctx context.Context) (*VerifyReport, error) {
+	baseLocationPath := v.Options.RootPath()
+	algorithm := AlgorithmName(v.Options.Algorithm)
+	startTime := time.Now()
+
+	// Check the signature before trusting the database
+	checksumFilePath := v.Options.DatabasePath()
+	var signedBy, signatureError string
+	if v.Options.Key != nil {
+		signature, err := CheckDatabaseSignature(checksumFilePath, v.Options.Key)
+		if err != nil && !v.Options.SignatureWarn {
+			return nil, fmt.Errorf("refusing to trust the database: %w", err)
+		}
+		if err != nil {
+			signatureError = err.Error()
+		} else {
+			signedBy = signature.KeyID
+		}
+	}
+
+	// A single shard is compared with the files it covers only
+	opts, databaseFile := v.Options, checksumFilePath
+	if v.Options.Shard != "" {
+		index, err := ReadShardIndex(checksumFilePath)
+		if err != nil {
+			return nil, err
+		}
+		i, err := index.selectShard(v.Options.Shard)
+		if err != nil {
+			return nil, err
+		}
+		if databaseFile, err = checkShard(checksumFilePath, index, i); err != nil {
+			return nil, err
+		}
+		index.scopeToShard(&opts, i)
+	}
+
+	// Select the files the database was scanned with, unless told otherwise
+	if opts.Selection.IsZero() {
+		selection, err := RecordedSelection(v.Options)
+		if err != nil {
+			return nil, err
+		}
+		opts.Selection = selection
+	}
+	var selection *Selection
+	if !opts.Selection.IsZero() {
+		selection = &opts.Selection
+	}
+
+	if v.Options.MemoryLimit > 0 {
+		if v.Sample != nil {
+			return nil, fmt.Errorf("a memory limit cannot be combined with a sampled verification")
+		}
+		report, err := v.verifyBounded(ctx, opts, databaseFile, algorithm)
+		if err != nil {
+			return nil, err
+		}
+		finishTime := time.Now()
+		report.Database, report.Shard, report.Scope = checksumFilePath, v.Options.Shard, v.Options.Paths
+		report.Selection = selection
+		report.StartedAt = startTime.UTC().Format(time.RFC3339)
+		report.FinishedAt = finishTime.UTC().Format(time.RFC3339)
+		report.DurationSeconds = finishTime.Sub(startTime).Seconds()
+		report.Incomplete = ctx.Err() != nil
+		report.SignedBy, report.SignatureError = signedBy, signatureError
+		return report, ctx.Err()
+	}
+
+	// Load checksum DB, combining the sidecars of every directory in
+	// sidecar mode
+	var checksumDB Database
+	var err error
+	if v.Options.Sidecars {
+		checksumFilePath = filepath.Join(baseLocationPath, "**", SidecarFileName)
+		checksumDB, err = LoadSidecars(ctx, v.Options)
+	} else {
+		checksumDB, err = LoadDatabase(databaseFile, v.Options.DatabaseKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(checksumDB) == 0 {
+		return nil, fmt.Errorf("no valid checksums found in database")
+	}
+	if existing := DetectAlgorithm(checksumDB); existing != algorithm {
+		return nil, fmt.Errorf("the database uses %s but %s was requested", existing, algorithm)
+	}
+	if len(opts.Paths) > 0 {
+		checksumDB = scopeDatabase(checksumDB, opts)
+	}
+
+	uniqueChecksums := len(checksumDB)
+	var sample *sampleSelection
+	if v.Sample != nil {
+		if sample, err = v.Sample.selectSample(baseLocationPath, checksumDB); err != nil {
+			return nil, err
+		}
+		checksumDB = restrictDatabase(checksumDB, sample.paths)
+	}
+
+	// Index files on disk
+	scanner := &Scanner{Options: opts, Progress: v.Progress}
+	var bytesHashed int64
+	diskFiles := make(map[string]string)
+	var fileErrors, warnings []FileError
+	var skippedFiles []SkippedFile
+	var pending []string
+	hashed := func(f hashedFile) {
+		if f.err != nil {
+			fileErrors = append(fileErrors, FileError{Path: f.relPath, Error: f.err.Error()})
+			return
+		}
+		if f.warning != "" {
+			warnings = append(warnings, FileError{Path: f.relPath, Error: f.warning})
+		}
+		for entryPath, entry := range f.entries {
+			diskFiles[entryPath] = entry.hash
+		}
+		bytesHashed += f.size
+	}
+	if sample != nil {
+		pending = scanner.hashList(ctx, existingFiles(baseLocationPath, sample.paths), hashed)
+	} else {
+		skippedFiles, pending = scanner.hashFiles(ctx, hashed)
+	}
+
+	// Files that were not read are unknown rather than deleted
+	unchecked := make(map[string]bool, len(pending)+len(fileErrors))
+	for _, p := range pending {
+		unchecked[p] = true
+	}
+	for _, e := range fileErrors {
+		unchecked[e.Path] = true
+	}
+
+	results := ClassifyFiles(checksumDB, diskFiles)
+	var deleted []Result
+	for _, r := range results["DELETED"] {
+		archivePath, _, _ := splitArchivePath(r.Path)
+		if !unchecked[r.Path] && !unchecked[archivePath] {
+			deleted = append(deleted, r)
+		}
+	}
+	if deleted == nil {
+		deleted = []Result{}
+	}
+	results["DELETED"] = deleted
+	dropDeselected(results, baseLocationPath, opts.Selection, startTime)
+	localiseModifications(ctx, newThrottle(opts), baseLocationPath, algorithm, checksumDB, results["MODIFIED"])
+	checkMetadata(baseLocationPath, checksumDB, results)
+
+	var coverage *SampleCoverage
+	if sample != nil {
+		coverage = &sample.coverage
+		damaged := len(results["MODIFIED"]) + len(results["DELETED"]) + len(fileErrors)
+		coverage.MaxDamagedPercent = damageBound(min(damaged, coverage.FilesSampled), coverage.FilesSampled)
+		if ctx.Err() == nil {
+			v.Sample.State = sample.next
+		}
+	}
+	finishTime := time.Now()
+	return &VerifyReport{
+		Database:        checksumFilePath,
+		Shard:           v.Options.Shard,
+		Scope:           v.Options.Paths,
+		Selection:       selection,
+		StartedAt:       startTime.UTC().Format(time.RFC3339),
+		FinishedAt:      finishTime.UTC().Format(time.RFC3339),
+		DurationSeconds: finishTime.Sub(startTime).Seconds(),
+		FilesChecked:    len(diskFiles),
+		BytesHashed:     bytesHashed,
+		UniqueChecksums: uniqueChecksums,
+		Results:         results,
+		Skipped:         skippedFiles,
+		Errors:          fileErrors,
+		Warnings:        warnings,
+		Incomplete:      ctx.Err() != nil,
+		FilesPending:    len(pending),
+		SignedBy:        signedBy,
+		SignatureError:  signatureError,
+		Sample:          coverage,
+	}, ctx.Err()
+}
+
+// ClassifyDatabases runs the verify classification between two databases,
+// treating every path stored in newDB as if it were a file on disk.
+func ClassifyDatabases(oldDB, newDB Database) map[string][]Result {
+	return ClassifyFiles(oldDB, newDB.PathIndex())
+}
+
+// ClassifyFiles compares a map of relative path to content hash against the
+// checksum database and sorts every path into a result category.
+func ClassifyFiles(checksumDB Database, diskFiles map[string]string) map[string][]Result {
+	results := map[string][]Result{
+		"OK":               {},
+		"MODIFIED":         {},
+		"METADATA_CHANGED": {},
+		"MOVED":            {},
+		"NEW":              {},
+		"DELETED":          {},
+		"RENAMED":          {},
+	}
+
+	processedDBPaths := make(map[string]bool)
+	processedDiskPaths := make(map[string]bool)
+
+	// Compare disk to DB
+	for relPath, diskHash := range diskFiles {
+		if infoData, exists := checksumDB[diskHash]; exists {
+			found := false
+			for _, p := range infoData.RelativePaths {
+				if p.Path == relPath {
+					results["OK"] = append(results["OK"], Result{Path: relPath, ContentHash: diskHash})
+					processedDBPaths[diskHash+":"+relPath] = true
+					processedDiskPaths[relPath] = true
+					found = true
+					break
+				}
+			}
+			if !found {
+				results["MOVED"] = append(results["MOVED"], Result{Path: relPath, ContentHash: diskHash, KnownOldPaths: getPaths(infoData.RelativePaths)})
+				processedDiskPaths[relPath] = true
+			}
+		}
+	}
+
+	// Check DB for missing or modified
+	for hash, infoData := range checksumDB {
+		for _, dbPath := range infoData.RelativePaths {
+			key := hash + ":" + dbPath.Path
+			if !processedDBPaths[key] {
+				if diskHash, exists := diskFiles[dbPath.Path]; exists {
+					results["MODIFIED"] = append(results["MODIFIED"], Result{Path: dbPath.Path, OriginalContentHash: hash, ContentHash: diskHash})
+					processedDiskPaths[dbPath.Path] = true
+				} else {
+					results["DELETED"] = append(results["DELETED"], Result{Path: dbPath.Path, OriginalContentHash: hash})
+				}
+			}
+		}
+	}
+
+	// Find truly new files (not processed as OK, MOVED, or MODIFIED)
+	for relPath, diskHash := range diskFiles {
+		if !processedDiskPaths[relPath] {
+			results["NEW"] = append(results["NEW"], Result{Path: relPath, ContentHash: diskHash})
+		}
+	}
+
+	// Handle RENAMED
+	hashesWithMoved := make(map[string][]Result)
+	for _, r := range results["MOVED"] {
+		hashesWithMoved[r.ContentHash] = append(hashesWithMoved[r.ContentHash], r)
+	}
+	hashesWithDeleted := make(map[string][]Result)
+	for _, r := range results["DELETED"] {
+		hashesWithDeleted[r.OriginalContentHash] = append(hashesWithDeleted[r.OriginalContentHash], r)
+	}
+
+	for hash := range hashesWithMoved {
+		if deleted, exists := hashesWithDeleted[hash]; exists {
+			renamed := Result{
+				ContentHash: hash,
+				OldPaths:    getPathsFromResults(deleted),
+				NewPaths:    getPathsFromResults(hashesWithMoved[hash]),
+			}
+			results["RENAMED"] = append(results["RENAMED"], renamed)
+			// Remove from MOVED and DELETED
+			results["MOVED"] = removeResults(results["MOVED"], hashesWithMoved[hash])
+			results["DELETED"] = removeResults(results["DELETED"], deleted)
+		}
+	}
+
+	// Keep the output stable between runs
+	for category, list := range results {
+		if list == nil {
+			results[category] = []Result{}
+		}
+		sortResults(results[category])
+	}
+	return results
+}
+
+func getPaths(entries []PathEntry) []string {
+	var paths []string
+	for _, e := range entries {
+		paths = append(paths, e.Path)
+	}
+	return paths
+}
+
+func getPathsFromResults(results []Result) []string {
+	var paths []string
+	for _, r := range results {
+		if r.Path != "" {
+			paths = append(paths, r.Path)
+		}
+	}
+	return paths
+}
+
+// sortResults orders results by path, falling back to the content hash for
+// entries without a single path (RENAMED).
+func sortResults(results []Result) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Path != results[j].Path {
+			return results[i].Path < results[j].Path
+		}
+		return results[i].ContentHash < results[j].ContentHash
+	})
+}
+
+func removeResults(all []Result, toRemove []Result) []Result {
+	var remaining []Result
+	for _, r := range all {
+		found := false
+		for _, rem := range toRemove {
+			if r.Path == rem.Path && r.ContentHash == rem.ContentHash {
+				found = true
+				break
+			}
+		}
+		if !found {
+			remaining = append(remaining, r)
+		}
+	}
+	return remaining
+}
+
+// dropDeselected removes the DELETED paths that still exist but are not
+// selected, so that a file that grew past a size limit or aged out of the
+// selection is not reported as deleted.
+func dropDeselected(results map[string][]Result, baseLocationPath string, selection Selection, now time.Time) {
+	if selection.IsZero() {
+		return
+	}
+	deleted := []Result{}
+	for _, r := range results["DELETED"] {
+		if !selection.deselects(baseLocationPath, r.Path, now) {
+			deleted = append(deleted, r)
+		}
+	}
+	results["DELETED"] = deleted
+}
@@ -0,0 +1,73 @@
+package checksum
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClassifyFiles(t *testing.T) {
+	checksumDB := NewDatabase(map[string]string{
+		"same.txt":    "h-same",
+		"changed.txt": "h-old",
+		"gone.txt":    "h-gone",
+		"old/name":    "h-renamed",
+		"copy/a":      "h-moved",
+	})
+	diskFiles := map[string]string{
+		"same.txt":    "h-same",
+		"changed.txt": "h-new",
+		"new/name":    "h-renamed",
+		"copy/a":      "h-moved",
+		"copy/b":      "h-moved",
+		"fresh.txt":   "h-fresh",
+	}
+
+	results := ClassifyFiles(checksumDB, diskFiles)
+
+	paths := func(category string) []string {
+		var out []string
+		for _, r := range results[category] {
+			out = append(out, r.Path)
+		}
+		return out
+	}
+	tests := []struct {
+		category string
+		want     []string
+	}{
+		{"OK", []string{"copy/a", "same.txt"}},
+		{"MODIFIED", []string{"changed.txt"}},
+		{"MOVED", []string{"copy/b"}},
+		{"NEW", []string{"fresh.txt"}},
+		{"DELETED", []string{"gone.txt"}},
+		{"METADATA_CHANGED", nil},
+	}
+	for _, tt := range tests {
+		if got := paths(tt.category); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.category, got, tt.want)
+		}
+	}
+
+	if got := results["MODIFIED"][0]; got.OriginalContentHash != "h-old" || got.ContentHash != "h-new" {
+		t.Errorf("MODIFIED hashes = %s -> %s, want h-old -> h-new", got.OriginalContentHash, got.ContentHash)
+	}
+	if got := results["MOVED"][0].KnownOldPaths; !reflect.DeepEqual(got, []string{"copy/a"}) {
+		t.Errorf("MOVED known old paths = %v, want [copy/a]", got)
+	}
+	renamed := results["RENAMED"]
+	if len(renamed) != 1 {
+		t.Fatalf("RENAMED = %v, want one result", renamed)
+	}
+	if !reflect.DeepEqual(renamed[0].OldPaths, []string{"old/name"}) || !reflect.DeepEqual(renamed[0].NewPaths, []string{"new/name"}) {
+		t.Errorf("RENAMED = %v -> %v, want [old/name] -> [new/name]", renamed[0].OldPaths, renamed[0].NewPaths)
+	}
+}
+
+func TestClassifyFilesEmpty(t *testing.T) {
+	results := ClassifyFiles(make(Database), map[string]string{})
+	for _, category := range Categories {
+		if list, ok := results[category]; !ok || list == nil || len(list) != 0 {
+			t.Errorf("%s = %#v, want an empty list", category, list)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package checksum
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"syscall"
+)
+
+// readXattrs returns the MD5 of every extended attribute of the file,
+// including POSIX ACLs (system.posix_acl_*).
+func readXattrs(filePath string) (map[string]string, error) {
+	size, err := syscall.Listxattr(filePath, nil)
+	if err == syscall.ENOTSUP {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	xattrs := make(map[string]string)
+	if size == 0 {
+		return xattrs, nil
+	}
+	list := make([]byte, size)
+	size, err = syscall.Listxattr(filePath, list)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range bytes.Split(list[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		valueSize, err := syscall.Getxattr(filePath, string(name), nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, valueSize)
+		if valueSize > 0 {
+			if valueSize, err = syscall.Getxattr(filePath, string(name), value); err != nil {
+				return nil, err
+			}
+		}
+		xattrs[string(name)] = fmt.Sprintf("%x", md5.Sum(value[:valueSize]))
+	}
+	return xattrs, nil
+}
@@ -0,0 +1,42 @@
+package checksum
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestReadXattrs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(path, "user.md5checker", []byte("first"), 0); err != nil {
+		t.Skipf("extended attributes are not supported here: %v", err)
+	}
+	before, err := readMetadata(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// MD5 of "first"
+	if got := before.Xattrs["user.md5checker"]; got != "8b04d5e3775d298e78455efc5ca404d5" {
+		t.Errorf("recorded xattr hash %s, want the MD5 of its value", got)
+	}
+
+	if err := syscall.Setxattr(path, "user.md5checker", []byte("second"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(path, "user.added", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	after, err := readMetadata(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"xattr user.added: added", "xattr user.md5checker: changed"}
+	if got := metadataChanges(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("metadataChanges = %q, want %q", got, want)
+	}
+}
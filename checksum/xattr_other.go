@@ -0,0 +1,8 @@
+//go:build !linux
+
+package checksum
+
+// readXattrs is not supported on this platform and records no attributes.
+func readXattrs(filePath string) (map[string]string, error) {
+	return map[string]string{}, nil
+}
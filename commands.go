@@ -0,0 +1,469 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"md5checker/checksum"
+)
+
+// runCommand executes a non-interactive command given on the command line
+// and returns the process exit code.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "add":
+		return runGenerate(args[1:], false)
+	case "regenerate":
+		return runGenerate(args[1:], true)
+	case "verify":
+		return runVerify(args[1:])
+	case "serve":
+		return runServe(args[1:])
+	case "history":
+		return runHistory(args[1:])
+	case "snapshot":
+		return runSnapshot(args[1:])
+	case "diff":
+		return runDiff(args[1:])
+	case "journal":
+		return runJournal(args[1:])
+	case "keygen":
+		return runKeygen(args[1:])
+	case "sign":
+		return runSign(args[1:])
+	case "verify-db":
+		return runVerifyDB(args[1:])
+	case "rekey":
+		return runRekey(args[1:])
+	case "convert":
+		return runConvert(args[1:])
+	case "shards":
+		return runShards(args[1:])
+	case "tree":
+		return runTree(args[1:])
+	case "diff-tree":
+		return runDiffTree(args[1:])
+	case "compare":
+		return runCompare(args[1:])
+	case "version", "-v", "--version":
+		fmt.Println(Version)
+		return 0
+	case "help", "-h", "--help":
+		printUsage()
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command '%s'.\n\n", args[0])
+		printUsage()
+		return 2
+	}
+}
+
+func printUsage() {
+	fmt.Println("Usage: md5checker [command] [options]")
+	fmt.Println()
+	fmt.Println("Run without a command to start the interactive menu.")
+	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Println("  add        Add new files to the database")
+	fmt.Println("  regenerate Regenerate all checksums")
+	fmt.Println("  verify     Verify file integrity (exit code 1 on discrepancies)")
+	fmt.Println("  serve      Start the local HTTP API server")
+	fmt.Println("  history    List past verification runs and compare them")
+	fmt.Println("  snapshot   Save, list and delete labelled database snapshots")
+	fmt.Println("  diff       Compare two databases or snapshots")
+	fmt.Println("  compare    Compare two directory trees by content")
+	fmt.Println("  journal    Show, verify and replay the change journal")
+	fmt.Println("  tree       Print the Merkle root hash of the database")
+	fmt.Println("  diff-tree  Compare two Merkle trees down to the differing directories")
+	fmt.Println("  keygen     Create an Ed25519 key pair or HMAC secret for signing")
+	fmt.Println("  sign       Sign the database")
+	fmt.Println("  verify-db  Check the database signature")
+	fmt.Println("  rekey      Re-encrypt the database with a new key, or decrypt it")
+	fmt.Println("  convert    Convert the database between the JSON and binary formats")
+	fmt.Println("  shards     List the shards of a sharded database")
+	fmt.Println("  version    Print the version and exit")
+	fmt.Println("  help       Show this help")
+	fmt.Println()
+	fmt.Println("add, regenerate and verify take optional paths or globs relative to the")
+	fmt.Println("root after the options, e.g. 'verify \"photos/2024/**\"', to scan only those.")
+	fmt.Println("Run 'md5checker <command> -h' for the options of a command.")
+}
+
+// scanOptions are the library options plus the settings that only the
+// command line tool uses.
+type scanOptions struct {
+	checksum.Options
+	// reportFormats are the verify report formats: text, json and csv.
+	reportFormats []string
+	// configPath and profile select a named profile from a config file.
+	configPath string
+	profile    string
+	// checkpoint saves the files hashed so far when add or regenerate is
+	// interrupted; resume continues from the checkpoint log of such a run.
+	checkpoint bool
+	resume     bool
+	// idle lowers the process to idle I/O and CPU priority.
+	idle bool
+	// sampleFiles, samplePercent and sampleWindow turn verify into a
+	// sampled spot-check; seed, if set, selects the sample order.
+	sampleFiles   int
+	samplePercent float64
+	sampleWindow  int
+	seed          uint64
+	// keyPath is the key file that signs the database on add and
+	// regenerate and checks its signature on verify.
+	keyPath string
+	// dbKeyFile and passphraseFile hold the key of an encrypted database.
+	dbKeyFile      string
+	passphraseFile string
+	// format is the format add and regenerate save the database in, by
+	// default that of the existing database.
+	format string
+	// shards is the shard layout add and regenerate save the database in:
+	// 'dir', a number of paths per shard or 'off', by default that of the
+	// existing database.
+	shards string
+	// allFiles drops the file selection recorded in the database on add
+	// and regenerate.
+	allFiles bool
+}
+
+// sampled reports whether verify checks a sample of the database only.
+func (o scanOptions) sampled() bool {
+	return o.sampleFiles > 0 || o.samplePercent > 0 || o.sampleWindow > 0
+}
+
+// samplePath returns the path of the rotation state of sampled verifies.
+func (o scanOptions) samplePath() string {
+	return filepath.Join(o.DatabaseDir(), sampleFileName)
+}
+
+// interruptContext returns a context that is cancelled on SIGINT or
+// SIGTERM. After the first signal the default handling is restored, so a
+// second Ctrl-C quits immediately.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			signal.Stop(signals)
+			fmt.Fprintln(os.Stderr, "\nInterrupted, finishing up (press Ctrl-C again to quit immediately)...")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// checkpointPath returns the path of the checkpoint log of add and
+// regenerate runs.
+func (o scanOptions) checkpointPath() string {
+	return filepath.Join(o.DatabaseDir(), checkpointFileName)
+}
+
+// databaseFormat returns the format the database is saved in: the one
+// requested, or else that of the existing database.
+func (o scanOptions) databaseFormat() string {
+	if o.format != "" {
+		return o.format
+	}
+	if sharded, _ := checksum.IsShardedDatabase(o.DatabasePath()); sharded {
+		if index, err := checksum.ReadShardIndex(o.DatabasePath()); err == nil {
+			return index.Format
+		}
+	}
+	if format, err := checksum.DetectFormat(o.DatabasePath()); err == nil {
+		return format
+	}
+	return checksum.FormatJSON
+}
+
+// historyPath returns the path of the verification history log.
+func (o scanOptions) historyPath() string {
+	return filepath.Join(o.DatabaseDir(), historyFileName)
+}
+
+// addLocationFlags registers the options that locate the scanned root and
+// its database, directly or through a config profile.
+func addLocationFlags(fs *flag.FlagSet) *scanOptions {
+	opts := &scanOptions{}
+	fs.StringVar(&opts.Root, "root", "", "directory to scan (default the working directory)")
+	fs.StringVar(&opts.Database, "database", "", "checksum database, relative to the root unless absolute (default "+checksum.DatabaseFileName+")")
+	fs.StringVar(&opts.configPath, "config", "", "config file holding the profiles (default ./"+configFileName+", then the user config directory)")
+	fs.StringVar(&opts.profile, "profile", "", "named profile from the config file; command line flags override it")
+	fs.StringVar(&opts.dbKeyFile, "db-key-file", "", "key file of an encrypted database (at least 32 random bytes)")
+	fs.StringVar(&opts.passphraseFile, "passphrase-file", "", "file holding the passphrase of an encrypted database (default $"+passphraseEnv+")")
+	return opts
+}
+
+// addScanFlags registers the options shared by every command that scans the
+// file system.
+func addScanFlags(fs *flag.FlagSet) *scanOptions {
+	opts := addLocationFlags(fs)
+	fs.StringVar(&opts.Algorithm, "algorithm", "md5", "hash algorithm: md5, sha1, sha256 or sha512")
+	fs.Var(listFlag{&opts.Include}, "include", "only scan files matching these glob patterns (repeatable or comma-separated)")
+	fs.Var(listFlag{&opts.Exclude}, "exclude", "skip files and directories matching these glob patterns (default "+strings.Join(checksum.DefaultExcludes, ",")+")")
+	fs.IntVar(&opts.Concurrency, "concurrency", 1, "number of files hashed in parallel")
+	fs.DurationVar(&opts.ReadTimeout, "read-timeout", 0, "give up on a file that takes longer than this to read, e.g. 30s (default no limit)")
+	fs.Var(listFlag{&opts.reportFormats}, "report-formats", "verify report formats: text, json and csv (default text)")
+	fs.BoolVar(&opts.Archives, "archives", false, "descend into zip, tar and gzip archives and check each member")
+	fs.BoolVar(&opts.Metadata, "metadata", false, "record mode, owner, size and mtime of every file")
+	fs.BoolVar(&opts.Xattrs, "xattrs", false, "also record extended attributes and ACLs (implies -metadata)")
+	fs.StringVar(&opts.Symlinks, "symlinks", checksum.SymlinksHash, "symlink policy: 'hash' the target file, 'record' the link target, 'follow' links to files and directories, or 'skip'")
+	fs.Func("min-size", "only scan files of at least this size, e.g. 10K, 1.5M or 2G (default the selection recorded in the database)", func(s string) error {
+		var err error
+		opts.Selection.MinSize, err = parseSize(s)
+		return err
+	})
+	fs.Func("max-size", "skip files larger than this size, e.g. 50G (default the selection recorded in the database)", func(s string) error {
+		var err error
+		opts.Selection.MaxSize, err = parseSize(s)
+		return err
+	})
+	fs.Func("newer-than", "only scan files modified within this long, e.g. 30d, 2w or 12h (default the selection recorded in the database)", func(s string) error {
+		var err error
+		opts.Selection.NewerThan, err = parseAge(s)
+		return err
+	})
+	fs.Func("older-than", "only scan files not modified for this long, e.g. 90d (default the selection recorded in the database)", func(s string) error {
+		var err error
+		opts.Selection.OlderThan, err = parseAge(s)
+		return err
+	})
+	fs.Var(listFlag{&opts.Selection.Extensions}, "ext", "only scan files with these extensions, e.g. pdf,docx (repeatable or comma-separated)")
+	fs.Var(listFlag{&opts.Selection.SkipExtensions}, "skip-ext", "skip files with these extensions (repeatable or comma-separated)")
+	fs.BoolVar(&opts.Selection.SkipHidden, "skip-hidden", false, "skip files and directories whose name starts with a dot, such as .git")
+	fs.StringVar(&opts.ChunkMode, "chunks", "", "store chunk hashes per file: 'fixed' or 'cdc' (content-defined)")
+	fs.Func("chunk-size", "chunk size in KiB, the average size for 'cdc' (default 1024)", func(s string) error {
+		kib, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || kib < 1 {
+			return fmt.Errorf("invalid chunk size '%s'", s)
+		}
+		opts.ChunkSize = kib * 1024
+		return nil
+	})
+	fs.Func("max-rate", "maximum read rate in MiB/s (default no limit)", func(s string) error {
+		mib, err := strconv.ParseFloat(s, 64)
+		if err != nil || mib <= 0 {
+			return fmt.Errorf("invalid rate '%s'", s)
+		}
+		opts.MaxBytesPerSec = int64(mib * 1024 * 1024)
+		return nil
+	})
+	fs.Float64Var(&opts.MaxFilesPerSec, "max-files", 0, "maximum number of files hashed per second (default no limit)")
+	fs.Float64Var(&opts.MaxLoad, "max-load", 0, "pause while the 1-minute load average is above this (Linux only)")
+	fs.BoolVar(&opts.idle, "idle", false, "run at idle I/O priority and lowest CPU priority (Linux only)")
+	fs.StringVar(&opts.keyPath, "key", "", "Ed25519 or HMAC key file: sign the database after add/regenerate, check its signature before verify")
+	fs.StringVar(&opts.format, "format", "", "database format for add/regenerate: 'json' or 'binary' (default that of the existing database, else json)")
+	fs.BoolVar(&opts.Sidecars, "sidecars", false, "keep a "+checksum.SidecarFileName+" manifest in every directory instead of one database file")
+	fs.BoolVar(&opts.SignatureWarn, "signature-warn", false, "flag a bad database signature in the verify report instead of refusing to verify")
+	return opts
+}
+
+// sizeUnits are the suffixes parseSize accepts, in powers of 1024.
+var sizeUnits = map[string]int64{"": 1, "B": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+
+// parseSize parses a file size in bytes, or with a K, M, G or T suffix,
+// optionally followed by B or iB.
+func parseSize(s string) (int64, error) {
+	unit := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "IB"), "B")
+	number := strings.TrimRight(unit, "KMGT")
+	multiplier, ok := sizeUnits[unit[len(number):]]
+	value, err := strconv.ParseFloat(number, 64)
+	if !ok || err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size '%s', expected e.g. 500K or 50G", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// parseAge parses a duration like time.ParseDuration, also accepting days
+// (d) and weeks (w).
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if number, ok := strings.CutSuffix(s, suffix); ok {
+			if n, err := strconv.ParseFloat(number, 64); err == nil && n > 0 {
+				return time.Duration(n * float64(unit)), nil
+			}
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid age '%s', expected e.g. 30d, 2w or 12h", s)
+	}
+	return d, nil
+}
+
+// validateScanOptions checks the parsed scan flags and fills in defaults.
+// The files the tool keeps next to the database are never scanned.
+func validateScanOptions(opts *scanOptions) error {
+	for _, format := range opts.reportFormats {
+		if format != "text" && format != "json" && format != "csv" {
+			return fmt.Errorf("invalid report format '%s', expected 'text', 'json' or 'csv'", format)
+		}
+	}
+	if opts.format != "" && opts.format != checksum.FormatJSON && opts.format != checksum.FormatBinary {
+		return fmt.Errorf("invalid database format '%s', expected 'json' or 'binary'", opts.format)
+	}
+	if opts.Sidecars && (opts.format == checksum.FormatBinary || (opts.shards != "" && opts.shards != "off")) {
+		return fmt.Errorf("sidecar manifests are always JSON and cannot be sharded")
+	}
+	dir := opts.DatabaseDir()
+	opts.IgnoreFiles = append(opts.IgnoreFiles,
+		opts.historyPath(),
+		opts.checkpointPath(),
+		opts.samplePath(),
+		filepath.Join(dir, reportFileName+".json"),
+		filepath.Join(dir, reportFileName+".csv"),
+		filepath.Join(dir, snapshotDirName),
+	)
+	if opts.keyPath != "" {
+		key, err := checksum.LoadKey(opts.keyPath)
+		if err != nil {
+			return err
+		}
+		opts.Key = key
+	}
+	return opts.Options.Validate()
+}
+
+// parseLocationFlags parses args for commands that only need to locate the
+// database, applying the selected profile.
+func parseLocationFlags(fs *flag.FlagSet, opts *scanOptions, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := applyProfile(fs, opts); err != nil {
+		return err
+	}
+	return loadDatabaseKey(opts)
+}
+
+// scopePaths returns the path arguments relative to the root. Relative
+// arguments already are; absolute ones are made relative to it.
+func scopePaths(root string, args []string) []string {
+	var paths []string
+	for _, arg := range args {
+		if filepath.IsAbs(arg) {
+			if rel, err := filepath.Rel(root, arg); err == nil {
+				arg = rel
+			}
+		}
+		paths = append(paths, arg)
+	}
+	return paths
+}
+
+// parseScanFlags parses args, applies the selected profile and validates
+// the result. The remaining arguments are the paths the scan is limited
+// to.
+func parseScanFlags(fs *flag.FlagSet, opts *scanOptions, args []string) error {
+	if err := parseLocationFlags(fs, opts, args); err != nil {
+		return err
+	}
+	opts.Paths = scopePaths(opts.RootPath(), fs.Args())
+	if err := validateScanOptions(opts); err != nil {
+		return err
+	}
+	if opts.idle {
+		return setIdlePriority()
+	}
+	return nil
+}
+
+func runGenerate(args []string, regenerateAll bool) int {
+	name := "add"
+	if regenerateAll {
+		name = "regenerate"
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	opts := addScanFlags(fs)
+	fs.BoolVar(&opts.checkpoint, "checkpoint", false, "when interrupted, save the files hashed so far instead of leaving the database untouched")
+	fs.BoolVar(&opts.resume, "resume", false, "continue an interrupted run, skipping files it hashed whose size and mtime are unchanged")
+	fs.BoolVar(&opts.allFiles, "all-files", false, "drop the file selection recorded in the database and scan every file")
+	fs.Func("shards", "save the database in shards: 'dir' for one per top-level directory, N for up to about N paths each, or 'off' (default the layout of the existing database)", func(s string) error {
+		opts.shards = s
+		return parseShardsFlag(s)
+	})
+	if err := parseScanFlags(fs, opts, args); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		return 2
+	}
+	if opts.allFiles && !opts.Selection.IsZero() {
+		fmt.Fprintln(os.Stderr, "-all-files cannot be combined with file selection flags")
+		return 2
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	if !NewMD5Hashes(ctx, regenerateAll, *opts) {
+		return 1
+	}
+	return 0
+}
+
+// runVerify exits with 0 when everything matches, 1 when discrepancies were
+// found and 2 when the verification could not run or was interrupted.
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	opts := addScanFlags(fs)
+	fs.Func("sample", "only check a sample of N files, or of N% of the bytes, continuing the rotation of earlier runs", func(s string) error {
+		var err error
+		if percent, ok := strings.CutSuffix(s, "%"); ok {
+			opts.samplePercent, err = strconv.ParseFloat(percent, 64)
+			if err != nil || opts.samplePercent <= 0 || opts.samplePercent > 100 {
+				return fmt.Errorf("invalid sample size '%s'", s)
+			}
+			return nil
+		}
+		opts.sampleFiles, err = strconv.Atoi(s)
+		if err != nil || opts.sampleFiles < 1 {
+			return fmt.Errorf("invalid sample size '%s'", s)
+		}
+		return nil
+	})
+	fs.IntVar(&opts.sampleWindow, "sample-window", 0, "check every file within this many sampled runs")
+	fs.Uint64Var(&opts.seed, "seed", 0, "seed of the sample order (default the seed of earlier runs, or a random one)")
+	fs.StringVar(&opts.Shard, "shard", "", "only verify this shard of a sharded database: a top-level directory, '.' for the files in the root (see 'md5checker shards')")
+	fs.Func("max-memory", "stream the database and sort on disk to stay within this many MiB; OK files are only counted (default no limit)", func(s string) error {
+		mib, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || mib < 16 {
+			return fmt.Errorf("invalid memory limit '%s', expected at least 16 MiB", s)
+		}
+		opts.MemoryLimit = mib * 1024 * 1024
+		return nil
+	})
+	if err := parseScanFlags(fs, opts, args); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		return 2
+	}
+	if opts.MemoryLimit > 0 && opts.sampled() {
+		fmt.Fprintln(os.Stderr, "-max-memory cannot be combined with -sample")
+		return 2
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	report := TestMD5Hashes(ctx, *opts)
+	if report == nil || report.Incomplete {
+		return 2
+	}
+	if report.Discrepancies() > 0 {
+		return 1
+	}
+	return 0
+}
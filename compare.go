@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+
+	"md5checker/checksum"
+)
+
+// hashTree hashes every selected file of a tree and returns a map of
+// relative path to content hash along with the files that could not be
+// read.
+func hashTree(ctx context.Context, opts checksum.Options) (map[string]string, []checksum.FileError) {
+	scanner := &checksum.Scanner{Options: opts}
+	files, _, errs := scanner.HashFiles(ctx)
+	return files, errs
+}
+
+// dropUnread removes the results for files that could not be read: they
+// are unknown rather than missing from the tree.
+func dropUnread(results []checksum.Result, unread []checksum.FileError) []checksum.Result {
+	if len(unread) == 0 {
+		return results
+	}
+	skip := make(map[string]bool, len(unread))
+	for _, e := range unread {
+		skip[e.Path] = true
+	}
+	kept := []checksum.Result{}
+	for _, r := range results {
+		if !skip[r.Path] {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// runCompare hashes two directory trees and classifies the second against
+// the first.
+func runCompare(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	workers := fs.Int("workers", runtime.NumCPU(), "number of files hashed in parallel per tree")
+	saveA := fs.String("save-a", "", "save the first tree as a checksum database at this path")
+	saveB := fs.String("save-b", "", "save the second tree as a checksum database at this path")
+	// Unlike a scan, a comparison skips nothing by default: both trees are
+	// copies, and a file named like a database is content like any other
+	exclude := []string{}
+	fs.Var(listFlag{&exclude}, "exclude", "skip files and directories matching these glob patterns in both trees (default none)")
+	fs.Usage = func() {
+		fmt.Println("Usage: md5checker compare [options] <A> <B>")
+		fmt.Println()
+		fmt.Println("Hashes both trees and reports B against A: NEW files exist only in B,")
+		fmt.Println("DELETED files exist only in A. Exits with 1 when the trees differ.")
+		fmt.Println()
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	var trees [2]checksum.Options
+	for i, arg := range fs.Args() {
+		trees[i] = checksum.Options{Root: arg, Exclude: exclude, Concurrency: *workers}
+		if err := trees[i].Validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid tree '%s': %v\n", arg, err)
+			return 2
+		}
+	}
+	roots := [2]string{trees[0].RootPath(), trees[1].RootPath()}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	fmt.Println("Hashing both trees...")
+	var files [2]map[string]string
+	var unread [2][]checksum.FileError
+	var wg sync.WaitGroup
+	for i := range trees {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			files[i], unread[i] = hashTree(ctx, trees[i])
+		}(i)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		fmt.Println("Interrupted: comparison incomplete.")
+		return 2
+	}
+
+	databaseA := checksum.NewDatabase(files[0])
+	results := checksum.ClassifyFiles(databaseA, files[1])
+	results["DELETED"] = dropUnread(results["DELETED"], unread[1])
+	results["NEW"] = dropUnread(results["NEW"], unread[0])
+
+	fmt.Println("\n╔════════════════════════════════════════════════════════════════╗")
+	fmt.Println("║                  TREE COMPARISON SUMMARY                       ║")
+	fmt.Println("╚════════════════════════════════════════════════════════════════╝")
+	fmt.Printf("  A: %s (%d files)\n", roots[0], len(files[0]))
+	fmt.Printf("  B: %s (%d files)\n", roots[1], len(files[1]))
+	if len(unread[0])+len(unread[1]) > 0 {
+		fmt.Printf("  Unreadable files: %d in A, %d in B\n", len(unread[0]), len(unread[1]))
+	}
+	fmt.Println("────────────────────────────────────────────────────────────────")
+
+	checksum.WriteResults(os.Stdout, results)
+	for i, name := range []string{"A", "B"} {
+		if len(unread[i]) == 0 {
+			continue
+		}
+		fmt.Printf("\n⊘ UNREADABLE IN %s (%d):\n", name, len(unread[i]))
+		for _, e := range unread[i] {
+			fmt.Printf("  • %s (%s)\n", e.Path, e.Error)
+		}
+	}
+
+	fmt.Println("────────────────────────────────────────────────────────────────")
+	exitCode := 0
+	if total := checksum.CountDiscrepancies(results); total == 0 && len(unread[0])+len(unread[1]) == 0 {
+		fmt.Println("✓ Both trees have identical content.")
+	} else {
+		if total > 0 {
+			fmt.Printf("⚠ Found %d differences between the trees.\n", total)
+		}
+		if len(unread[0])+len(unread[1]) > 0 {
+			fmt.Println("⚠ Some files could not be read and were not compared.")
+		}
+		exitCode = 1
+	}
+
+	for i, target := range []string{*saveA, *saveB} {
+		if target == "" {
+			continue
+		}
+		checksumDB := databaseA
+		if i == 1 {
+			checksumDB = checksum.NewDatabase(files[1])
+		}
+		if err := checksumDB.Save(target, nil); err != nil {
+			fmt.Printf("Error saving database for %s: %v\n", roots[i], err)
+			exitCode = 1
+			continue
+		}
+		fmt.Printf("✓ Database for %s saved to: %s\n", roots[i], target)
+	}
+	fmt.Println("════════════════════════════════════════════════════════════════")
+	return exitCode
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"md5checker/checksum"
+	"md5checker/checksum/checksumtest"
+)
+
+func TestCompareExitCode(t *testing.T) {
+	// Files a scan excludes by default are compared like any other
+	files := map[string]string{
+		"a.txt":             "alpha",
+		"0":                 "zero",
+		"checksums.json.gz": "not a database",
+		"md5checker.log":    "log",
+	}
+	a := checksumtest.WriteTree(t, files)
+	same := checksumtest.WriteTree(t, files)
+	files["md5checker.log"] = "another log"
+	changed := checksumtest.WriteTree(t, files)
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"identical", []string{a, same}, 0},
+		{"differing excluded-by-default file", []string{a, changed}, 1},
+		{"differing file excluded", []string{"-exclude", "*.log", a, changed}, 0},
+		{"missing tree", []string{a, a + "/missing"}, 2},
+		{"one tree", []string{a}, 2},
+	}
+	for _, tt := range tests {
+		if got := runCompare(tt.args); got != tt.want {
+			t.Errorf("%s: compare exited with %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDropUnread(t *testing.T) {
+	results := []checksum.Result{{Path: "gone.txt"}, {Path: "locked.txt"}}
+	kept := dropUnread(results, []checksum.FileError{{Path: "locked.txt", Error: "permission denied"}})
+	if len(kept) != 1 || kept[0].Path != "gone.txt" {
+		t.Errorf("dropUnread = %v, want only gone.txt", kept)
+	}
+}
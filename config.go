@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// configFileName is the config file looked up in the working directory when
+// -config is not given.
+const configFileName = "md5checker.toml"
+
+// profile is one named set of settings from the config file. Keys are flag
+// names with underscores instead of dashes, e.g. report_formats.
+type profile map[string]any
+
+// profilePathSettings are the settings holding a path. Relative paths in a
+// profile are resolved against the directory of the config file, so a
+// profile means the same wherever it is used from.
+var profilePathSettings = []string{"root", "database", "key", "db-key-file", "passphrase-file"}
+
+// defaultConfigPath returns the first config file that exists: the one in
+// the working directory, then the one in the user config directory.
+func defaultConfigPath() string {
+	if fileExists(configFileName) {
+		return configFileName
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		if path := filepath.Join(dir, "md5checker", "config.toml"); fileExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// loadConfig reads the profiles of a config file. Only the subset of TOML
+// the config needs is understood: [profiles.NAME] tables holding strings,
+// numbers, booleans and arrays of strings. YAML is not supported.
+func loadConfig(path string) (map[string]profile, error) {
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		return nil, fmt.Errorf("config file '%s': YAML is not supported, write the profiles as TOML", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open config file '%s': %w", path, err)
+	}
+	defer f.Close()
+
+	profiles := make(map[string]profile)
+	var current profile
+	lineNo := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		fail := func(format string, args ...any) error {
+			return fmt.Errorf("%s:%d: %s", path, lineNo, fmt.Sprintf(format, args...))
+		}
+
+		if strings.HasPrefix(line, "[") {
+			table, ok := strings.CutSuffix(strings.TrimPrefix(line, "["), "]")
+			name, isProfile := strings.CutPrefix(strings.TrimSpace(table), "profiles.")
+			if !ok || !isProfile || name == "" {
+				return nil, fail("expected a [profiles.NAME] table, got %s", line)
+			}
+			name = strings.Trim(name, `"`)
+			if _, exists := profiles[name]; exists {
+				return nil, fail("profile '%s' is defined twice", name)
+			}
+			current = make(profile)
+			profiles[name] = current
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fail("expected key = value")
+		}
+		if current == nil {
+			return nil, fail("setting outside of a [profiles.NAME] table")
+		}
+		key = strings.TrimSpace(key)
+		raw = strings.TrimSpace(raw)
+		// Arrays may span several lines
+		for strings.HasPrefix(raw, "[") && !strings.HasSuffix(raw, "]") && scanner.Scan() {
+			lineNo++
+			raw += " " + strings.TrimSpace(stripComment(scanner.Text()))
+		}
+		value, err := parseConfigValue(raw)
+		if err != nil {
+			return nil, fail("%s: %v", key, err)
+		}
+		current[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read config file '%s': %w", path, err)
+	}
+	return profiles, nil
+}
+
+// stripComment removes a trailing # comment that is not inside a basic or
+// literal string.
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseConfigValue(raw string) (any, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "'"):
+		// TOML literal string, taken as is
+		s, ok := strings.CutSuffix(raw[1:], "'")
+		if !ok || strings.Contains(s, "'") {
+			return nil, errors.New("unterminated string")
+		}
+		return s, nil
+	case strings.HasPrefix(raw, "["):
+		inner, ok := strings.CutSuffix(raw[1:], "]")
+		if !ok {
+			return nil, errors.New("unterminated array")
+		}
+		items := []string{}
+		for _, item := range splitArrayItems(inner) {
+			value, err := parseConfigValue(item)
+			if err != nil {
+				return nil, err
+			}
+			s, ok := value.(string)
+			if !ok {
+				return nil, errors.New("arrays may only hold strings")
+			}
+			items = append(items, s)
+		}
+		return items, nil
+	case raw == "true", raw == "false":
+		return raw == "true", nil
+	}
+	number := strings.ReplaceAll(raw, "_", "")
+	if n, err := strconv.ParseInt(number, 10, 64); err == nil {
+		return n, nil
+	}
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, fmt.Errorf("invalid value %s", raw)
+	}
+	return f, nil
+}
+
+// splitArrayItems splits the inside of an array at the commas that are not
+// inside a string, dropping empty items left by a trailing comma.
+func splitArrayItems(inner string) []string {
+	var items []string
+	start := 0
+	var quote rune
+	escaped := false
+	for i, c := range inner {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, inner[start:i])
+			start = i + 1
+		}
+	}
+	items = append(items, inner[start:])
+
+	var out []string
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// applyProfile fills in the flags of fs from the profile selected with
+// -profile. Flags given on the command line take precedence over the
+// profile, and settings the command has no flag for are ignored. Relative
+// paths are resolved against the directory of the config file.
+func applyProfile(fs *flag.FlagSet, opts *scanOptions) error {
+	if opts.profile == "" {
+		return nil
+	}
+	configPath := opts.configPath
+	if configPath == "" {
+		if configPath = defaultConfigPath(); configPath == "" {
+			return fmt.Errorf("profile '%s' requested but no config file found (looked for %s and the user config directory)", opts.profile, configFileName)
+		}
+	}
+	profiles, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	configDir, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return err
+	}
+	settings, ok := profiles[opts.profile]
+	if !ok {
+		return fmt.Errorf("profile '%s' not found in %s", opts.profile, configPath)
+	}
+
+	setOnCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { setOnCommandLine[f.Name] = true })
+
+	// Every scan setting is known, even to commands that do not use it
+	known := flag.NewFlagSet("", flag.ContinueOnError)
+	addScanFlags(known)
+
+	for key, value := range settings {
+		name := strings.ReplaceAll(key, "_", "-")
+		if known.Lookup(name) == nil || name == "profile" || name == "config" {
+			return fmt.Errorf("%s: unknown setting '%s' in profile '%s'", configPath, key, opts.profile)
+		}
+		if fs.Lookup(name) == nil || setOnCommandLine[name] {
+			continue
+		}
+
+		var values []string
+		switch v := value.(type) {
+		case string:
+			if contains(profilePathSettings, name) && v != "" && !filepath.IsAbs(v) {
+				v = filepath.Join(configDir, v)
+			}
+			values = []string{v}
+		case int64:
+			values = []string{strconv.FormatInt(v, 10)}
+		case float64:
+			values = []string{strconv.FormatFloat(v, 'f', -1, 64)}
+		case bool:
+			values = []string{strconv.FormatBool(v)}
+		case []string:
+			// An empty list sets nothing, leaving the defaults
+			values = v
+		}
+		for _, v := range values {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("%s: profile '%s': %s: %v", configPath, opts.profile, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// listFlag is a repeatable flag that also accepts comma-separated values.
+type listFlag struct {
+	values *[]string
+}
+
+func (l listFlag) String() string {
+	if l.values == nil {
+		return ""
+	}
+	return strings.Join(*l.values, ",")
+}
+
+func (l listFlag) Set(s string) error {
+	if *l.values == nil {
+		*l.values = []string{}
+	}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l.values = append(*l.values, v)
+		}
+	}
+	return nil
+}
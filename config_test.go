@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigValue(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    any
+		wantErr bool
+	}{
+		{raw: `"media"`, want: "media"},
+		{raw: `"tab\there \"quoted\""`, want: "tab\there \"quoted\""},
+		{raw: `'C:\backup\media'`, want: `C:\backup\media`},
+		{raw: `''`, want: ""},
+		{raw: `42`, want: int64(42)},
+		{raw: `1_000_000`, want: int64(1000000)},
+		{raw: `-3`, want: int64(-3)},
+		{raw: `2.5`, want: 2.5},
+		{raw: `true`, want: true},
+		{raw: `false`, want: false},
+		{raw: `["*.jpg", '*.mp4', "a,b"]`, want: []string{"*.jpg", "*.mp4", "a,b"}},
+		{raw: `["trailing", ]`, want: []string{"trailing"}},
+		{raw: `["escaped \\", "next"]`, want: []string{`escaped \`, "next"}},
+		{raw: `[]`, want: []string{}},
+		{raw: `"unterminated`, wantErr: true},
+		{raw: `'unterminated`, wantErr: true},
+		{raw: `'one' 'two'`, wantErr: true},
+		{raw: `"one" "two"`, wantErr: true},
+		{raw: `["unterminated"`, wantErr: true},
+		{raw: `[1, 2]`, wantErr: true},
+		{raw: `yes`, wantErr: true},
+		{raw: `inf`, wantErr: true},
+		{raw: `nan`, wantErr: true},
+		{raw: ``, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseConfigValue(tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseConfigValue(%s) error = %v, want error %v", tt.raw, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseConfigValue(%s) = %#v, want %#v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestStripComment(t *testing.T) {
+	tests := map[string]string{
+		`root = "/srv" # comment`:          `root = "/srv" `,
+		`# whole line`:                     ``,
+		`root = "/srv/#1" # comment`:       `root = "/srv/#1" `,
+		`root = '/srv/#1' # comment`:       `root = '/srv/#1' `,
+		`root = "back\\" # comment`:        `root = "back\\" `,
+		`root = "quote \" #" # comment`:    `root = "quote \" #" `,
+		`include = ["#a", '#b'] # comment`: `include = ["#a", '#b'] `,
+	}
+	for line, want := range tests {
+		if got := stripComment(line); got != want {
+			t.Errorf("stripComment(%s) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+// writeConfig writes a config file into a new directory and returns its
+// path.
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, configFileName, `
+# Two trees
+[profiles.media]
+root = "/srv/media"   # absolute
+include = [
+    "*.jpg",  # photos
+    "*.mp4",
+]
+concurrency = 4
+
+[profiles."docs"]
+exclude = []
+`)
+	profiles, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]profile{
+		"media": {"root": "/srv/media", "include": []string{"*.jpg", "*.mp4"}, "concurrency": int64(4)},
+		"docs":  {"exclude": []string{}},
+	}
+	if !reflect.DeepEqual(profiles, want) {
+		t.Errorf("profiles = %#v, want %#v", profiles, want)
+	}
+
+	bad := []struct {
+		content string
+		wantErr string
+	}{
+		{"root = \"/srv\"\n", ":1: setting outside of a [profiles.NAME] table"},
+		{"[settings]\n", ":1: expected a [profiles.NAME] table"},
+		{"[profiles.a]\n[profiles.a]\n", ":2: profile 'a' is defined twice"},
+		{"[profiles.a]\nroot\n", ":2: expected key = value"},
+		{"[profiles.a]\n\nroot = \"/srv\n", ":3: root: "},
+		{"[profiles.a]\ninclude = [1]\n", "arrays may only hold strings"},
+	}
+	for _, tt := range bad {
+		_, err := loadConfig(writeConfig(t, configFileName, tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("loadConfig(%q) error = %v, want %q", tt.content, err, tt.wantErr)
+		}
+	}
+
+	if _, err := loadConfig(writeConfig(t, "md5checker.yaml", "profiles:\n  media:\n    root: /srv\n")); err == nil || !strings.Contains(err.Error(), "YAML is not supported") {
+		t.Errorf("loading a YAML config: %v, want an error saying YAML is not supported", err)
+	}
+}
+
+func TestApplyProfilePaths(t *testing.T) {
+	path := writeConfig(t, configFileName, `
+[profiles.media]
+root = "media"
+database = "db/media.json.gz"
+key = "keys/sign.key"
+db_key_file = "keys/db.key"
+passphrase_file = "/etc/md5checker/passphrase"
+concurrency = 4
+include = ["*.jpg"]
+`)
+	dir := filepath.Dir(path)
+
+	// The profile means the same from any working directory
+	t.Chdir(t.TempDir())
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	opts := addScanFlags(fs)
+	if err := fs.Parse([]string{"-config", path, "-profile", "media", "-concurrency", "8"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyProfile(fs, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{
+		"root":            opts.Root,
+		"database":        opts.Database,
+		"key":             opts.keyPath,
+		"db_key_file":     opts.dbKeyFile,
+		"passphrase_file": opts.passphraseFile,
+	}
+	want := map[string]string{
+		"root":            filepath.Join(dir, "media"),
+		"database":        filepath.Join(dir, "db", "media.json.gz"),
+		"key":             filepath.Join(dir, "keys", "sign.key"),
+		"db_key_file":     filepath.Join(dir, "keys", "db.key"),
+		"passphrase_file": "/etc/md5checker/passphrase",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("paths = %v, want %v", got, want)
+	}
+	if opts.Concurrency != 8 || !reflect.DeepEqual(opts.Include, []string{"*.jpg"}) {
+		t.Errorf("concurrency %d and include %v, want 8 from the command line and [*.jpg]", opts.Concurrency, opts.Include)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"md5checker/checksum"
+)
+
+// runConvert rewrites a database in the JSON or binary format, in place or
+// to another file.
+func runConvert(args []string) int {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	opts := addLocationFlags(fs)
+	to := fs.String("to", "", "target format: 'json' or 'binary' (required)")
+	out := fs.String("out", "", "write the converted database here instead of replacing the input")
+	fs.Usage = func() {
+		fmt.Println("Usage: md5checker convert -to json|binary [options] [database]")
+		fmt.Println()
+		fmt.Println("Converts 'current' (default), a snapshot label or a database file.")
+		fmt.Println()
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	if err := parseLocationFlags(fs, opts, args); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		return 2
+	}
+	if (*to != checksum.FormatJSON && *to != checksum.FormatBinary) || fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	arg := "current"
+	if fs.NArg() > 0 {
+		arg = fs.Arg(0)
+	}
+	path, err := resolveDatabase(*opts, arg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	target := path
+	if *out != "" {
+		target = *out
+	}
+	if sharded, _ := checksum.IsShardedDatabase(path); sharded && target == path {
+		fmt.Fprintln(os.Stderr, "a sharded database is converted with 'md5checker add -format', or exported as a single file with -out")
+		return 1
+	}
+
+	var before int64
+	if info, err := os.Stat(path); err == nil {
+		before = info.Size()
+	}
+	// A JSON database keeps its encryption
+	key := opts.DatabaseKey
+	if *to == checksum.FormatBinary {
+		key = nil
+		if encrypted, _ := checksum.IsEncryptedDatabase(path); encrypted {
+			fmt.Fprintln(os.Stderr, "the binary format cannot be encrypted, decrypt the database with 'md5checker rekey -decrypt' first")
+			return 1
+		}
+	}
+	// Entries are copied one at a time, so databases of any size convert
+	// in bounded memory
+	selection, err := checksum.ReadSelection(path, opts.DatabaseKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	w, err := checksum.CreateDatabase(target, *to, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if err := w.SetSelection(selection); err != nil {
+		w.Abort()
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	start := time.Now()
+	entries := 0
+	err = checksum.ReadDatabase(path, opts.DatabaseKey, func(hash string, infoData checksum.InfoData) error {
+		entries++
+		return w.Write(hash, infoData)
+	})
+	if err == nil {
+		err = w.Close()
+	} else {
+		w.Abort()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	elapsed := time.Since(start)
+
+	var after int64
+	if info, err := os.Stat(target); err == nil {
+		after = info.Size()
+	}
+	fmt.Printf("✓ Converted %s to %s: %d entries in %s\n", path, *to, entries, elapsed.Round(time.Millisecond))
+	fmt.Printf("  Size: %s → %s\n", checksum.FormatBytes(before), checksum.FormatBytes(after))
+	fmt.Printf("  Saved to: %s\n", target)
+	if target == opts.DatabasePath() {
+		signAfterSave(*opts)
+	}
+	return 0
+}
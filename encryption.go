@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"md5checker/checksum"
+)
+
+// passphraseEnv holds the passphrase of an encrypted database when no key
+// or passphrase file is given.
+const passphraseEnv = "MD5CHECKER_PASSPHRASE"
+
+// loadDatabaseKey loads the key of an encrypted database from the key
+// file, the passphrase file or the passphrase environment variable.
+func loadDatabaseKey(opts *scanOptions) error {
+	switch {
+	case opts.dbKeyFile != "" && opts.passphraseFile != "":
+		return fmt.Errorf("-db-key-file and -passphrase-file cannot be combined")
+	case opts.dbKeyFile != "":
+		key, err := checksum.LoadDatabaseKeyFile(opts.dbKeyFile)
+		if err != nil {
+			return err
+		}
+		opts.DatabaseKey = key
+	case opts.passphraseFile != "":
+		passphrase, err := readPassphraseFile(opts.passphraseFile)
+		if err != nil {
+			return err
+		}
+		opts.DatabaseKey = checksum.NewPassphraseKey(passphrase)
+	default:
+		opts.DatabaseKey = passphraseFromEnv()
+	}
+	return nil
+}
+
+// readPassphraseFile reads a passphrase from the first line of a file.
+func readPassphraseFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read passphrase file '%s': %w", path, err)
+	}
+	passphrase, _, _ := strings.Cut(string(data), "\n")
+	passphrase = strings.TrimSuffix(passphrase, "\r")
+	if passphrase == "" {
+		return "", fmt.Errorf("passphrase file '%s' is empty", path)
+	}
+	return passphrase, nil
+}
+
+// passphraseFromEnv returns the database key from the passphrase
+// environment variable, or nil when it is not set.
+func passphraseFromEnv() *checksum.DatabaseKey {
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return checksum.NewPassphraseKey(passphrase)
+	}
+	return nil
+}
+
+// runRekey re-encrypts the database and its snapshots with a new key, or
+// decrypts them.
+func runRekey(args []string) int {
+	fs := flag.NewFlagSet("rekey", flag.ContinueOnError)
+	opts := addLocationFlags(fs)
+	newKeyFile := fs.String("new-db-key-file", "", "encrypt with this key file")
+	newPassphraseFile := fs.String("new-passphrase-file", "", "encrypt with the passphrase in this file")
+	decrypt := fs.Bool("decrypt", false, "store the database unencrypted")
+	fs.Usage = func() {
+		fmt.Println("Usage: md5checker rekey [options]")
+		fmt.Println()
+		fmt.Println("Re-encrypts the database and its snapshots. The current key is given as")
+		fmt.Println("for every other command, the new one with exactly one of -new-db-key-file,")
+		fmt.Println("-new-passphrase-file or -decrypt.")
+		fmt.Println()
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	if err := parseLocationFlags(fs, opts, args); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		return 2
+	}
+
+	newOpts := *opts
+	newOpts.dbKeyFile, newOpts.passphraseFile, newOpts.DatabaseKey = *newKeyFile, *newPassphraseFile, nil
+	selected := 0
+	for _, set := range []bool{*newKeyFile != "", *newPassphraseFile != "", *decrypt} {
+		if set {
+			selected++
+		}
+	}
+	if selected != 1 {
+		fmt.Fprintln(os.Stderr, "exactly one of -new-db-key-file, -new-passphrase-file or -decrypt is required")
+		return 2
+	}
+	if !*decrypt {
+		if err := loadDatabaseKey(&newOpts); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+	}
+
+	// Load everything first, so a wrong key leaves every file untouched
+	checksumFilePath := opts.DatabasePath()
+	if sharded, _ := checksum.IsShardedDatabase(checksumFilePath); sharded {
+		fmt.Fprintln(os.Stderr, "sharded databases cannot be encrypted, save it as a single file with 'md5checker add -shards off' first")
+		return 1
+	}
+	snapshots, err := listSnapshotFiles(*opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	paths := append([]string{checksumFilePath}, snapshots...)
+	databases := make([]checksum.Database, len(paths))
+	selections := make([]checksum.Selection, len(paths))
+	for i, path := range paths {
+		if databases[i], err = checksum.LoadDatabase(path, opts.DatabaseKey); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if selections[i], err = checksum.ReadSelection(path, opts.DatabaseKey); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+	for i, path := range paths {
+		// Binary databases stay binary unless they are encrypted now
+		format := checksum.FormatJSON
+		if newOpts.DatabaseKey == nil {
+			format, _ = checksum.DetectFormat(path)
+		}
+		// A binary snapshot encrypted now is renamed for its new format
+		target := path
+		if label, ok := snapshotLabel(filepath.Base(path)); ok && i > 0 {
+			target = snapshotPath(newOpts, label, format)
+		}
+		if err := databases[i].SaveAs(target, format, newOpts.DatabaseKey, selections[i]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if target != path {
+			if err := os.Remove(path); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 1
+			}
+		}
+	}
+	saveTree(newOpts, databases[0])
+
+	// The journal and the checkpoint log list every path in the clear, and
+	// the journal chain ends at the old database file. A decrypted database
+	// starts a new journal on the next add.
+	for _, path := range []string{checksum.JournalPath(checksumFilePath), opts.checkpointPath()} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+
+	if *decrypt {
+		fmt.Printf("✓ Decrypted %s and %d snapshots\n", checksumFilePath, len(snapshots))
+	} else {
+		fmt.Printf("✓ Re-encrypted %s and %d snapshots with the new key\n", checksumFilePath, len(snapshots))
+		dir := opts.DatabaseDir()
+		for _, path := range []string{opts.historyPath(), filepath.Join(dir, reportFileName+".json"), filepath.Join(dir, reportFileName+".csv")} {
+			if _, err := os.Stat(path); err == nil {
+				fmt.Printf("Note: %s is not encrypted and lists paths in the clear; delete it if they must stay private.\n", path)
+			}
+		}
+	}
+	signAfterSave(newOpts)
+	return 0
+}
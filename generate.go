@@ -1,40 +1,27 @@
 package main
 
 import (
-	"compress/gzip"
-	"crypto/md5"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
-	"regexp"
-	"strings"
-	"time"
 
 	"github.com/cheggaaa/pb/v3"
-)
-
-type PathEntry struct {
-	Path      string `json:"Path"`
-	FirstSeen string `json:"FirstSeen"`
-	LastSeen  string `json:"LastSeen"`
-}
 
-type InfoData struct {
-	ContentMD5        string      `json:"ContentMD5"`
-	RelativePaths     []PathEntry `json:"RelativePaths"`
-	FirstCreated      string      `json:"FirstCreated"`
-	LastContentUpdate string      `json:"LastContentUpdate"`
-}
+	"md5checker/checksum"
+)
 
-func NewMD5Hashes(regenerateAll bool) {
-	baseLocationPath, _ := os.Getwd()
-	checksumFileName := "checksums.json.gz"
-	excludedFileNames := []string{"0", checksumFileName}
+// checkpointFileName is the log of files hashed by an add or regenerate
+// run, kept next to the database until the run completes.
+const checkpointFileName = "checksums.checkpoint.jsonl"
 
-	// Exclude all md5checker binaries (current exe and all platform builds)
-	excludedPrefixes := []string{"md5checker"}
+// NewMD5Hashes adds new files to the database, or regenerates it, and
+// reports whether the run completed. When ctx is cancelled the database is
+// left untouched, or saved as a checkpoint if requested; the checkpoint log
+// lets a run with opts.resume skip the files already hashed.
+func NewMD5Hashes(ctx context.Context, regenerateAll bool, opts scanOptions) bool {
+	baseLocationPath := opts.RootPath()
 
 	if regenerateAll {
 		fmt.Println("╔════════════════════════════════════════════════════════════════╗")
@@ -47,225 +34,147 @@ func NewMD5Hashes(regenerateAll bool) {
 	}
 
 	fmt.Printf("Scanning for files to process in '%s'...\n", baseLocationPath)
-	var filesToProcess []string
-	filepath.WalkDir(baseLocationPath, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return nil
-		}
-		if d.IsDir() {
-			return nil
-		}
-		// Skip exact matches
-		if contains(excludedFileNames, d.Name()) {
-			return nil
-		}
-		// Skip files starting with excluded prefixes
-		for _, prefix := range excludedPrefixes {
-			if strings.HasPrefix(d.Name(), prefix) {
-				return nil
-			}
-		}
-		filesToProcess = append(filesToProcess, path)
-		return nil
-	})
-
-	if len(filesToProcess) == 0 {
-		fmt.Println("No files found to process (excluding checks directory and excluded files).")
-		return
-	}
-
-	fmt.Printf("Found %d files to process...\n", len(filesToProcess))
 
 	// Load existing checksum database
-	checksumDB := make(map[string]InfoData)
-	checksumFilePath := filepath.Join(baseLocationPath, checksumFileName)
-	f, err := os.Open(checksumFilePath)
-	if err == nil {
-		defer f.Close()
-		gz, gzErr := gzip.NewReader(f)
-		if gzErr == nil {
-			defer gz.Close()
-			decoder := json.NewDecoder(gz)
-			if decodeErr := decoder.Decode(&checksumDB); decodeErr != nil {
-				fmt.Printf("Warning: Could not parse existing checksum file: %v. Starting fresh.\n", decodeErr)
-				checksumDB = make(map[string]InfoData)
-			}
+	checksumFilePath := opts.DatabasePath()
+	var checksumDB checksum.Database
+	var err error
+	if opts.Sidecars {
+		checksumFilePath = filepath.Join(baseLocationPath, "**", checksum.SidecarFileName)
+		checksumDB, err = checksum.LoadSidecars(ctx, opts.Options)
+	} else {
+		checksumDB, err = checksum.LoadDatabase(checksumFilePath, opts.DatabaseKey)
+	}
+	if errors.Is(err, checksum.ErrEncrypted) || errors.Is(err, checksum.ErrWrongKey) {
+		// Starting fresh would replace the encrypted database
+		fmt.Printf("%v\n", err)
+		return false
+	}
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Warning: %v. Starting fresh.\n", err)
 		}
+		checksumDB = make(checksum.Database)
+	}
+	if existing, algorithm := checksum.DetectAlgorithm(checksumDB), checksum.AlgorithmName(opts.Algorithm); regenerateAll && existing != "" && existing != algorithm {
+		fmt.Printf("Converting the database from %s to %s.\n", existing, algorithm)
 	}
 
-	processedFilesCount := 0
-	pathsAddedToDbCount := 0
-	pathsUpdatedInDbCount := 0
-	pathsPrunedFromDbCount := 0
-	errorCount := 0
-
-	md5Pattern := regexp.MustCompile("^[a-f0-9]{32}$")
-
-	// Initialize progress bar
-	fmt.Println("\nProcessing files...")
-	bar := pb.StartNew(len(filesToProcess))
-	bar.SetTemplate(`{{ green "Processing:" }} {{ bar . "<" "=" (cycle . "↖" "↗" "↘" "↙" ) "." ">"}} {{percent . }} {{counters . }} {{speed . "%s files/sec" }} {{ "ETA:" }} {{rtime . "%s"}}`)
-	bar.SetWidth(80)
-
-	for _, filePath := range filesToProcess {
-		fileRelativePath, _ := filepath.Rel(baseLocationPath, filePath)
-
-		// Update progress bar with current file
-		bar.Set("prefix", fmt.Sprintf("📄 %s", truncatePath(fileRelativePath, 50)))
-
-		// Compute hash
-		file, err := os.Open(filePath)
+	// Keep the file selection the database was scanned with, unless a new
+	// one is given
+	if opts.Selection.IsZero() && !opts.allFiles {
+		selection, err := checksum.RecordedSelection(opts.Options)
 		if err != nil {
-			fmt.Printf("\nError opening file '%s': %v\n", filePath, err)
-			errorCount++
-			bar.Increment()
-			continue
-		}
-		defer file.Close()
-
-		hash := md5.New()
-		if _, err := io.Copy(hash, file); err != nil {
-			fmt.Printf("\nError hashing file '%s': %v\n", filePath, err)
-			errorCount++
-			bar.Increment()
-			continue
-		}
-		fileContentHash := fmt.Sprintf("%x", hash.Sum(nil))
-
-		if !md5Pattern.MatchString(fileContentHash) {
-			fmt.Printf("\nGenerated hash '%s' for file '%s' is not a valid MD5 format. Skipping.\n", fileContentHash, filePath)
-			errorCount++
-			bar.Increment()
-			continue
+			fmt.Printf("%v\n", err)
+			return false
 		}
+		opts.Selection = selection
+	}
+	if !opts.Selection.IsZero() {
+		fmt.Printf("File selection: %s\n", opts.Selection)
+	}
 
-		currentTime := time.Now().UTC().Format(time.RFC3339)
+	if !checkSignatureBeforeUpdate(opts, regenerateAll) {
+		return false
+	}
+	format := opts.databaseFormat()
+	if format == checksum.FormatBinary && opts.DatabaseKey != nil {
+		fmt.Println("The binary format cannot be encrypted; use -format json for an encrypted database.")
+		return false
+	}
+	if mode, _ := opts.shardLayout(); mode != "" && opts.DatabaseKey != nil {
+		fmt.Println("Sharded databases cannot be encrypted; use -shards off for an encrypted database.")
+		return false
+	}
 
-		// Check if this file path already exists in ANY hash entry
-		existingHash := ""
-		for hash, info := range checksumDB {
-			for _, p := range info.RelativePaths {
-				if p.Path == fileRelativePath {
-					existingHash = hash
-					break
-				}
-			}
-			if existingHash != "" {
-				break
-			}
+	// The checkpoint log lists every path in the clear, so an encrypted
+	// database keeps none
+	var checkpoint *checksum.Checkpoint
+	checkpointPath := opts.checkpointPath()
+	if opts.DatabaseKey != nil {
+		if opts.resume {
+			fmt.Println("Encrypted databases keep no checkpoint log to resume from; use -checkpoint to save the files hashed so far when interrupted.")
+			return false
 		}
-
-		// If regenerateAll is false and file already exists in DB, skip it
-		if !regenerateAll && existingHash != "" {
-			if existingHash == fileContentHash {
-				// File hasn't changed, just update LastSeen
-				for i := range checksumDB[existingHash].RelativePaths {
-					if checksumDB[existingHash].RelativePaths[i].Path == fileRelativePath {
-						checksumDB[existingHash].RelativePaths[i].LastSeen = currentTime
-						pathsUpdatedInDbCount++
-						break
-					}
-				}
-			}
-			// Skip processing - don't update if content changed
-			continue
+	} else {
+		if _, err := os.Stat(checkpointPath); err == nil && !opts.resume {
+			fmt.Println("Discarding the checkpoint of an earlier interrupted run (use -resume to continue from it).")
 		}
-
-		// If regenerateAll is true and file exists with different hash, remove old entry
-		if regenerateAll && existingHash != "" && existingHash != fileContentHash {
-			// Remove from old hash entry
-			oldInfo := checksumDB[existingHash]
-			var newPaths []PathEntry
-			for _, p := range oldInfo.RelativePaths {
-				if p.Path != fileRelativePath {
-					newPaths = append(newPaths, p)
-				}
-			}
-			if len(newPaths) == 0 {
-				delete(checksumDB, existingHash)
-			} else {
-				oldInfo.RelativePaths = newPaths
-				checksumDB[existingHash] = oldInfo
-			}
+		if checkpoint, err = checksum.OpenCheckpoint(checkpointPath, opts.Options, opts.resume); err != nil {
+			fmt.Printf("%v\n", err)
+			return false
 		}
+	}
+	if opts.resume {
+		fmt.Printf("Resuming: %d files already hashed in '%s'.\n", checkpoint.Len(), checkpointPath)
+	}
 
-		infoData, exists := checksumDB[fileContentHash]
-		if !exists {
-			infoData = InfoData{
-				ContentMD5:        fileContentHash,
-				RelativePaths:     []PathEntry{},
-				FirstCreated:      currentTime,
-				LastContentUpdate: currentTime,
-			}
-		}
+	var bar *pb.ProgressBar
+	scanner := &checksum.Scanner{Checkpoint: checkpoint, Options: opts.Options, Progress: func(p checksum.Progress) {
+		if bar == nil {
+			fmt.Printf("Found %d files to process...\n", p.Total)
 
-		pathEntry := findPathEntry(infoData.RelativePaths, fileRelativePath)
-		if pathEntry != nil {
-			pathEntry.LastSeen = currentTime
-			pathsUpdatedInDbCount++
-		} else {
-			newEntry := PathEntry{
-				Path:      fileRelativePath,
-				FirstSeen: currentTime,
-				LastSeen:  currentTime,
-			}
-			infoData.RelativePaths = append(infoData.RelativePaths, newEntry)
-			pathsAddedToDbCount++
+			// Initialize progress bar
+			fmt.Println("\nProcessing files...")
+			bar = pb.StartNew(p.Total)
+			bar.SetTemplate(`{{ green "Processing:" }} {{ bar . "<" "=" (cycle . "↖" "↗" "↘" "↙" ) "." ">"}} {{percent . }} {{counters . }} {{speed . "%s files/sec" }} {{ "ETA:" }} {{rtime . "%s"}}`)
+			bar.SetWidth(80)
 		}
-
-		infoData.LastContentUpdate = currentTime
-		checksumDB[fileContentHash] = infoData
-		processedFilesCount++
+		// Update progress bar with current file
+		bar.Set("prefix", fmt.Sprintf("📄 %s", truncatePath(p.Path, 50)))
 		bar.Increment()
+	}}
+	summary, err := scanner.Update(ctx, checksumDB, regenerateAll)
+	if bar != nil {
+		// Finish progress bar
+		bar.Finish()
+		fmt.Println()
 	}
-
-	// Finish progress bar
-	bar.Finish()
-	fmt.Println()
-
-	// Prune missing paths across all entries
-	fmt.Println("Pruning missing files from database...")
-	totalEntries := len(checksumDB)
-	pruneBar := pb.StartNew(totalEntries)
-	pruneBar.SetTemplate(`{{ green "Pruning:" }} {{ bar . "<" "=" ">" "." ">"}} {{percent . }} {{counters . }}`)
-	pruneBar.SetWidth(80)
-
-	for hash, infoData := range checksumDB {
-		var newPaths []PathEntry
-		for _, p := range infoData.RelativePaths {
-			fullPath := filepath.Join(baseLocationPath, p.Path)
-			if _, err := os.Stat(fullPath); err == nil {
-				newPaths = append(newPaths, p)
-			} else {
-				pathsPrunedFromDbCount++
-			}
-		}
-		if len(newPaths) == 0 {
-			delete(checksumDB, hash)
+	if summary != nil && summary.Interrupted {
+		if err := checkpoint.Close(); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		if !opts.checkpoint {
+			fmt.Printf("Interrupted after %d files, %d not reached: database left untouched.\n", summary.FilesScanned, summary.Pending)
+		} else if err := saveDatabase(opts, checksumDB, format); err != nil {
+			fmt.Printf("Error saving checkpoint: %v\n", err)
 		} else {
-			infoData.RelativePaths = newPaths
-			checksumDB[hash] = infoData
+			fmt.Printf("Interrupted: checkpoint with %d files saved to %s, %d files not reached.\n", summary.Processed, checksumFilePath, summary.Pending)
+			fmt.Println("Missing paths were not pruned.")
+			afterSave(opts, checksumDB)
 		}
-		pruneBar.Increment()
+		if checkpoint != nil {
+			fmt.Println("Run the same command with -resume to continue.")
+		}
+		return false
+	}
+	if err != nil {
+		checkpoint.Remove()
+		fmt.Printf("%v\n", err)
+		return false
+	}
+	if err := checkpoint.Flush(); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	if summary.FilesScanned == 0 {
+		checkpoint.Remove()
+		fmt.Println("No files found to process (excluding checks directory and excluded files).")
+		return true
+	}
+	for _, fileErr := range summary.Errors {
+		fmt.Printf("Error hashing file '%s': %s\n", fileErr.Path, fileErr.Error)
 	}
-	pruneBar.Finish()
-	fmt.Println()
 
 	// Save the database (compressed)
-	checksumFilePath = filepath.Join(baseLocationPath, checksumFileName)
-	file, createErr := os.Create(checksumFilePath)
-	if createErr != nil {
-		fmt.Printf("Error creating checksum file: %v\n", createErr)
-		return
+	if err := saveDatabase(opts, checksumDB, format); err != nil {
+		fmt.Printf("Error saving checksum database: %v\n", err)
+		checkpoint.Close()
+		return false
 	}
-	defer file.Close()
-	gz := gzip.NewWriter(file)
-	defer gz.Close()
-	encoder := json.NewEncoder(gz)
-	if encodeErr := encoder.Encode(checksumDB); encodeErr != nil {
-		fmt.Printf("Error encoding checksum database: %v\n", encodeErr)
-		return
+	if err := checkpoint.Remove(); err != nil {
+		fmt.Printf("Warning: %v\n", err)
 	}
+	root := afterSave(opts, checksumDB)
 
 	fmt.Println("\n╔════════════════════════════════════════════════════════════════╗")
 	if regenerateAll {
@@ -274,21 +183,44 @@ func NewMD5Hashes(regenerateAll bool) {
 		fmt.Println("║         NEW FILES ADDED TO DATABASE                            ║")
 	}
 	fmt.Println("╚════════════════════════════════════════════════════════════════╝")
-	fmt.Printf("  Total files scanned: %d\n", len(filesToProcess))
-	fmt.Printf("  Successfully processed: %d\n", processedFilesCount)
+	fmt.Printf("  Total files scanned: %d\n", summary.FilesScanned)
+	fmt.Printf("  Successfully processed: %d\n", summary.Processed)
+	if summary.Resumed > 0 {
+		fmt.Printf("  Resumed from checkpoint: %d\n", summary.Resumed)
+	}
 	if regenerateAll {
-		fmt.Printf("  Checksums regenerated: %d\n", processedFilesCount)
+		fmt.Printf("  Checksums regenerated: %d\n", summary.Processed)
 	} else {
-		fmt.Printf("  New file paths added: %d\n", pathsAddedToDbCount)
-		fmt.Printf("  Existing paths updated: %d\n", pathsUpdatedInDbCount)
+		fmt.Printf("  New file paths added: %d\n", summary.Added)
+		fmt.Printf("  Existing paths updated: %d\n", summary.Updated)
+	}
+	fmt.Printf("  Missing paths pruned: %d\n", summary.Pruned)
+	if len(summary.Skipped) > 0 {
+		fmt.Printf("  Files skipped: %d\n", len(summary.Skipped))
 	}
-	fmt.Printf("  Missing paths pruned: %d\n", pathsPrunedFromDbCount)
-	if errorCount > 0 {
-		fmt.Printf("  Errors encountered: %d\n", errorCount)
+	if len(summary.Errors) > 0 {
+		fmt.Printf("  Errors encountered: %d\n", len(summary.Errors))
 	}
+	checksum.WriteSkipped(os.Stdout, summary.Skipped)
+	checksum.WriteWarnings(os.Stdout, summary.Warnings)
 	fmt.Println("────────────────────────────────────────────────────────────────")
 	fmt.Printf("✓ Database saved to: %s\n", checksumFilePath)
+	fmt.Printf("  Root hash: %s\n", root)
 	fmt.Println("════════════════════════════════════════════════════════════════")
+	return true
+}
+
+// afterSave stores the Merkle tree of a database that was just saved,
+// journals its changes and signs it, returning the root hash. Sidecar
+// manifests have no database file to keep these for.
+func afterSave(opts scanOptions, checksumDB checksum.Database) string {
+	if opts.Sidecars {
+		return checksum.BuildTree(checksumDB).Root
+	}
+	root := saveTree(opts, checksumDB)
+	appendJournal(opts, checksumDB)
+	signAfterSave(opts)
+	return root
 }
 
 func contains(slice []string, item string) bool {
@@ -300,15 +232,6 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
-func findPathEntry(paths []PathEntry, path string) *PathEntry {
-	for i := range paths {
-		if paths[i].Path == path {
-			return &paths[i]
-		}
-	}
-	return nil
-}
-
 // truncatePath truncates a file path to a maximum length for display
 func truncatePath(path string, maxLen int) string {
 	if len(path) <= maxLen {
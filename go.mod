@@ -2,7 +2,10 @@ module md5checker
 
 go 1.25.3
 
-require github.com/cheggaaa/pb/v3 v3.1.7
+require (
+	github.com/cheggaaa/pb/v3 v3.1.7
+	golang.org/x/crypto v0.43.0
+)
 
 require (
 	github.com/VividCortex/ewma v1.2.0 // indirect
@@ -11,5 +14,5 @@ require (
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mattn/go-runewidth v0.0.16 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
-	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/sys v0.37.0 // indirect
 )
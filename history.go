@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"md5checker/checksum"
+)
+
+// historyFileName is the verification history log kept next to the database.
+const historyFileName = "checksums.history.jsonl"
+
+// failingCategories are the categories in which a path counts as failing.
+var failingCategories = []string{"MODIFIED", "METADATA_CHANGED", "DELETED"}
+
+// HistoryRecord is one verification run as stored in the history log.
+type HistoryRecord struct {
+	ID        int                 `json:"ID"`
+	Timestamp string              `json:"Timestamp"`
+	Database  string              `json:"Database"`
+	Counts    map[string]int      `json:"Counts"`
+	Paths     map[string][]string `json:"Paths"`
+	// A run that did not check every file, or did not list every path,
+	// is partial: Scope holds the paths it was limited to, Sample is set
+	// for sampled runs, Shard for a single shard, and OKNotListed counts
+	// the OK files a run with a memory limit did not list. Partial runs
+	// are left out of diffs; file histories use only the paths they list.
+	Scope       []string `json:"Scope,omitempty"`
+	Sample      bool     `json:"Sample,omitempty"`
+	Shard       string   `json:"Shard,omitempty"`
+	Incomplete  bool     `json:"Incomplete,omitempty"`
+	OKNotListed int      `json:"OKNotListed,omitempty"`
+}
+
+// newHistoryRecord flattens a verify report into a history record. RENAMED
+// results are recorded under their new paths.
+func newHistoryRecord(report *checksum.VerifyReport) HistoryRecord {
+	record := HistoryRecord{
+		Timestamp:   report.FinishedAt,
+		Database:    report.Database,
+		Counts:      report.Summary(),
+		Paths:       make(map[string][]string),
+		Scope:       report.Scope,
+		Sample:      report.Sample != nil,
+		Shard:       report.Shard,
+		Incomplete:  report.Incomplete,
+		OKNotListed: report.OKNotListed,
+	}
+	for _, category := range checksum.Categories {
+		paths := []string{}
+		for _, r := range report.Results[category] {
+			if category == "RENAMED" {
+				paths = append(paths, r.NewPaths...)
+			} else {
+				paths = append(paths, r.Path)
+			}
+		}
+		record.Paths[category] = paths
+	}
+	return record
+}
+
+// appendHistory appends a verification run to the history log and returns
+// the stored record.
+func appendHistory(historyFilePath string, report *checksum.VerifyReport) (HistoryRecord, error) {
+	lastID, err := lastHistoryID(historyFilePath)
+	if err != nil {
+		return HistoryRecord{}, err
+	}
+
+	record := newHistoryRecord(report)
+	record.ID = lastID + 1
+
+	f, err := os.OpenFile(historyFilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return HistoryRecord{}, fmt.Errorf("could not open history file '%s': %w", historyFilePath, err)
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(record); err != nil {
+		return HistoryRecord{}, fmt.Errorf("could not write history record: %w", err)
+	}
+	return record, nil
+}
+
+// lastHistoryID returns the ID of the last record in the history log, 0 if
+// the log is missing or empty. Only the last line is read, so numbering a
+// new run does not cost a pass over the whole history.
+func lastHistoryID(historyFilePath string) (int, error) {
+	f, err := os.Open(historyFilePath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not open history file '%s': %w", historyFilePath, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("could not read history file '%s': %w", historyFilePath, err)
+	}
+
+	// Read backwards in blocks until the start of the last non-empty line
+	const blockSize = 64 * 1024
+	var tail []byte
+	for offset := info.Size(); offset > 0; {
+		n := min(int64(blockSize), offset)
+		offset -= n
+		block := make([]byte, n)
+		if _, err := f.ReadAt(block, offset); err != nil {
+			return 0, fmt.Errorf("could not read history file '%s': %w", historyFilePath, err)
+		}
+		tail = append(block, tail...)
+		line := bytes.TrimRight(tail, "\r\n")
+		if i := bytes.LastIndexByte(line, '\n'); i >= 0 {
+			tail = line[i+1:]
+			break
+		}
+	}
+	tail = bytes.TrimSpace(tail)
+	if len(tail) == 0 {
+		return 0, nil
+	}
+	var last struct{ ID int }
+	if err := json.Unmarshal(tail, &last); err != nil {
+		return 0, fmt.Errorf("could not parse history file '%s': %w", historyFilePath, err)
+	}
+	return last.ID, nil
+}
+
+// loadHistory reads every record from the history log. A missing log is
+// treated as an empty history.
+func loadHistory(historyFilePath string) ([]HistoryRecord, error) {
+	f, err := os.Open(historyFilePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open history file '%s': %w", historyFilePath, err)
+	}
+	defer f.Close()
+
+	var records []HistoryRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 256*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record HistoryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("could not parse history file '%s': %w", historyFilePath, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read history file '%s': %w", historyFilePath, err)
+	}
+	return records, nil
+}
+
+func findHistoryRecord(records []HistoryRecord, id string) (HistoryRecord, bool) {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return HistoryRecord{}, false
+	}
+	for _, r := range records {
+		if r.ID == n {
+			return r, true
+		}
+	}
+	return HistoryRecord{}, false
+}
+
+// partial reports whether the run left files unchecked or unlisted, so
+// that a path missing from it says nothing about the path.
+func (r HistoryRecord) partial() bool {
+	return len(r.Scope) > 0 || r.Sample || r.Shard != "" || r.Incomplete || r.OKNotListed > 0
+}
+
+// partialReason describes why a run is partial.
+func (r HistoryRecord) partialReason() string {
+	switch {
+	case r.Incomplete:
+		return "it was interrupted"
+	case r.Sample:
+		return "it only checked a sample"
+	case r.Shard != "":
+		return "it only checked shard " + r.Shard
+	case len(r.Scope) > 0:
+		return "it was limited to " + strings.Join(r.Scope, ", ")
+	default:
+		return "it did not list its OK files"
+	}
+}
+
+// categoryOf returns the category a path was reported under in a run, or ""
+// if the path does not appear in it.
+func (r HistoryRecord) categoryOf(path string) string {
+	for _, category := range checksum.Categories {
+		if contains(r.Paths[category], path) {
+			return category
+		}
+	}
+	return ""
+}
+
+func runHistory(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	opts := addLocationFlags(fs)
+	fs.Usage = func() {
+		fmt.Println("Usage:")
+		fmt.Println("  md5checker history [list]            List past verification runs")
+		fmt.Println("  md5checker history show <id>         Show the paths of one run")
+		fmt.Println("  md5checker history diff <id> <id>    Show what changed between two runs")
+		fmt.Println("  md5checker history file <path>       Show when a file first started failing")
+		fmt.Println()
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	if err := parseLocationFlags(fs, opts, args); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		return 2
+	}
+
+	records, err := loadHistory(opts.historyPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if len(records) == 0 {
+		fmt.Println("No verification history recorded yet.")
+		return 0
+	}
+
+	rest := fs.Args()
+	subcommand := "list"
+	if len(rest) > 0 {
+		subcommand, rest = rest[0], rest[1:]
+	}
+
+	switch {
+	case subcommand == "list":
+		printHistoryList(records)
+	case subcommand == "show" && len(rest) == 1:
+		record, ok := findHistoryRecord(records, rest[0])
+		if !ok {
+			fmt.Fprintf(os.Stderr, "No history record with ID '%s'.\n", rest[0])
+			return 1
+		}
+		printHistoryRecord(record)
+	case subcommand == "diff" && len(rest) == 2:
+		from, ok := findHistoryRecord(records, rest[0])
+		if !ok {
+			fmt.Fprintf(os.Stderr, "No history record with ID '%s'.\n", rest[0])
+			return 1
+		}
+		to, ok := findHistoryRecord(records, rest[1])
+		if !ok {
+			fmt.Fprintf(os.Stderr, "No history record with ID '%s'.\n", rest[1])
+			return 1
+		}
+		for _, r := range []HistoryRecord{from, to} {
+			if r.partial() {
+				fmt.Fprintf(os.Stderr, "Run %d cannot be compared because %s.\n", r.ID, r.partialReason())
+				return 1
+			}
+		}
+		printHistoryDiff(from, to)
+	case subcommand == "file" && len(rest) == 1:
+		printFileHistory(records, filepath.Clean(rest[0]))
+	default:
+		fs.Usage()
+		return 2
+	}
+	return 0
+}
+
+func printHistoryList(records []HistoryRecord) {
+	fmt.Println("╔════════════════════════════════════════════════════════════════╗")
+	fmt.Println("║                   VERIFICATION HISTORY                         ║")
+	fmt.Println("╚════════════════════════════════════════════════════════════════╝")
+	fmt.Printf("  %-5s %-21s %6s %9s %9s %8s %6s %6s %8s\n", "ID", "Timestamp", "OK", "MODIFIED", "METADATA", "RENAMED", "MOVED", "NEW", "DELETED")
+	partial := false
+	for _, r := range records {
+		marker := " "
+		if r.partial() {
+			marker, partial = "*", true
+		}
+		fmt.Printf("  %-5s %-21s %6d %9d %9d %8d %6d %6d %8d\n", strconv.Itoa(r.ID)+marker, r.Timestamp,
+			r.Counts["OK"], r.Counts["MODIFIED"], r.Counts["METADATA_CHANGED"], r.Counts["RENAMED"], r.Counts["MOVED"], r.Counts["NEW"], r.Counts["DELETED"])
+	}
+	if partial {
+		fmt.Println("  * partial run (scoped, sampled, single shard or OK files not listed), left out of diffs")
+	}
+	fmt.Println("════════════════════════════════════════════════════════════════")
+}
+
+func printHistoryRecord(record HistoryRecord) {
+	fmt.Printf("Run %d at %s\n", record.ID, record.Timestamp)
+	fmt.Printf("Database: %s\n", record.Database)
+	if record.partial() {
+		fmt.Printf("Partial run: %s\n", record.partialReason())
+	}
+	for _, category := range checksum.Categories {
+		paths := record.Paths[category]
+		if len(paths) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s (%d):\n", category, len(paths))
+		for _, p := range paths {
+			fmt.Printf("  • %s\n", p)
+		}
+	}
+}
+
+// printHistoryDiff shows, per category, the paths that entered or left the
+// category between two runs.
+func printHistoryDiff(from, to HistoryRecord) {
+	fmt.Printf("Changes from run %d (%s) to run %d (%s)\n", from.ID, from.Timestamp, to.ID, to.Timestamp)
+	fmt.Println("────────────────────────────────────────────────────────────────")
+	changes := 0
+	for _, category := range checksum.Categories {
+		added := pathsNotIn(to.Paths[category], from.Paths[category])
+		removed := pathsNotIn(from.Paths[category], to.Paths[category])
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+		changes += len(added) + len(removed)
+		fmt.Printf("\n%s (%d → %d):\n", category, from.Counts[category], to.Counts[category])
+		for _, p := range added {
+			fmt.Printf("  + %s\n", p)
+		}
+		for _, p := range removed {
+			fmt.Printf("  - %s\n", p)
+		}
+	}
+	if changes == 0 {
+		fmt.Println("No differences between the two runs.")
+	}
+}
+
+// fileHistory is the verification record of one path.
+type fileHistory struct {
+	// seen is false if no run reported the path
+	seen bool
+	// last is the latest run that reported the path
+	last HistoryRecord
+	// firstFailure and streakStart are the first failing run and the start
+	// of the current failing streak, nil if there is none
+	firstFailure, streakStart *HistoryRecord
+}
+
+// historyOf walks the runs in order, remembering the first failure of the
+// path and the start of its current failing streak. Partial runs count
+// where they report the path; only its absence from them says nothing.
+func historyOf(records []HistoryRecord, path string) fileHistory {
+	var h fileHistory
+	for i := range records {
+		category := records[i].categoryOf(path)
+		if category == "" {
+			continue
+		}
+		h.seen = true
+		h.last = records[i]
+		if contains(failingCategories, category) {
+			if h.firstFailure == nil {
+				h.firstFailure = &records[i]
+			}
+			if h.streakStart == nil {
+				h.streakStart = &records[i]
+			}
+		} else {
+			h.streakStart = nil
+		}
+	}
+	return h
+}
+
+// printFileHistory reports when a path started failing.
+func printFileHistory(records []HistoryRecord, path string) {
+	h := historyOf(records, path)
+	if !h.seen {
+		fmt.Printf("'%s' does not appear in any recorded verification run.\n", path)
+		return
+	}
+	if h.firstFailure == nil {
+		fmt.Printf("✓ '%s' has never failed verification (last seen in run %d as %s).\n", path, h.last.ID, h.last.categoryOf(path))
+		return
+	}
+	fmt.Printf("'%s' first failed in run %d at %s (%s).\n", path, h.firstFailure.ID, h.firstFailure.Timestamp, h.firstFailure.categoryOf(path))
+	if h.streakStart != nil {
+		fmt.Printf("⚠ Failing continuously since run %d at %s (currently %s).\n", h.streakStart.ID, h.streakStart.Timestamp, h.last.categoryOf(path))
+	} else {
+		fmt.Printf("✓ Not failing in its latest run %d (%s).\n", h.last.ID, h.last.categoryOf(path))
+	}
+}
+
+// pathsNotIn returns the paths of a that are not in b, sorted.
+func pathsNotIn(a, b []string) []string {
+	inB := make(map[string]bool, len(b))
+	for _, p := range b {
+		inB[p] = true
+	}
+	var out []string
+	for _, p := range a {
+		if !inB[p] {
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+// testRun returns a history record that reports each path under its
+// category.
+func testRun(id int, sample bool, paths map[string]string) HistoryRecord {
+	record := HistoryRecord{ID: id, Sample: sample, Paths: make(map[string][]string)}
+	for path, category := range paths {
+		record.Paths[category] = append(record.Paths[category], path)
+	}
+	return record
+}
+
+func TestHistoryOfSampledFailure(t *testing.T) {
+	tests := []struct {
+		name        string
+		records     []HistoryRecord
+		first       int
+		streakStart int
+		last        int
+	}{
+		{
+			name: "sampled failure, full run still failing",
+			records: []HistoryRecord{
+				testRun(1, false, map[string]string{"a": "OK", "b": "OK"}),
+				testRun(2, true, map[string]string{"a": "MODIFIED"}),
+				// A sample that did not pick the file says nothing about it
+				testRun(3, true, map[string]string{"b": "OK"}),
+				testRun(4, false, map[string]string{"a": "MODIFIED", "b": "OK"}),
+			},
+			first: 2, streakStart: 2, last: 4,
+		},
+		{
+			name: "sampled failure, restored by the full run",
+			records: []HistoryRecord{
+				testRun(1, false, map[string]string{"a": "OK"}),
+				testRun(2, true, map[string]string{"a": "DELETED"}),
+				testRun(3, false, map[string]string{"a": "OK"}),
+			},
+			first: 2, last: 3,
+		},
+	}
+	for _, tt := range tests {
+		h := historyOf(tt.records, "a")
+		if !h.seen || h.last.ID != tt.last {
+			t.Errorf("%s: last seen in run %d (seen %v), want %d", tt.name, h.last.ID, h.seen, tt.last)
+		}
+		if h.firstFailure == nil || h.firstFailure.ID != tt.first {
+			t.Errorf("%s: first failure %v, want run %d", tt.name, h.firstFailure, tt.first)
+		}
+		streakStart := 0
+		if h.streakStart != nil {
+			streakStart = h.streakStart.ID
+		}
+		if streakStart != tt.streakStart {
+			t.Errorf("%s: failing since run %d, want %d", tt.name, streakStart, tt.streakStart)
+		}
+	}
+
+	if h := historyOf([]HistoryRecord{testRun(1, true, map[string]string{"b": "OK"})}, "a"); h.seen {
+		t.Error("a path no run reported was seen")
+	}
+}
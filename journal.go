@@ -0,0 +1,210 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"md5checker/checksum"
+)
+
+// appendJournal records the changes of a database that was just saved in
+// its journal. Encrypted databases keep no journal, as it lists every path.
+func appendJournal(opts scanOptions, checksumDB checksum.Database) {
+	if opts.DatabaseKey != nil {
+		return
+	}
+	journal, err := checksum.OpenJournal(checksum.JournalPath(opts.DatabasePath()))
+	if err != nil {
+		fmt.Printf("⚠ Not appending to the journal: %v\n", err)
+		return
+	}
+	n, err := journal.Append(checksumDB)
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		return
+	}
+	if n > 0 {
+		fmt.Printf("  Journal: %d changes recorded, head %s\n", n, journal.Head()[:16])
+	}
+}
+
+// parseJournalTime parses the -at option: an RFC 3339 time, or a date
+// meaning the end of that day in UTC.
+func parseJournalTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.Add(24*time.Hour - time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time '%s', expected e.g. 2026-01-31 or 2026-01-31T12:00:00Z", s)
+}
+
+func runJournal(args []string) int {
+	fs := flag.NewFlagSet("journal", flag.ContinueOnError)
+	opts := addLocationFlags(fs)
+	head := fs.String("head", "", "verify: also check that the chain contains this head hash noted earlier")
+	at := fs.String("at", "", "rebuild: the point in time, e.g. 2026-01-31 (end of day, UTC) or 2026-01-31T12:00:00Z")
+	out := fs.String("out", "", "rebuild: path of the rebuilt database")
+	fs.Usage = func() {
+		fmt.Println("Usage:")
+		fmt.Println("  md5checker journal [log] [path]      List the recorded changes, optionally of one path")
+		fmt.Println("  md5checker journal verify            Check the hash chain against the database")
+		fmt.Println("  md5checker journal rebuild -at <time> -out <file>")
+		fmt.Println("                                       Rebuild the database as it was at a point in time")
+		fmt.Println()
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	if err := parseLocationFlags(fs, opts, args); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		return 2
+	}
+
+	rest := fs.Args()
+	subcommand := "log"
+	if len(rest) > 0 {
+		subcommand, rest = rest[0], rest[1:]
+		// Options may also follow the subcommand
+		if err := parseLocationFlags(fs, opts, rest); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+		rest = fs.Args()
+	}
+
+	journalPath := checksum.JournalPath(opts.DatabasePath())
+	records, err := checksum.ReadJournal(journalPath)
+	switch {
+	case subcommand == "log" && len(rest) <= 1:
+		if err != nil {
+			fmt.Printf("⚠ %v\n", err)
+		}
+		printJournal(records, rest)
+	case subcommand == "verify" && len(rest) == 0:
+		if *head != "" && len(*head) < 16 {
+			fmt.Fprintln(os.Stderr, "-head needs at least the 16 characters printed after every run")
+			return 2
+		}
+		return verifyJournal(*opts, records, err, *head)
+	case subcommand == "rebuild" && len(rest) == 0 && *at != "" && *out != "":
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		until, err := parseJournalTime(*at)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+		if fileExists(*out) {
+			fmt.Fprintf(os.Stderr, "'%s' already exists\n", *out)
+			return 1
+		}
+		checksumDB := checksum.ReplayJournal(records, until)
+		if err := checksumDB.Save(*out, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Printf("✓ Database as of %s (%d paths) saved to: %s\n", until.UTC().Format(time.RFC3339), len(checksumDB.PathIndex()), *out)
+	default:
+		fs.Usage()
+		return 2
+	}
+	return 0
+}
+
+func printJournal(records []checksum.JournalRecord, paths []string) {
+	if len(records) == 0 {
+		fmt.Println("No changes recorded in the journal yet.")
+		return
+	}
+	fmt.Printf("%-6s  %-20s  %-8s  %-16s  %s\n", "Seq", "Time", "Change", "Content", "Path")
+	fmt.Println("────────────────────────────────────────────────────────────────")
+	for _, record := range records {
+		if len(paths) == 1 && record.Path != filepath.Clean(paths[0]) {
+			continue
+		}
+		hash := record.ContentHash
+		if record.Type == checksum.JournalRemoved {
+			hash = record.OldContentHash
+		}
+		if len(hash) > 16 {
+			hash = hash[:16]
+		}
+		fmt.Printf("%-6d  %-20s  %-8s  %-16s  %s\n", record.Seq, record.Time, record.Type, hash, record.Path)
+	}
+}
+
+// verifyJournal checks the hash chain and that replaying it yields the
+// paths and hashes of the current database. It returns 1 when either check
+// fails.
+func verifyJournal(opts scanOptions, records []checksum.JournalRecord, chainErr error, head string) int {
+	fmt.Println("╔════════════════════════════════════════════════════════════════╗")
+	fmt.Println("║                    JOURNAL VERIFICATION                        ║")
+	fmt.Println("╚════════════════════════════════════════════════════════════════╝")
+	if chainErr != nil {
+		fmt.Printf("⚠ Hash chain broken: %v\n", chainErr)
+		fmt.Printf("  The first %d records are intact.\n", len(records))
+		return 1
+	}
+	if len(records) == 0 {
+		fmt.Println("No changes recorded in the journal yet.")
+		return 1
+	}
+	last := records[len(records)-1]
+	fmt.Printf("✓ Hash chain intact: %d records from %s to %s\n", len(records), records[0].Time, last.Time)
+	fmt.Printf("  Head: %s\n", last.RecordHash)
+
+	ok := true
+	if head != "" {
+		found := false
+		for _, record := range records {
+			if strings.HasPrefix(record.RecordHash, strings.ToLower(head)) {
+				fmt.Printf("✓ Head %s is record %d of %s\n", head, record.Seq, record.Time)
+				found = true
+				break
+			}
+		}
+		if !found {
+			fmt.Printf("⚠ Head %s is not in the chain: the history was rewritten\n", head)
+			ok = false
+		}
+	}
+
+	checksumDB, err := checksum.LoadDatabase(opts.DatabasePath(), opts.DatabaseKey)
+	if err != nil {
+		fmt.Printf("⚠ %v\n", err)
+		return 1
+	}
+	replayed := checksum.ReplayJournal(records, time.Time{}).PathIndex()
+	current := checksumDB.PathIndex()
+	differing := 0
+	for path, hash := range current {
+		if replayed[path] != hash {
+			differing++
+		}
+	}
+	for path := range replayed {
+		if _, exists := current[path]; !exists {
+			differing++
+		}
+	}
+	if differing > 0 {
+		fmt.Printf("⚠ The database differs from the journal at %d paths: it was changed outside md5checker\n", differing)
+		ok = false
+	} else {
+		fmt.Printf("✓ The database matches the journal (%d paths)\n", len(current))
+	}
+	fmt.Println("════════════════════════════════════════════════════════════════")
+	if !ok {
+		return 1
+	}
+	return 0
+}
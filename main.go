@@ -10,9 +10,15 @@ import (
 	"fmt"
 	"os"
 	"strings"
+
+	"md5checker/checksum"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	showBanner()
 	reader := bufio.NewReader(os.Stdin)
 	for {
@@ -21,11 +27,17 @@ func main() {
 		choice = strings.TrimSpace(choice)
 		switch choice {
 		case "1":
-			NewMD5Hashes(false) // Add new files only
+			ctx, stop := interruptContext()
+			NewMD5Hashes(ctx, false, menuOptions()) // Add new files only
+			stop()
 		case "2":
-			NewMD5Hashes(true) // Regenerate all checksums
+			ctx, stop := interruptContext()
+			NewMD5Hashes(ctx, true, menuOptions()) // Regenerate all checksums
+			stop()
 		case "3":
-			TestMD5Hashes() // Verify
+			ctx, stop := interruptContext()
+			TestMD5Hashes(ctx, menuOptions()) // Verify
+			stop()
 		case "4":
 			ShowManual()
 		case "5":
@@ -41,6 +53,12 @@ func main() {
 	}
 }
 
+// menuOptions are the defaults used by the interactive menu. An encrypted
+// database is opened with the passphrase from the environment.
+func menuOptions() scanOptions {
+	return scanOptions{Options: checksum.Options{DatabaseKey: passphraseFromEnv()}}
+}
+
 func clearScreen() {
 	// Clear screen for Windows
 	fmt.Print("\033[H\033[2J")
@@ -128,9 +146,10 @@ func ShowManual() {
 	fmt.Println("NOTES:")
 	fmt.Println("────────────────────────────────────────────────────────────────")
 	fmt.Println("• Database file: checksums.json.gz (compressed)")
-	fmt.Println("• Excluded files: md5checker.exe, checksums.json.gz")
+	fmt.Println("• Excluded files: md5checker.exe, checksums.json.gz, checksums.history.jsonl")
 	fmt.Println("• For large directories, operations may take time")
 	fmt.Println("• Database is portable - can be copied/backed up")
+	fmt.Println("• Each verification is logged to checksums.history.jsonl")
 	fmt.Println()
 	fmt.Println("════════════════════════════════════════════════════════════════")
 	fmt.Println("Developed by Md. Shamsuzzaman")
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"md5checker/checksum"
+)
+
+// loadTree resolves a tree command argument: the path of a stored tree
+// file, or "current", a snapshot label or a database file, whose tree is
+// computed.
+func loadTree(opts scanOptions, arg string) (*checksum.Tree, string, error) {
+	if strings.HasSuffix(arg, ".tree") && fileExists(arg) {
+		tree, err := checksum.LoadTree(arg)
+		return tree, arg, err
+	}
+	path, err := resolveDatabase(opts, arg)
+	if err != nil {
+		return nil, "", err
+	}
+	checksumDB, err := checksum.LoadDatabase(path, opts.DatabaseKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return checksum.BuildTree(checksumDB), path, nil
+}
+
+// saveTree stores the Merkle tree of a database that was just saved and
+// returns its root hash. The tree of an encrypted database is not stored,
+// as it lists the directory names.
+func saveTree(opts scanOptions, checksumDB checksum.Database) string {
+	tree := checksum.BuildTree(checksumDB)
+	treePath := checksum.TreePath(opts.DatabasePath())
+	if opts.DatabaseKey != nil {
+		if err := os.Remove(treePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		return tree.Root
+	}
+	if err := tree.Save(treePath); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	return tree.Root
+}
+
+// runTree prints the root hash of the database, and the directory digests
+// down to the requested depth.
+func runTree(args []string) int {
+	fs := flag.NewFlagSet("tree", flag.ContinueOnError)
+	opts := addLocationFlags(fs)
+	depth := fs.Int("depth", 0, "also print the digests of directories down to this depth")
+	fs.Usage = func() {
+		fmt.Println("Usage: md5checker tree [options] [database]")
+		fmt.Println()
+		fmt.Println("Prints the Merkle root hash of 'current' (default), a snapshot label,")
+		fmt.Println("a database file or a stored .tree file.")
+		fmt.Println()
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	if err := parseLocationFlags(fs, opts, args); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		return 2
+	}
+	arg := "current"
+	if fs.NArg() > 0 {
+		arg = fs.Arg(0)
+	}
+	tree, path, err := loadTree(*opts, arg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	root := tree.Directories[""]
+	fmt.Printf("Tree: %s\n", path)
+	fmt.Printf("Files: %d in %d directories\n", root.Files, len(tree.Directories))
+	fmt.Printf("Root (%s): %s\n", tree.Algorithm, tree.Root)
+	if *depth > 0 {
+		fmt.Println("────────────────────────────────────────────────────────────────")
+		subdirs := tree.Subdirectories()
+		var printDir func(dir string, level int)
+		printDir = func(dir string, level int) {
+			for _, d := range subdirs[dir] {
+				node := tree.Directories[d]
+				fmt.Printf("%s%s  %s/ (%d files)\n", strings.Repeat("  ", level), node.Digest[:16], d, node.Files)
+				if level+1 < *depth {
+					printDir(d, level+1)
+				}
+			}
+		}
+		printDir("", 0)
+	}
+	return 0
+}
+
+// runDiffTree compares two Merkle trees and walks down to the directories
+// that differ. It exits with 1 when the trees differ.
+func runDiffTree(args []string) int {
+	fs := flag.NewFlagSet("diff-tree", flag.ContinueOnError)
+	opts := addLocationFlags(fs)
+	fs.Usage = func() {
+		fmt.Println("Usage: md5checker diff-tree [options] <A> <B>")
+		fmt.Println()
+		fmt.Println("Each side is 'current', a snapshot label, a database file or a stored .tree file.")
+		fmt.Println()
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	if err := parseLocationFlags(fs, opts, args); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	var trees [2]*checksum.Tree
+	var paths [2]string
+	for i, arg := range fs.Args() {
+		var err error
+		if trees[i], paths[i], err = loadTree(*opts, arg); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+	}
+	diffs, err := checksum.DiffTrees(trees[0], trees[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	fmt.Println("╔════════════════════════════════════════════════════════════════╗")
+	fmt.Println("║                     MERKLE TREE DIFF                           ║")
+	fmt.Println("╚════════════════════════════════════════════════════════════════╝")
+	fmt.Printf("  A: %s\n     root %s\n", paths[0], trees[0].Root)
+	fmt.Printf("  B: %s\n     root %s\n", paths[1], trees[1].Root)
+	fmt.Println("────────────────────────────────────────────────────────────────")
+	for _, d := range diffs {
+		name := d.Path + "/"
+		if d.Path == "" {
+			name = "(root)"
+		}
+		indent := strings.Repeat("  ", d.Depth)
+		switch {
+		case d.OnlyIn != "":
+			fmt.Printf("  %s%s only in %s\n", indent, name, d.OnlyIn)
+		case d.FilesDiffer:
+			fmt.Printf("  %s%s ≠ files differ here\n", indent, name)
+		default:
+			fmt.Printf("  %s%s ≠\n", indent, name)
+		}
+	}
+	if len(diffs) == 0 {
+		fmt.Println("✓ The root hashes match: both trees hold the same content.")
+		fmt.Println("════════════════════════════════════════════════════════════════")
+		return 0
+	}
+	fmt.Println("────────────────────────────────────────────────────────────────")
+	fmt.Println("⚠ The trees differ. Use 'md5checker diff' on the databases for the differing files.")
+	fmt.Println("════════════════════════════════════════════════════════════════")
+	return 1
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"md5checker/checksum"
+)
+
+// histogram is a cumulative histogram with fixed upper bounds, rendered in
+// the Prometheus text exposition format.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n", name, h.sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// serverMetrics collects counters for the verifications run by the API server.
+type serverMetrics struct {
+	mu               sync.Mutex
+	filesScanned     uint64
+	bytesHashed      uint64
+	verifyRuns       uint64
+	verifyFailures   uint64
+	running          bool
+	throughput       float64
+	categoryCounts   map[string]int
+	partialCounts    map[string]int
+	lastSuccess      time.Time
+	lastPartial      time.Time
+	databaseEntries  int
+	verifyDuration   *histogram
+	fileHashDuration *histogram
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		categoryCounts:   make(map[string]int),
+		partialCounts:    make(map[string]int),
+		verifyDuration:   newHistogram(1, 5, 15, 60, 300, 900, 3600, 14400),
+		fileHashDuration: newHistogram(0.001, 0.01, 0.1, 0.5, 1, 5, 30, 120),
+	}
+}
+
+func (m *serverMetrics) verifyStarted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running = true
+}
+
+// observeFile records one file hashed by a verification. Unreadable files
+// are not counted.
+func (m *serverMetrics) observeFile(p checksum.Progress) {
+	if p.Err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.filesScanned++
+	m.bytesHashed += uint64(p.Size)
+	m.fileHashDuration.observe(p.Elapsed.Seconds())
+}
+
+func (m *serverMetrics) observeVerify(report *checksum.VerifyReport, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running = false
+	m.verifyRuns++
+	if err != nil {
+		m.verifyFailures++
+		return
+	}
+	m.verifyDuration.observe(report.DurationSeconds)
+	if report.DurationSeconds > 0 {
+		m.throughput = float64(report.BytesHashed) / report.DurationSeconds
+	}
+	// A sampled, single-shard or scoped run says nothing about the rest of
+	// the tree, so it does not stand in for a full verification
+	if report.Partial() {
+		m.partialCounts = report.Summary()
+		m.lastPartial = time.Now()
+		return
+	}
+	m.categoryCounts = report.Summary()
+	m.lastSuccess = time.Now()
+	m.databaseEntries = report.UniqueChecksums
+}
+
+// write renders all metrics. databasePath is stat'ed to report the size of
+// the database on disk.
+func (m *serverMetrics) write(w io.Writer, databasePath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeMetric(w, "md5checker_files_scanned_total", "counter", "Files hashed by verification runs.", float64(m.filesScanned))
+	writeMetric(w, "md5checker_bytes_hashed_total", "counter", "Bytes hashed by verification runs.", float64(m.bytesHashed))
+	writeMetric(w, "md5checker_hash_throughput_bytes_per_second", "gauge", "Hash throughput of the last successful verification.", m.throughput)
+	writeMetric(w, "md5checker_verify_runs_total", "counter", "Verification runs started.", float64(m.verifyRuns))
+	writeMetric(w, "md5checker_verify_failures_total", "counter", "Verification runs that failed.", float64(m.verifyFailures))
+	running := 0.0
+	if m.running {
+		running = 1
+	}
+	writeMetric(w, "md5checker_verify_running", "gauge", "Whether a verification is currently running.", running)
+
+	for _, category := range checksum.Categories {
+		name := "md5checker_" + strings.ToLower(category) + "_files"
+		fmt.Fprintf(w, "# HELP %s Files reported as %s by the last successful verification, full or partial (sampled, single-shard or scoped).\n# TYPE %s gauge\n", name, category, name)
+		fmt.Fprintf(w, "%s{partial=\"false\"} %d\n", name, m.categoryCounts[category])
+		fmt.Fprintf(w, "%s{partial=\"true\"} %d\n", name, m.partialCounts[category])
+	}
+
+	writeMetric(w, "md5checker_last_successful_verify_timestamp_seconds", "gauge", "Unix time of the last successful full verification.", unixTime(m.lastSuccess))
+	writeMetric(w, "md5checker_last_partial_verify_timestamp_seconds", "gauge", "Unix time of the last successful sampled, single-shard or scoped verification.", unixTime(m.lastPartial))
+
+	writeMetric(w, "md5checker_database_entries", "gauge", "Unique checksums in the database at the last successful full verification.", float64(m.databaseEntries))
+	databaseSize := 0.0
+	if info, err := os.Stat(databasePath); err == nil {
+		databaseSize = float64(info.Size())
+	}
+	writeMetric(w, "md5checker_database_size_bytes", "gauge", "Size of the checksum database file.", databaseSize)
+
+	m.verifyDuration.write(w, "md5checker_verify_duration_seconds", "Duration of successful verification runs.")
+	m.fileHashDuration.write(w, "md5checker_file_hash_duration_seconds", "Time spent hashing individual files.")
+}
+
+// unixTime returns t in Unix seconds, 0 for the zero time.
+func unixTime(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.Unix())
+}
+
+func writeMetric(w io.Writer, name, metricType, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, metricType, name, value)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"md5checker/checksum"
+)
+
+// scrape renders the metrics and returns the value of every sample by name
+// and labels.
+func scrape(t *testing.T, m *serverMetrics) map[string]float64 {
+	t.Helper()
+	var out strings.Builder
+	m.write(&out, "")
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(strings.NewReader(out.String()))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("bad sample %q: %v", line, err)
+		}
+		values[line[:i]] = value
+	}
+	return values
+}
+
+// testReport returns a successful report with the given number of results
+// per category.
+func testReport(counts map[string]int) *checksum.VerifyReport {
+	report := &checksum.VerifyReport{Results: make(map[string][]checksum.Result), UniqueChecksums: 10, DurationSeconds: 2, BytesHashed: 100}
+	for category, n := range counts {
+		report.Results[category] = make([]checksum.Result, n)
+	}
+	return report
+}
+
+func TestMetricsPartialRuns(t *testing.T) {
+	m := newServerMetrics()
+	m.observeVerify(testReport(map[string]int{"OK": 9, "DELETED": 1}), nil)
+	full := scrape(t, m)
+	if full[`md5checker_ok_files{partial="false"}`] != 9 || full[`md5checker_deleted_files{partial="false"}`] != 1 {
+		t.Errorf("full run counts = %v", full)
+	}
+	if full["md5checker_last_successful_verify_timestamp_seconds"] == 0 || full["md5checker_last_partial_verify_timestamp_seconds"] != 0 {
+		t.Errorf("timestamps after a full run: %v", full)
+	}
+
+	// Sampled, single-shard and scoped runs keep the full run's gauges
+	sampled := testReport(map[string]int{"OK": 2, "MODIFIED": 1})
+	sampled.Sample = &checksum.SampleCoverage{FilesSampled: 3}
+	sampled.UniqueChecksums = 3
+	shard := testReport(map[string]int{"OK": 1})
+	shard.Shard = "docs"
+	scoped := testReport(map[string]int{"OK": 1})
+	scoped.Scope = []string{"docs"}
+	for _, report := range []*checksum.VerifyReport{shard, scoped, sampled} {
+		m.observeVerify(report, nil)
+	}
+	after := scrape(t, m)
+	for _, name := range []string{
+		`md5checker_ok_files{partial="false"}`,
+		`md5checker_deleted_files{partial="false"}`,
+		`md5checker_modified_files{partial="false"}`,
+		"md5checker_database_entries",
+		"md5checker_last_successful_verify_timestamp_seconds",
+	} {
+		if after[name] != full[name] {
+			t.Errorf("%s = %g after partial runs, want %g", name, after[name], full[name])
+		}
+	}
+	if after[`md5checker_modified_files{partial="true"}`] != 1 || after[`md5checker_ok_files{partial="true"}`] != 2 {
+		t.Errorf("partial run counts = %v", after)
+	}
+	if after["md5checker_last_partial_verify_timestamp_seconds"] == 0 {
+		t.Error("partial runs did not set their timestamp")
+	}
+	if after["md5checker_verify_runs_total"] != 4 {
+		t.Errorf("verify runs = %g, want 4", after["md5checker_verify_runs_total"])
+	}
+
+	m.observeVerify(nil, errors.New("interrupted"))
+	if failed := scrape(t, m); failed["md5checker_verify_failures_total"] != 1 || failed["md5checker_last_successful_verify_timestamp_seconds"] != full["md5checker_last_successful_verify_timestamp_seconds"] {
+		t.Errorf("after a failed run: %v", failed)
+	}
+}
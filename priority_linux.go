@@ -0,0 +1,40 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// ioprio_set(2) constants
+const (
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// setIdlePriority moves every thread of the process to the idle I/O
+// scheduling class and the lowest CPU priority. Both are per thread on
+// Linux, and threads created later inherit them from their creator.
+func setIdlePriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fmt.Errorf("could not list threads: %w", err)
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioClassIdle<<ioprioClassShift); errno != 0 {
+			return fmt.Errorf("could not set idle I/O priority: %w", errno)
+		}
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, 19); err != nil {
+			return fmt.Errorf("could not lower CPU priority: %w", err)
+		}
+	}
+	return nil
+}
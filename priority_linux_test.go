@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+const ioprioClassMask = 7 << ioprioClassShift
+
+// TestSetIdlePriority runs in a child process, since a lowered priority
+// cannot be raised again without privileges.
+func TestSetIdlePriority(t *testing.T) {
+	if os.Getenv("MD5CHECKER_PRIORITY_CHILD") == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestSetIdlePriority$")
+		cmd.Env = append(os.Environ(), "MD5CHECKER_PRIORITY_CHILD=1")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v\n%s", err, out)
+		}
+		return
+	}
+
+	if err := setIdlePriority(); err != nil {
+		t.Skipf("cannot change priorities here: %v", err)
+	}
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		ioprio, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(tid), 0)
+		if errno != 0 {
+			t.Fatalf("ioprio_get: %v", errno)
+		}
+		if class := (ioprio & ioprioClassMask) >> ioprioClassShift; class != ioprioClassIdle {
+			t.Errorf("thread %d has I/O class %d, want %d (idle)", tid, class, ioprioClassIdle)
+		}
+		// The raw getpriority(2) result is 20 - nice
+		prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, tid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nice := 20 - prio; nice != 19 {
+			t.Errorf("thread %d has nice value %d, want 19", tid, nice)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// setIdlePriority is only supported on Linux.
+func setIdlePriority() error {
+	return fmt.Errorf("idle priority is only supported on Linux")
+}
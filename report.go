@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"md5checker/checksum"
+)
+
+// reportFileName is the base name of the json and csv reports written next
+// to the database.
+const reportFileName = "checksums.report"
+
+// writeReports writes the verify report in every requested file format and
+// returns the paths written. The text format is printed, not written. The
+// report files list every path in the clear, so none are written for an
+// encrypted database.
+func writeReports(report *checksum.VerifyReport, opts scanOptions) ([]string, error) {
+	var written []string
+	for _, format := range opts.reportFormats {
+		if format == "text" {
+			continue
+		}
+		if opts.DatabaseKey != nil {
+			return written, fmt.Errorf("no %s report written, as report files are not encrypted and the database is", format)
+		}
+		path := filepath.Join(opts.DatabaseDir(), reportFileName+"."+format)
+		if err := writeReport(path, format, report); err != nil {
+			return written, fmt.Errorf("could not write %s report '%s': %w", format, path, err)
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
+
+func writeReport(path, format string, report *checksum.VerifyReport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if format == "csv" {
+		err = report.WriteCSV(f)
+	} else {
+		err = report.WriteJSON(f)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"md5checker/checksum"
+)
+
+// verifyJob tracks one verification run triggered through the API.
+type verifyJob struct {
+	ID         string                 `json:"ID"`
+	Status     string                 `json:"Status"` // running, done or failed
+	StartedAt  string                 `json:"StartedAt"`
+	FinishedAt string                 `json:"FinishedAt,omitempty"`
+	Error      string                 `json:"Error,omitempty"`
+	Summary    map[string]int         `json:"Summary,omitempty"`
+	Report     *checksum.VerifyReport `json:"-"`
+}
+
+// The read timeouts bound how long a client may take to send a request, so
+// a slow or stalled client cannot hold a connection open. On SIGINT or
+// SIGTERM, open requests get shutdownTimeout to finish.
+const (
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 30 * time.Second
+	idleTimeout       = 2 * time.Minute
+	shutdownTimeout   = 10 * time.Second
+)
+
+// keptJobs is the number of verification jobs kept for GET /api/verify/{id};
+// older jobs are forgotten, so a long-running server polled by cron does
+// not hold on to every report it produced.
+const keptJobs = 20
+
+// apiServer exposes the checksum database and verification runs over HTTP.
+// Only one verification runs at a time. Verifications are cancelled when
+// ctx is.
+type apiServer struct {
+	ctx     context.Context
+	token   string
+	opts    scanOptions
+	metrics *serverMetrics
+	wg      sync.WaitGroup
+
+	mu       sync.Mutex
+	nextID   int
+	jobs     map[string]*verifyJob
+	jobOrder []string
+	maxJobs  int
+	running  *verifyJob
+	latest   *verifyJob
+}
+
+func newAPIServer(ctx context.Context, token string, opts scanOptions) *apiServer {
+	return &apiServer{
+		ctx:     ctx,
+		token:   token,
+		opts:    opts,
+		metrics: newServerMetrics(),
+		jobs:    make(map[string]*verifyJob),
+		maxJobs: keptJobs,
+	}
+}
+
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "address to listen on")
+	token := fs.String("token", os.Getenv("MD5CHECKER_TOKEN"), "bearer token required by the API (default $MD5CHECKER_TOKEN)")
+	opts := addScanFlags(fs)
+	if err := parseScanFlags(fs, opts, args); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		return 2
+	}
+	// Resolve the root once so a later change of directory cannot move it
+	opts.Root = opts.RootPath()
+
+	// SIGINT and SIGTERM stop the server and cancel a running verification
+	ctx, stop := interruptContext()
+	defer stop()
+	server := newAPIServer(ctx, *token, *opts)
+	httpServer := &http.Server{
+		Addr:              *addr,
+		Handler:           server.routes(),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	fmt.Printf("Serving integrity API for '%s' on http://%s\n", opts.Root, *addr)
+	if *token == "" {
+		fmt.Println("Warning: no token set, the API is unauthenticated.")
+	}
+	if opts.Key == nil && fileExists(checksum.SignaturePath(opts.DatabasePath())) {
+		fmt.Println("Warning: the database is signed but its signature is not checked; pass -key <public key> to check it.")
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.ListenAndServe()
+	}()
+	select {
+	case err := <-serveErr:
+		fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
+		return 1
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
+	}
+	server.wg.Wait()
+	fmt.Println("Server stopped.")
+	return 0
+}
+
+func (s *apiServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/health", s.handleHealth)
+	mux.HandleFunc("POST /api/verify", s.requireToken(s.handleStartVerify))
+	mux.HandleFunc("GET /api/verify/{id}", s.requireToken(s.handleGetJob))
+	mux.HandleFunc("GET /api/results/latest", s.requireToken(s.handleLatestResults))
+	mux.HandleFunc("GET /api/summary", s.requireToken(s.handleSummary))
+	mux.HandleFunc("GET /api/lookup", s.requireToken(s.handleLookup))
+	mux.HandleFunc("GET /api/duplicates", s.requireToken(s.handleDuplicates))
+	mux.HandleFunc("GET /metrics", s.requireToken(s.handleMetrics))
+	return mux
+}
+
+// requireToken rejects requests without the configured bearer token. It is a
+// no-op when no token is configured.
+func (s *apiServer) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+				writeError(w, http.StatusUnauthorized, "missing or invalid token")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+func (s *apiServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"Status": "ok", "Version": Version})
+}
+
+func (s *apiServer) handleStartVerify(w http.ResponseWriter, r *http.Request) {
+	job, err := s.startVerify()
+	if err != nil {
+		writeJSON(w, http.StatusConflict, map[string]any{"Error": err.Error(), "Job": job})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// startVerify launches a verification in the background. If one is already
+// running it returns that job together with an error.
+func (s *apiServer) startVerify() (verifyJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running != nil {
+		return *s.running, errors.New("a verification is already running")
+	}
+
+	s.nextID++
+	job := &verifyJob{
+		ID:        fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405"), s.nextID),
+		Status:    "running",
+		StartedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	s.jobs[job.ID] = job
+	s.jobOrder = append(s.jobOrder, job.ID)
+	// The latest completed job stays available through /api/results/latest
+	for len(s.jobOrder) > s.maxJobs {
+		delete(s.jobs, s.jobOrder[0])
+		s.jobOrder = s.jobOrder[1:]
+	}
+	s.running = job
+	s.metrics.verifyStarted()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		verifier := &checksum.Verifier{Options: s.opts.Options, Progress: s.metrics.observeFile}
+		report, err := verifier.Verify(s.ctx)
+		s.metrics.observeVerify(report, err)
+		if err == nil && s.opts.DatabaseKey == nil {
+			if _, historyErr := appendHistory(s.opts.historyPath(), report); historyErr != nil {
+				fmt.Fprintf(os.Stderr, "Warning: could not record verification history: %v\n", historyErr)
+			}
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		job.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+		if err != nil {
+			job.Status = "failed"
+			job.Error = err.Error()
+		} else {
+			job.Status = "done"
+			job.Report = report
+			job.Summary = report.Summary()
+			s.latest = job
+		}
+		s.running = nil
+	}()
+	return *job, nil
+}
+
+func (s *apiServer) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	job, exists := s.jobs[r.PathValue("id")]
+	var snapshot verifyJob
+	if exists {
+		snapshot = *job
+	}
+	s.mu.Unlock()
+
+	if !exists {
+		writeError(w, http.StatusNotFound, "unknown job")
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+func (s *apiServer) latestJob() *verifyJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latest
+}
+
+func (s *apiServer) handleLatestResults(w http.ResponseWriter, r *http.Request) {
+	job := s.latestJob()
+	if job == nil {
+		writeError(w, http.StatusNotFound, "no verification has completed yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"Job": job, "Report": job.Report})
+}
+
+func (s *apiServer) handleSummary(w http.ResponseWriter, r *http.Request) {
+	job := s.latestJob()
+	if job == nil {
+		writeError(w, http.StatusNotFound, "no verification has completed yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"JobID":           job.ID,
+		"FinishedAt":      job.FinishedAt,
+		"FilesChecked":    job.Report.FilesChecked,
+		"UniqueChecksums": job.Report.UniqueChecksums,
+		"Discrepancies":   job.Report.Discrepancies(),
+		"Counts":          job.Summary,
+	})
+}
+
+// handleLookup finds a database entry by relative path (?path=) or by
+// content hash (?hash=).
+func (s *apiServer) handleLookup(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	hash := strings.ToLower(r.URL.Query().Get("hash"))
+	if path == "" && hash == "" {
+		writeError(w, http.StatusBadRequest, "either path or hash is required")
+		return
+	}
+
+	infoData, exists, err := s.lookupEntry(path, hash)
+	switch {
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	case exists:
+		writeJSON(w, http.StatusOK, infoData)
+	case hash != "":
+		writeError(w, http.StatusNotFound, "hash not found in database")
+	default:
+		writeError(w, http.StatusNotFound, "path not found in database")
+	}
+}
+
+// lookupEntry finds the entry of a content hash, or else of a relative
+// path. Binary databases are searched through their indexes instead of
+// being loaded whole.
+func (s *apiServer) lookupEntry(path, hash string) (checksum.InfoData, bool, error) {
+	checksumFilePath := s.opts.DatabasePath()
+	relPath := filepath.FromSlash(filepath.Clean(path))
+	if format, _ := checksum.DetectFormat(checksumFilePath); format == checksum.FormatBinary {
+		binaryDB, err := checksum.OpenBinaryDatabase(checksumFilePath)
+		if err != nil {
+			return checksum.InfoData{}, false, err
+		}
+		defer binaryDB.Close()
+		if hash != "" {
+			return binaryDB.Get(hash)
+		}
+		return binaryDB.Lookup(relPath)
+	}
+
+	checksumDB, err := checksum.LoadDatabase(checksumFilePath, s.opts.DatabaseKey)
+	if err != nil {
+		return checksum.InfoData{}, false, err
+	}
+	if hash != "" {
+		infoData, exists := checksumDB[hash]
+		return infoData, exists, nil
+	}
+	infoData, exists := checksumDB.Lookup(relPath)
+	return infoData, exists, nil
+}
+
+// handleDuplicates lists every content hash that is stored under more than
+// one path.
+func (s *apiServer) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	checksumDB, err := checksum.LoadDatabase(s.opts.DatabasePath(), s.opts.DatabaseKey)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	duplicates := []checksum.InfoData{}
+	for _, infoData := range checksumDB {
+		if len(infoData.RelativePaths) > 1 {
+			duplicates = append(duplicates, infoData)
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i].ContentMD5 < duplicates[j].ContentMD5
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"Groups": len(duplicates), "Duplicates": duplicates})
+}
+
+func (s *apiServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.metrics.write(w, s.opts.DatabasePath())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"Error": message})
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"md5checker/checksum"
+	"md5checker/checksum/checksumtest"
+)
+
+const testToken = "secret"
+
+// newTestServer creates a tree with a database and serves the API for it.
+// Extra flags are passed on as to 'md5checker serve'. The returned function
+// cancels the server context; it is called, and running verifications
+// waited for, when the test ends.
+func newTestServer(t *testing.T, token string, flags ...string) (*apiServer, *httptest.Server, context.CancelFunc) {
+	t.Helper()
+	t.Setenv(passphraseEnv, "")
+	dir := checksumtest.WriteTree(t, map[string]string{
+		"a.txt":       "alpha",
+		"b.txt":       "bravo",
+		"sub/c.txt":   "charlie",
+		"sub/dup.txt": "alpha",
+	})
+
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	opts := addScanFlags(fs)
+	if err := parseScanFlags(fs, opts, append([]string{"-root", dir}, flags...)); err != nil {
+		t.Fatal(err)
+	}
+	// The database is built without the rate limits of the flags
+	checksumDB := make(checksum.Database)
+	scanner := &checksum.Scanner{Options: opts.Options}
+	scanner.Options.MaxBytesPerSec, scanner.Options.MaxFilesPerSec = 0, 0
+	if _, err := scanner.Update(context.Background(), checksumDB, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := checksumDB.Save(opts.DatabasePath(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	server := newAPIServer(ctx, token, *opts)
+	ts := httptest.NewServer(server.routes())
+	t.Cleanup(func() {
+		cancel()
+		server.wg.Wait()
+		ts.Close()
+	})
+	return server, ts, cancel
+}
+
+// request sends a request with the test token, if given, and decodes the
+// JSON response into out when it is not nil.
+func request(t *testing.T, ts *httptest.Server, method, path, token string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// waitForJob polls a verification until it is no longer running.
+func waitForJob(t *testing.T, ts *httptest.Server, id string) verifyJob {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		var job verifyJob
+		if status := request(t, ts, "GET", "/api/verify/"+id, testToken, &job); status != http.StatusOK {
+			t.Fatalf("GET job %s: status %d", id, status)
+		}
+		if job.Status != "running" {
+			return job
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("verification %s did not finish", id)
+	return verifyJob{}
+}
+
+func TestServerToken(t *testing.T) {
+	_, ts, _ := newTestServer(t, testToken)
+
+	tests := []struct {
+		path  string
+		token string
+		want  int
+	}{
+		{"/api/health", "", http.StatusOK},
+		{"/api/summary", "", http.StatusUnauthorized},
+		{"/api/summary", "wrong", http.StatusUnauthorized},
+		{"/api/summary", testToken, http.StatusNotFound},
+		{"/api/lookup?path=a.txt", "", http.StatusUnauthorized},
+		{"/api/lookup?path=a.txt", testToken, http.StatusOK},
+		{"/metrics", "", http.StatusUnauthorized},
+		{"/metrics", testToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := request(t, ts, "GET", tt.path, tt.token, nil); got != tt.want {
+			t.Errorf("GET %s with token %q: status %d, want %d", tt.path, tt.token, got, tt.want)
+		}
+	}
+	if got := request(t, ts, "POST", "/api/verify", "", nil); got != http.StatusUnauthorized {
+		t.Errorf("POST /api/verify without token: status %d, want %d", got, http.StatusUnauthorized)
+	}
+}
+
+func TestServerWithoutToken(t *testing.T) {
+	_, ts, _ := newTestServer(t, "")
+	if got := request(t, ts, "GET", "/api/lookup?path=a.txt", "", nil); got != http.StatusOK {
+		t.Errorf("GET /api/lookup without a configured token: status %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestServerRoutes(t *testing.T) {
+	_, ts, _ := newTestServer(t, testToken)
+
+	var health map[string]string
+	if status := request(t, ts, "GET", "/api/health", "", &health); status != http.StatusOK || health["Status"] != "ok" {
+		t.Errorf("health: status %d, body %v", status, health)
+	}
+
+	var job verifyJob
+	if status := request(t, ts, "POST", "/api/verify", testToken, &job); status != http.StatusAccepted {
+		t.Fatalf("POST /api/verify: status %d, want %d", status, http.StatusAccepted)
+	}
+	if job = waitForJob(t, ts, job.ID); job.Status != "done" {
+		t.Fatalf("verification %s: status %s (%s), want done", job.ID, job.Status, job.Error)
+	}
+	if job.Summary["OK"] != 4 {
+		t.Errorf("summary of job = %v, want 4 OK files", job.Summary)
+	}
+
+	var summary struct {
+		JobID         string
+		FilesChecked  int
+		Discrepancies int
+	}
+	if status := request(t, ts, "GET", "/api/summary", testToken, &summary); status != http.StatusOK {
+		t.Fatalf("GET /api/summary: status %d", status)
+	}
+	if summary.JobID != job.ID || summary.FilesChecked != 4 || summary.Discrepancies != 0 {
+		t.Errorf("summary = %+v, want job %s with 4 files and no discrepancies", summary, job.ID)
+	}
+	if status := request(t, ts, "GET", "/api/results/latest", testToken, nil); status != http.StatusOK {
+		t.Errorf("GET /api/results/latest: status %d", status)
+	}
+
+	var entry checksum.InfoData
+	if status := request(t, ts, "GET", "/api/lookup?path=sub/c.txt", testToken, &entry); status != http.StatusOK {
+		t.Fatalf("lookup by path: status %d", status)
+	}
+	var byHash checksum.InfoData
+	if status := request(t, ts, "GET", "/api/lookup?hash="+strings.ToUpper(entry.ContentMD5), testToken, &byHash); status != http.StatusOK || byHash.ContentMD5 != entry.ContentMD5 {
+		t.Errorf("lookup by hash: status %d, entry %s, want %s", status, byHash.ContentMD5, entry.ContentMD5)
+	}
+
+	var duplicates struct{ Groups int }
+	if status := request(t, ts, "GET", "/api/duplicates", testToken, &duplicates); status != http.StatusOK || duplicates.Groups != 1 {
+		t.Errorf("duplicates: status %d, %d groups, want 1", status, duplicates.Groups)
+	}
+
+	for path, want := range map[string]int{
+		"/api/lookup":                 http.StatusBadRequest,
+		"/api/lookup?path=missing":    http.StatusNotFound,
+		"/api/lookup?hash=0123456789": http.StatusNotFound,
+		"/api/verify/unknown":         http.StatusNotFound,
+	} {
+		if got := request(t, ts, "GET", path, testToken, nil); got != want {
+			t.Errorf("GET %s: status %d, want %d", path, got, want)
+		}
+	}
+}
+
+func TestServerRejectsSecondVerify(t *testing.T) {
+	// At one file per second the first verification is still running
+	server, ts, cancel := newTestServer(t, testToken, "-max-files", "1")
+
+	var first verifyJob
+	if status := request(t, ts, "POST", "/api/verify", testToken, &first); status != http.StatusAccepted {
+		t.Fatalf("first POST /api/verify: status %d, want %d", status, http.StatusAccepted)
+	}
+	var conflict struct {
+		Error string
+		Job   verifyJob
+	}
+	if status := request(t, ts, "POST", "/api/verify", testToken, &conflict); status != http.StatusConflict {
+		t.Fatalf("second POST /api/verify: status %d, want %d", status, http.StatusConflict)
+	}
+	if conflict.Job.ID != first.ID || conflict.Error == "" {
+		t.Errorf("conflict = %+v, want the running job %s and an error", conflict, first.ID)
+	}
+
+	// Cancelling the server context stops the running verification
+	cancel()
+	server.wg.Wait()
+	if job := waitForJob(t, ts, first.ID); job.Status != "failed" {
+		t.Errorf("cancelled verification: status %s, want failed", job.Status)
+	}
+	if status := request(t, ts, "POST", "/api/verify", testToken, nil); status != http.StatusAccepted {
+		t.Errorf("POST /api/verify after the cancelled run: status %d, want %d", status, http.StatusAccepted)
+	}
+}
+
+func TestServerForgetsOldJobs(t *testing.T) {
+	server, ts, _ := newTestServer(t, testToken)
+	server.maxJobs = 2
+
+	var ids []string
+	for range 3 {
+		var job verifyJob
+		if status := request(t, ts, "POST", "/api/verify", testToken, &job); status != http.StatusAccepted {
+			t.Fatalf("POST /api/verify: status %d, want %d", status, http.StatusAccepted)
+		}
+		waitForJob(t, ts, job.ID)
+		ids = append(ids, job.ID)
+	}
+	if got := request(t, ts, "GET", "/api/verify/"+ids[0], testToken, nil); got != http.StatusNotFound {
+		t.Errorf("GET the oldest job: status %d, want %d", got, http.StatusNotFound)
+	}
+	for _, id := range ids[1:] {
+		if got := request(t, ts, "GET", "/api/verify/"+id, testToken, nil); got != http.StatusOK {
+			t.Errorf("GET job %s: status %d, want %d", id, got, http.StatusOK)
+		}
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.jobs) != 2 {
+		t.Errorf("server holds %d jobs, want 2", len(server.jobs))
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"md5checker/checksum"
+)
+
+// parseShardsFlag checks the value of -shards: 'dir', a number of paths
+// per shard, or 'off'.
+func parseShardsFlag(s string) error {
+	if s == checksum.ShardByDirectory || s == "off" {
+		return nil
+	}
+	if n, err := strconv.Atoi(s); err != nil || n < 1 {
+		return fmt.Errorf("invalid shard layout '%s', expected 'dir', a number of paths per shard or 'off'", s)
+	}
+	return nil
+}
+
+// shardLayout returns the shard mode and the paths per shard add and
+// regenerate save the database with: those of -shards, or else those of
+// the existing database. An empty mode saves a single database file.
+func (o scanOptions) shardLayout() (string, int) {
+	switch o.shards {
+	case "off":
+		return "", 0
+	case checksum.ShardByDirectory:
+		return checksum.ShardByDirectory, 0
+	case "":
+		if sharded, _ := checksum.IsShardedDatabase(o.DatabasePath()); sharded {
+			if index, err := checksum.ReadShardIndex(o.DatabasePath()); err == nil {
+				return index.Mode, index.MaxEntries
+			}
+		}
+		return "", 0
+	}
+	n, _ := strconv.Atoi(o.shards)
+	return checksum.ShardByEntries, n
+}
+
+// saveDatabase saves the database of an add or regenerate run, as sidecar
+// manifests, or in shards if requested or if it was sharded before. Only
+// changed shards and sidecars are rewritten.
+func saveDatabase(opts scanOptions, checksumDB checksum.Database, format string) error {
+	if opts.Sidecars {
+		written, total, err := checksumDB.SaveSidecars(context.Background(), opts.Options)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  Sidecars: %d of %d rewritten\n", written, total)
+		return nil
+	}
+	checksumFilePath := opts.DatabasePath()
+	mode, maxEntries := opts.shardLayout()
+	if mode == "" {
+		wasSharded, _ := checksum.IsShardedDatabase(checksumFilePath)
+		if err := checksumDB.SaveAs(checksumFilePath, format, opts.DatabaseKey, opts.Selection); err != nil {
+			return err
+		}
+		if wasSharded {
+			return checksum.RemoveShards(checksumFilePath)
+		}
+		return nil
+	}
+	written, total, err := checksumDB.SaveSharded(checksumFilePath, mode, maxEntries, format, opts.DatabaseKey, opts.Selection)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  Shards: %d of %d rewritten\n", written, total)
+	return nil
+}
+
+// runShards lists the shards of a sharded database.
+func runShards(args []string) int {
+	fs := flag.NewFlagSet("shards", flag.ContinueOnError)
+	opts := addLocationFlags(fs)
+	fs.Usage = func() {
+		fmt.Println("Usage: md5checker shards [options]")
+		fmt.Println()
+		fmt.Println("Lists the shards of a database saved with 'add -shards'.")
+		fmt.Println()
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	if err := parseLocationFlags(fs, opts, args); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		return 2
+	}
+	checksumFilePath := opts.DatabasePath()
+	if sharded, err := checksum.IsShardedDatabase(checksumFilePath); err != nil || !sharded {
+		fmt.Printf("'%s' is not a sharded database.\n", checksumFilePath)
+		return 1
+	}
+	index, err := checksum.ReadShardIndex(checksumFilePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	layout := "one shard per top-level directory"
+	if index.Mode == checksum.ShardByEntries {
+		layout = fmt.Sprintf("shards of up to %d paths", index.MaxEntries)
+	}
+	fmt.Printf("%s: %s, %s format\n", checksumFilePath, layout, index.Format)
+	fmt.Printf("%-30s  %10s  %10s  %s\n", "Shard", "Paths", "Size", "File")
+	fmt.Println("────────────────────────────────────────────────────────────────")
+	for i, shard := range index.Shards {
+		key := shard.Key
+		if key == "" {
+			key = "."
+		}
+		size := "missing"
+		if info, err := os.Stat(checksum.ShardPath(checksumFilePath, index, i)); err == nil {
+			size = checksum.FormatBytes(info.Size())
+		}
+		fmt.Printf("%-30s  %10d  %10s  %s\n", key, shard.Paths, size, shard.File)
+	}
+	return 0
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"md5checker/checksum"
+)
+
+// runKeygen writes a new signing key. Ed25519 keys are written as a private
+// key file and a .pub public key file; HMAC secrets as a single file. The
+// path must be given, so a key that can sign is not left in the scanned
+// tree by default.
+func runKeygen(args []string) int {
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	keyType := fs.String("type", checksum.KeyEd25519, "key type: '"+checksum.KeyEd25519+"' or '"+checksum.KeyHMAC+"'")
+	out := fs.String("out", "", "path of the private key or secret, best outside the scanned tree (required); the Ed25519 public key gets a .pub suffix")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *out == "" {
+		fmt.Fprintln(os.Stderr, "-out is required: write the key somewhere off the scanned tree, e.g. -out /mnt/offline/md5checker.key")
+		return 2
+	}
+
+	key, err := checksum.GenerateKey(*keyType)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	private, err := key.PrivatePEM()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if err := writeKeyFile(*out, private, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Printf("✓ %s key %s written to %s\n", key.Type, key.ID(), *out)
+	if key.Type == checksum.KeyEd25519 {
+		public, err := key.PublicPEM()
+		if err == nil {
+			err = writeKeyFile(*out+".pub", public, 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Printf("✓ Public key written to %s.pub\n", *out)
+		fmt.Println("Keep the private key off the scanned machine; the public key is enough to verify.")
+	} else {
+		fmt.Println("Keep the secret off the scanned machine and only provide it to sign and verify.")
+	}
+	return 0
+}
+
+// writeKeyFile writes a key file, refusing to replace an existing one.
+func writeKeyFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("key file '%s' already exists", path)
+		}
+		return fmt.Errorf("could not create key file '%s': %w", path, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write key file '%s': %w", path, err)
+	}
+	return f.Close()
+}
+
+// parseKeyFlags parses the flags of the sign and verify-db commands and
+// loads the key.
+func parseKeyFlags(fs *flag.FlagSet, args []string) (scanOptions, *checksum.Key, error) {
+	opts := addLocationFlags(fs)
+	keyPath := fs.String("key", "", "Ed25519 or HMAC key file (required)")
+	if err := parseLocationFlags(fs, opts, args); err != nil {
+		return *opts, nil, err
+	}
+	if *keyPath == "" {
+		return *opts, nil, fmt.Errorf("-key is required")
+	}
+	key, err := checksum.LoadKey(*keyPath)
+	return *opts, key, err
+}
+
+// runSign signs the database with a private key or HMAC secret.
+func runSign(args []string) int {
+	fs := flag.NewFlagSet("sign", flag.ContinueOnError)
+	opts, key, err := parseKeyFlags(fs, args)
+	if err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		return 2
+	}
+	checksumFilePath := opts.DatabasePath()
+	if err := checksum.SignDatabase(checksumFilePath, key); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Printf("✓ Signed %s with %s key %s\n", checksumFilePath, key.Type, key.ID())
+	return 0
+}
+
+// runVerifyDB checks the database signature. It exits with 0 when the
+// signature is valid and 1 when it is missing or does not match.
+func runVerifyDB(args []string) int {
+	fs := flag.NewFlagSet("verify-db", flag.ContinueOnError)
+	opts, key, err := parseKeyFlags(fs, args)
+	if err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		return 2
+	}
+	checksumFilePath := opts.DatabasePath()
+	signature, err := checksum.CheckDatabaseSignature(checksumFilePath, key)
+	if err != nil {
+		fmt.Printf("⚠ %s: %v\n", checksumFilePath, err)
+		return 1
+	}
+	fmt.Printf("✓ %s: signature valid (%s key %s, signed %s)\n", checksumFilePath, signature.Type, signature.KeyID, signature.SignedAt)
+	return 0
+}
+
+// checkSignatureBeforeUpdate refuses to add to a signed database whose
+// signature does not match, which would sign over a tampered database.
+// Regenerating rehashes every file, so it only warns.
+func checkSignatureBeforeUpdate(opts scanOptions, regenerateAll bool) bool {
+	checksumFilePath := opts.DatabasePath()
+	if opts.Key == nil {
+		return true
+	}
+	if _, err := os.Stat(checksumFilePath); err != nil {
+		return true
+	}
+	_, err := checksum.CheckDatabaseSignature(checksumFilePath, opts.Key)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, checksum.ErrNotSigned):
+		if opts.Key.CanSign() {
+			fmt.Println("The database is not signed yet; it will be signed after this run.")
+		}
+		return true
+	case regenerateAll:
+		fmt.Printf("⚠ Warning: %v. Regenerating and signing it again.\n", err)
+		return true
+	}
+	fmt.Printf("⚠ Refusing to add to the database: %v.\n", err)
+	fmt.Println("Check the database, then regenerate it or sign it again with 'md5checker sign'.")
+	return false
+}
+
+// signAfterSave signs the database that was just saved, or warns that its
+// signature is now stale.
+func signAfterSave(opts scanOptions) {
+	checksumFilePath := opts.DatabasePath()
+	switch {
+	case opts.Key != nil && opts.Key.CanSign():
+		if err := checksum.SignDatabase(checksumFilePath, opts.Key); err != nil {
+			fmt.Printf("Error signing checksum database: %v\n", err)
+			return
+		}
+		fmt.Printf("✓ Database signed with %s key %s\n", opts.Key.Type, opts.Key.ID())
+	case fileExists(checksum.SignaturePath(checksumFilePath)):
+		fmt.Println("⚠ The database changed and its signature no longer matches; sign it again with 'md5checker sign -key <private key>'.")
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"md5checker/checksum"
+)
+
+// snapshotDirName is the directory next to the database that holds labelled
+// snapshots of it.
+const snapshotDirName = "checksums.snapshots"
+
+// snapshotExts maps each database format to the extension of its
+// snapshots.
+var snapshotExts = map[string]string{
+	checksum.FormatJSON:   ".json.gz",
+	checksum.FormatBinary: ".bin",
+}
+
+func snapshotPath(opts scanOptions, label, format string) string {
+	return filepath.Join(opts.DatabaseDir(), snapshotDirName, label+snapshotExts[format])
+}
+
+// findSnapshot returns the path of the snapshot with the given label in
+// whichever format it was saved, or "" when there is none.
+func findSnapshot(opts scanOptions, label string) string {
+	for _, format := range []string{checksum.FormatJSON, checksum.FormatBinary} {
+		if path := snapshotPath(opts, label, format); fileExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// snapshotLabel returns the label of a file in the snapshot directory.
+func snapshotLabel(name string) (string, bool) {
+	for _, ext := range snapshotExts {
+		if label, ok := strings.CutSuffix(name, ext); ok && label != "" {
+			return label, true
+		}
+	}
+	return "", false
+}
+
+// listSnapshotFiles returns the paths of the snapshots of the database,
+// sorted by name.
+func listSnapshotFiles(opts scanOptions) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(opts.DatabaseDir(), snapshotDirName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read snapshot directory: %w", err)
+	}
+	var paths []string
+	for _, entry := range entries {
+		if _, ok := snapshotLabel(entry.Name()); ok && !entry.IsDir() {
+			paths = append(paths, filepath.Join(opts.DatabaseDir(), snapshotDirName, entry.Name()))
+		}
+	}
+	return paths, nil
+}
+
+func validSnapshotLabel(label string) bool {
+	return label != "" && label != "." && label != ".." && !strings.ContainsAny(label, `/\:`)
+}
+
+// resolveDatabase turns a diff argument into a database path. The argument
+// may be "current", the label of a snapshot or the path to a database file.
+func resolveDatabase(opts scanOptions, arg string) (string, error) {
+	if arg == "current" {
+		return opts.DatabasePath(), nil
+	}
+	if validSnapshotLabel(arg) {
+		if path := findSnapshot(opts, arg); path != "" {
+			return path, nil
+		}
+	}
+	if fileExists(arg) {
+		return arg, nil
+	}
+	return "", fmt.Errorf("'%s' is neither a snapshot label nor a database file", arg)
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+func runSnapshot(args []string) int {
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	opts := addLocationFlags(fs)
+	force := fs.Bool("force", false, "overwrite an existing snapshot with the same label")
+	fs.Usage = func() {
+		fmt.Println("Usage:")
+		fmt.Println("  md5checker snapshot create <label>   Snapshot the current database")
+		fmt.Println("  md5checker snapshot list             List snapshots")
+		fmt.Println("  md5checker snapshot delete <label>   Delete a snapshot")
+		fmt.Println()
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	if err := parseLocationFlags(fs, opts, args); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		return 2
+	}
+
+	rest := fs.Args()
+	subcommand := "list"
+	if len(rest) > 0 {
+		subcommand, rest = rest[0], rest[1:]
+	}
+
+	var err error
+	switch {
+	case subcommand == "list":
+		err = listSnapshots(*opts)
+	case subcommand == "create" && len(rest) == 1:
+		err = createSnapshot(*opts, rest[0], *force)
+	case subcommand == "delete" && len(rest) == 1:
+		err = deleteSnapshot(*opts, rest[0])
+	default:
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// createSnapshot copies the current database to the snapshot directory
+// under label. The database is parsed first so a corrupt file is never
+// snapshotted.
+func createSnapshot(opts scanOptions, label string, force bool) error {
+	if !validSnapshotLabel(label) {
+		return fmt.Errorf("invalid snapshot label '%s'", label)
+	}
+	checksumFilePath := opts.DatabasePath()
+	checksumDB, err := checksum.LoadDatabase(checksumFilePath, opts.DatabaseKey)
+	if err != nil {
+		return err
+	}
+
+	// The shards of a sharded database change with it, so its snapshot is
+	// a single JSON file
+	sharded, _ := checksum.IsShardedDatabase(checksumFilePath)
+	format := checksum.FormatJSON
+	if !sharded {
+		if format, err = checksum.DetectFormat(checksumFilePath); err != nil {
+			return err
+		}
+	}
+	target := snapshotPath(opts, label, format)
+	existing := findSnapshot(opts, label)
+	if existing != "" && !force {
+		return fmt.Errorf("snapshot '%s' already exists (use -force to overwrite)", label)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("could not create snapshot directory: %w", err)
+	}
+	if sharded {
+		selection, err := checksum.ReadSelection(checksumFilePath, opts.DatabaseKey)
+		if err != nil {
+			return err
+		}
+		if err := checksumDB.SaveAs(target, format, opts.DatabaseKey, selection); err != nil {
+			return fmt.Errorf("could not write snapshot: %w", err)
+		}
+	} else if err := copyFile(checksumFilePath, target); err != nil {
+		return fmt.Errorf("could not write snapshot: %w", err)
+	}
+	// A snapshot overwritten in another format leaves no file behind
+	if existing != "" && existing != target {
+		if err := os.Remove(existing); err != nil {
+			return fmt.Errorf("could not remove the old snapshot: %w", err)
+		}
+	}
+
+	fmt.Printf("✓ Snapshot '%s' created with %d unique checksums.\n", label, len(checksumDB))
+	fmt.Printf("  Saved to: %s\n", target)
+	return nil
+}
+
+func listSnapshots(opts scanOptions) error {
+	paths, err := listSnapshotFiles(opts)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		fmt.Println("No snapshots found.")
+		return nil
+	}
+
+	sort.Strings(paths)
+	fmt.Printf("  %-30s %-7s %-21s %12s\n", "Label", "Format", "Created", "Size")
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		label, _ := snapshotLabel(filepath.Base(path))
+		format, _ := checksum.DetectFormat(path)
+		fmt.Printf("  %-30s %-7s %-21s %12d\n", label, format, info.ModTime().UTC().Format(time.RFC3339), info.Size())
+	}
+	return nil
+}
+
+func deleteSnapshot(opts scanOptions, label string) error {
+	if !validSnapshotLabel(label) {
+		return fmt.Errorf("invalid snapshot label '%s'", label)
+	}
+	path := findSnapshot(opts, label)
+	if path == "" {
+		return fmt.Errorf("could not delete snapshot '%s': it does not exist", label)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("could not delete snapshot '%s': %w", label, err)
+	}
+	fmt.Printf("✓ Snapshot '%s' deleted.\n", label)
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// runDiff classifies the paths of one database against another without
+// reading any file on disk. It exits with 1 when they differ, like verify.
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	opts := addLocationFlags(fs)
+	fs.Usage = func() {
+		fmt.Println("Usage: md5checker diff [options] <old> <new>")
+		fmt.Println()
+		fmt.Println("Each side is 'current', a snapshot label or the path to a database file.")
+		fmt.Println()
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	if err := parseLocationFlags(fs, opts, args); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	var databases [2]checksum.Database
+	var paths [2]string
+	for i, arg := range fs.Args() {
+		path, err := resolveDatabase(*opts, arg)
+		if err == nil {
+			databases[i], err = checksum.LoadDatabase(path, opts.DatabaseKey)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+		paths[i] = path
+	}
+
+	results := checksum.ClassifyDatabases(databases[0], databases[1])
+
+	fmt.Println("╔════════════════════════════════════════════════════════════════╗")
+	fmt.Println("║                   DATABASE DIFF SUMMARY                        ║")
+	fmt.Println("╚════════════════════════════════════════════════════════════════╝")
+	fmt.Printf("  Old: %s (%d unique checksums)\n", paths[0], len(databases[0]))
+	fmt.Printf("  New: %s (%d unique checksums)\n", paths[1], len(databases[1]))
+	fmt.Println("────────────────────────────────────────────────────────────────")
+
+	checksum.WriteResults(os.Stdout, results)
+
+	fmt.Println("────────────────────────────────────────────────────────────────")
+	total := checksum.CountDiscrepancies(results)
+	if total == 0 {
+		fmt.Println("✓ Both databases describe the same files.")
+	} else {
+		fmt.Printf("⚠ Found %d differences between the databases.\n", total)
+	}
+	fmt.Println("════════════════════════════════════════════════════════════════")
+	if total > 0 {
+		return 1
+	}
+	return 0
+}
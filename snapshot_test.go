@@ -0,0 +1,106 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"md5checker/checksum"
+)
+
+// saveTestDatabase saves a database of files in root in the given format.
+func saveTestDatabase(t *testing.T, root string, files map[string]string, format string) {
+	t.Helper()
+	path := filepath.Join(root, checksum.DatabaseFileName)
+	if err := checksum.NewDatabase(files).SaveAs(path, format, nil, checksum.Selection{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotFormats(t *testing.T) {
+	root := t.TempDir()
+	opts := scanOptions{Options: checksum.Options{Root: root}}
+	files := map[string]string{"a.txt": "0cc175b9c0f1b6a831c399e269772661"}
+	saveTestDatabase(t, root, files, checksum.FormatBinary)
+
+	snapshot := func(args ...string) int {
+		return runSnapshot(append([]string{"-root", root}, args...))
+	}
+	snapshots := func() []string {
+		paths, err := listSnapshotFiles(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, path := range paths {
+			names = append(names, filepath.Base(path))
+		}
+		return names
+	}
+
+	if code := snapshot("create", "v1"); code != 0 {
+		t.Fatalf("snapshot create exited with %d", code)
+	}
+	if got, want := snapshots(), []string{"v1.bin"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("snapshots of a binary database = %v, want %v", got, want)
+	}
+	if code := snapshot("create", "v1"); code != 1 {
+		t.Errorf("creating an existing snapshot exited with %d, want 1", code)
+	}
+
+	// Overwriting it from a JSON database replaces the binary file
+	saveTestDatabase(t, root, files, checksum.FormatJSON)
+	if code := snapshot("-force", "create", "v1"); code != 0 {
+		t.Fatalf("snapshot create -force exited with %d", code)
+	}
+	if code := snapshot("create", "v2"); code != 0 {
+		t.Fatalf("snapshot create exited with %d", code)
+	}
+	if got, want := snapshots(), []string{"v1.json.gz", "v2.json.gz"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshots after overwriting = %v, want %v", got, want)
+	}
+	if path, err := resolveDatabase(opts, "v2"); err != nil || filepath.Base(path) != "v2.json.gz" {
+		t.Errorf("resolveDatabase(v2) = %s, %v", path, err)
+	}
+	if code := snapshot("list"); code != 0 {
+		t.Errorf("snapshot list exited with %d", code)
+	}
+
+	if code := snapshot("delete", "v1"); code != 0 {
+		t.Errorf("snapshot delete exited with %d", code)
+	}
+	if code := snapshot("delete", "v1"); code != 1 {
+		t.Errorf("deleting a missing snapshot exited with %d, want 1", code)
+	}
+	if got, want := snapshots(), []string{"v2.json.gz"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshots after delete = %v, want %v", got, want)
+	}
+}
+
+func TestDiffExitCode(t *testing.T) {
+	root := t.TempDir()
+	before := map[string]string{"a.txt": "0cc175b9c0f1b6a831c399e269772661", "b.txt": "92eb5ffee6ae2fec3ad71c777531578f"}
+	saveTestDatabase(t, root, before, checksum.FormatBinary)
+	if code := runSnapshot([]string{"-root", root, "create", "before"}); code != 0 {
+		t.Fatalf("snapshot create exited with %d", code)
+	}
+	after := map[string]string{"a.txt": "0cc175b9c0f1b6a831c399e269772661", "c.txt": "92eb5ffee6ae2fec3ad71c777531578f"}
+	saveTestDatabase(t, root, after, checksum.FormatJSON)
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"same database", []string{"current", "current"}, 0},
+		{"binary snapshot against current", []string{"before", "current"}, 1},
+		{"snapshot against itself", []string{"before", "before"}, 0},
+		{"unknown label", []string{"before", "missing"}, 2},
+		{"one side", []string{"before"}, 2},
+	}
+	for _, tt := range tests {
+		if got := runDiff(append([]string{"-root", root}, tt.args...)); got != tt.want {
+			t.Errorf("%s: diff exited with %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}